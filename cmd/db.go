@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffAgainst string
+	diffRows    bool
+	diffSQL     bool
+	diffOutput  string
+)
+
+// dbCmd groups the commands that work on agent databases
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Inspect and manage agent databases",
+	Long: `Commands that work on the databases of registered agents. An agent is
+named by its ID; "main" names database.main_url (or, for SQLite projects,
+database.path in the main repo).`,
+}
+
+// dbDiffCmd compares an agent's database with another database
+var dbDiffCmd = &cobra.Command{
+	Use:   "diff <agent>",
+	Short: "Compare an agent's database schema with main or another agent",
+	Long: `Compare the tables, columns, primary keys, foreign keys, indexes and
+constraints of an agent's database with those of the main database, or of
+another agent's with --against. Tables are matched by name.
+
+The report marks what the agent added with "+", what it dropped with "-" and
+what it changed with "~". --rows also compares the number of rows in each
+table. --sql writes SQL that would bring the --against database to the
+agent's schema instead; changes the engine cannot make in place, such as
+SQLite column type changes, are left as comments.
+
+Example:
+  agentenv db diff agent1
+  agentenv db diff agent1 --against agent2 --rows
+  agentenv db diff agent1 --sql --output migration.sql`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDBDiff,
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbDiffCmd)
+
+	dbDiffCmd.Flags().StringVar(&diffAgainst, "against", "main", "Database to compare with: main or an agent ID")
+	dbDiffCmd.Flags().BoolVar(&diffRows, "rows", false, "Also compare the number of rows in each table")
+	dbDiffCmd.Flags().BoolVar(&diffSQL, "sql", false, "Write a migration-style SQL diff instead of a report")
+	dbDiffCmd.Flags().StringVarP(&diffOutput, "output", "o", "", "Output file (default: stdout)")
+}
+
+func runDBDiff(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadConfigFromPath(".agentenv.yml")
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	agentID := args[0]
+	if agentID == diffAgainst {
+		return fmt.Errorf("cannot compare %s with itself", agentID)
+	}
+
+	// Keep stdout clean when it holds the SQL
+	if !diffSQL || diffOutput != "" {
+		fmt.Printf("🔍 Comparing the database of %s with %s...\n", agentID, diffAgainst)
+	}
+
+	opts := database.SchemaOptions{Rows: diffRows, DDL: diffSQL}
+	base, err := readDatabaseSchema(cmd, cfg, diffAgainst, opts)
+	if err != nil {
+		return err
+	}
+	target, err := readDatabaseSchema(cmd, cfg, agentID, opts)
+	if err != nil {
+		return err
+	}
+	diff := database.DiffSchemas(base, target)
+
+	var w io.Writer = os.Stdout
+	if diffOutput != "" {
+		f, err := os.Create(diffOutput)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if diffSQL {
+		if err := database.WriteMigration(w, cfg.Database.Type, diff); err != nil {
+			return err
+		}
+	} else {
+		database.WriteSchemaDiff(w, diff)
+	}
+
+	if diffOutput != "" {
+		fmt.Printf("✓ Diff written to %s\n", diffOutput)
+	}
+	return nil
+}
+
+// readDatabaseSchema reads the schema of the main database or an agent's
+func readDatabaseSchema(cmd *cobra.Command, cfg *config.Config, name string, opts database.SchemaOptions) (*database.DatabaseSchema, error) {
+	dbURL, err := databaseURL(cfg, name)
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := database.ReadSchema(cmd.Context(), cfg.Database.Type, dbURL, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the schema of %s: %w", name, err)
+	}
+	return schema, nil
+}
+
+// databaseURL returns the URL of the main database, or of a registered
+// agent's database
+func databaseURL(cfg *config.Config, name string) (string, error) {
+	if name == "main" {
+		return mainDatabaseURL(cfg)
+	}
+
+	conn, err := agentConnection(cfg, name)
+	if err != nil {
+		return "", err
+	}
+	return conn.URL(), nil
+}
@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/database"
+	"github.com/joshpurvis/agentenv/internal/docker"
+	"github.com/joshpurvis/agentenv/internal/registry"
+	"github.com/spf13/cobra"
+)
+
+// dbCheckpointCmd saves a copy of an agent's database
+var dbCheckpointCmd = &cobra.Command{
+	Use:   "checkpoint <agent> [name]",
+	Short: "Save a checkpoint of an agent's database",
+	Long: `Save a copy of an agent's database to roll back to later. Postgres
+checkpoints are template databases and MySQL checkpoints are database copies,
+both kept inside the agent's database container; SQLite checkpoints are files
+under .agentenv/checkpoints. Checkpoints are listed in the registry and
+removed by 'agentenv down'.
+
+The name defaults to the current time. Saving a checkpoint under an existing
+name replaces it. Postgres can only copy a database nobody is connected to,
+so the agent's open connections are closed.
+
+Example:
+  agentenv db checkpoint agent1
+  agentenv db checkpoint agent1 before_migration`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runDBCheckpoint,
+}
+
+// dbRollbackCmd restores a checkpoint
+var dbRollbackCmd = &cobra.Command{
+	Use:   "rollback <agent> [name]",
+	Short: "Restore an agent's database from a checkpoint",
+	Long: `Replace an agent's database with a checkpoint, the latest one if no name
+is given. The checkpoint is kept, so the database can be rolled back to it
+again. Open connections to the agent's database are closed.
+
+Example:
+  agentenv db rollback agent1
+  agentenv db rollback agent1 before_migration`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: runDBRollback,
+}
+
+// dbCheckpointsCmd lists checkpoints
+var dbCheckpointsCmd = &cobra.Command{
+	Use:   "checkpoints <agent>",
+	Short: "List the checkpoints of an agent's database",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runDBCheckpoints,
+}
+
+func init() {
+	dbCmd.AddCommand(dbCheckpointCmd)
+	dbCmd.AddCommand(dbRollbackCmd)
+	dbCmd.AddCommand(dbCheckpointsCmd)
+}
+
+func runDBCheckpoint(cmd *cobra.Command, args []string) error {
+	agentID := args[0]
+	name := time.Now().Format("20060102_150405")
+	if len(args) > 1 {
+		name = args[1]
+	}
+	if err := database.ValidateCheckpointName(name); err != nil {
+		return err
+	}
+
+	loaded, err := loadAgent(agentID)
+	if err != nil {
+		return err
+	}
+	checkpointer, err := agentCheckpointer(loaded.cfg, loaded.agent, loaded.reg.Project)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("📸 Checkpointing the database of %s as %s...\n", agentID, name)
+	location := checkpointer.Location(name)
+	if err := checkpointer.Create(location); err != nil {
+		return fmt.Errorf("failed to create checkpoint: %w", err)
+	}
+
+	loaded.agent.SetCheckpoint(registry.Checkpoint{Name: name, Location: location, CreatedAt: time.Now()})
+	if err := loaded.reg.Save(); err != nil {
+		return fmt.Errorf("failed to save registry: %w", err)
+	}
+
+	fmt.Printf("✓ Checkpoint %s saved (%s)\n", name, location)
+	return nil
+}
+
+func runDBRollback(cmd *cobra.Command, args []string) error {
+	agentID := args[0]
+	name := ""
+	if len(args) > 1 {
+		name = args[1]
+	}
+
+	loaded, err := loadAgent(agentID)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := loaded.agent.GetCheckpoint(name)
+	if err != nil {
+		return err
+	}
+	checkpointer, err := agentCheckpointer(loaded.cfg, loaded.agent, loaded.reg.Project)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("⏪ Rolling back the database of %s to %s...\n", agentID, checkpoint.Name)
+	if err := checkpointer.Rollback(checkpoint.Location); err != nil {
+		return fmt.Errorf("failed to roll back: %w", err)
+	}
+
+	fmt.Printf("✓ Database rolled back to %s (saved %s)\n", checkpoint.Name, checkpoint.CreatedAt.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+func runDBCheckpoints(cmd *cobra.Command, args []string) error {
+	loaded, err := loadAgent(args[0])
+	if err != nil {
+		return err
+	}
+	agent := loaded.agent
+
+	if len(agent.Checkpoints) == 0 {
+		fmt.Printf("No checkpoints for %s\n", args[0])
+		return nil
+	}
+
+	fmt.Printf("Checkpoints of %s (oldest first):\n", args[0])
+	for _, checkpoint := range agent.Checkpoints {
+		fmt.Printf("  %-24s %s  %s\n", checkpoint.Name, checkpoint.CreatedAt.Format("2006-01-02 15:04:05"), checkpoint.Location)
+	}
+	return nil
+}
+
+// loadedAgent is an agent with the config and registry it was loaded from
+type loadedAgent struct {
+	cfg   *config.Config
+	reg   *registry.Registry
+	agent *registry.Agent
+}
+
+// loadAgent loads the config, the registry and one of its agents
+func loadAgent(agentID string) (*loadedAgent, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	reg, err := registry.LoadRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry: %w", err)
+	}
+
+	agent, err := reg.GetAgent(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("agent not found: %w", err)
+	}
+
+	return &loadedAgent{cfg: cfg, reg: reg, agent: agent}, nil
+}
+
+// agentCheckpointer creates a checkpointer for an agent's database, which
+// runs its commands in the agent's database service
+func agentCheckpointer(cfg *config.Config, agent *registry.Agent, projectName string) (*database.Checkpointer, error) {
+	conn, err := database.AgentConnection(cfg, agent, projectName)
+	if err != nil {
+		return nil, err
+	}
+
+	// MySQL checkpoints are new databases, which MYSQL_USER may not create
+	if conn.Type == "mysql" || conn.Type == "mariadb" {
+		dbEnv, err := docker.ServiceEnvironment(cfg, agent, cfg.Database.Service)
+		if err != nil {
+			return nil, err
+		}
+		if rootPassword := dbEnv["MYSQL_ROOT_PASSWORD"]; rootPassword != "" {
+			conn.User, conn.Password = "root", rootPassword
+		}
+	}
+
+	// SQLite checkpoint paths are saved in the registry, so they are made
+	// absolute to work from any directory
+	stateDir, err := registry.Dir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate the registry: %w", err)
+	}
+	dir := filepath.Join(stateDir, "checkpoints", agent.Name)
+	return database.NewCheckpointer(conn, databaseService(cfg, agent), dir), nil
+}
+
+// databaseService returns an agent's database service
+func databaseService(cfg *config.Config, agent *registry.Agent) docker.Service {
+	return docker.Service{
+		Dir:          agent.WorktreePath,
+		ComposeFile:  cfg.Docker.ComposeFile,
+		OverrideFile: agent.DockerComposeOverride,
+		Name:         cfg.Database.Service,
+	}
+}
+
+// removeCheckpoints drops every checkpoint of an agent, returning the first
+// error after trying them all
+func removeCheckpoints(cfg *config.Config, agent *registry.Agent, projectName string) error {
+	checkpointer, err := agentCheckpointer(cfg, agent, projectName)
+	if err != nil {
+		return err
+	}
+
+	var firstErr error
+	for _, checkpoint := range agent.Checkpoints {
+		if err := checkpointer.Drop(checkpoint.Location); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to drop checkpoint %s: %w", checkpoint.Name, err)
+		}
+	}
+	return firstErr
+}
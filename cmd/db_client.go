@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/joshpurvis/agentenv/internal/database"
+	"github.com/spf13/cobra"
+)
+
+var (
+	urlFormat         string
+	clientInContainer bool
+)
+
+// dbURLCmd prints the connection details of an agent's database
+var dbURLCmd = &cobra.Command{
+	Use:   "url <agent>",
+	Short: "Print the connection URL of an agent's database",
+	Long: `Print how to connect to an agent's database, with the port, database name
+and credentials resolved from the agent's environment.
+
+--format selects the output: url (the default), env (DATABASE_URL and the
+variables the engine's client reads, as shell assignments), json, or jdbc
+(without the password, which JDBC tools take separately).
+
+Example:
+  agentenv db url agent1
+  eval "$(agentenv db url agent1 --format env)"
+  agentenv db url agent1 --format jdbc`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDBURL,
+}
+
+// dbClientCmd opens an interactive client on an agent's database
+var dbClientCmd = &cobra.Command{
+	Use:     "psql <agent> [-- client args...]",
+	Aliases: []string{"shell"},
+	Short:   "Open an interactive client on an agent's database",
+	Long: `Open the engine's interactive client (psql, mysql or sqlite3) connected to
+an agent's database. The client installed on the host is used when there is
+one; otherwise, or with --container, it runs inside the agent's database
+container. Arguments after -- are passed to the client.
+
+Example:
+  agentenv db psql agent1
+  agentenv db shell agent1 --container
+  agentenv db psql agent1 -- -c "SELECT count(*) FROM users"`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runDBClient,
+}
+
+func init() {
+	dbCmd.AddCommand(dbURLCmd)
+	dbCmd.AddCommand(dbClientCmd)
+
+	dbURLCmd.Flags().StringVar(&urlFormat, "format", "url", "Output format: url, env, json or jdbc")
+	dbClientCmd.Flags().BoolVar(&clientInContainer, "container", false, "Run the client inside the database container")
+}
+
+// connectionJSON is the --format json output of db url
+type connectionJSON struct {
+	Type     string `json:"type"`
+	Host     string `json:"host,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Database string `json:"database,omitempty"`
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	Path     string `json:"path,omitempty"`
+	URL      string `json:"url"`
+}
+
+func runDBURL(cmd *cobra.Command, args []string) error {
+	loaded, err := loadAgent(args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := database.AgentConnection(loaded.cfg, loaded.agent, loaded.reg.Project)
+	if err != nil {
+		return err
+	}
+
+	switch urlFormat {
+	case "url":
+		fmt.Println(conn.URL())
+	case "jdbc":
+		fmt.Println(conn.JDBCURL())
+	case "env":
+		for _, variable := range conn.Variables() {
+			key, value, _ := strings.Cut(variable, "=")
+			fmt.Printf("export %s=%s\n", key, shellQuote(value))
+		}
+	case "json":
+		data, err := json.MarshalIndent(connectionJSON{
+			Type:     conn.Type,
+			Host:     conn.Host,
+			Port:     conn.Port,
+			Database: conn.Name,
+			User:     conn.User,
+			Password: conn.Password,
+			Path:     conn.Path,
+			URL:      conn.URL(),
+		}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal connection: %w", err)
+		}
+		fmt.Println(string(data))
+	default:
+		return fmt.Errorf("unknown format %q: use url, env, json or jdbc", urlFormat)
+	}
+	return nil
+}
+
+func runDBClient(cmd *cobra.Command, args []string) error {
+	loaded, err := loadAgent(args[0])
+	if err != nil {
+		return err
+	}
+	conn, err := database.AgentConnection(loaded.cfg, loaded.agent, loaded.reg.Project)
+	if err != nil {
+		return err
+	}
+
+	// Fall back to the container's client when the host has none
+	inContainer := clientInContainer
+	client, err := conn.ClientCommand(inContainer)
+	if err != nil {
+		return err
+	}
+	if _, lookErr := exec.LookPath(client.Command); lookErr != nil && !inContainer {
+		if conn.Type == "sqlite" {
+			return fmt.Errorf("%s is not installed: %w", client.Command, lookErr)
+		}
+		inContainer = true
+		if client, err = conn.ClientCommand(inContainer); err != nil {
+			return err
+		}
+	}
+	clientArgs := append(append([]string{client.Command}, client.Args...), args[1:]...)
+
+	if inContainer {
+		return databaseService(loaded.cfg, loaded.agent).Attach(client.Env, clientArgs...)
+	}
+
+	command := exec.Command(clientArgs[0], clientArgs[1:]...)
+	command.Env = append(os.Environ(), client.Env...)
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", client.Command, err)
+	}
+	return nil
+}
+
+// shellQuote quotes a value for a POSIX shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
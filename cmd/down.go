@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/database"
 	"github.com/joshpurvis/agentenv/internal/git"
 	"github.com/joshpurvis/agentenv/internal/registry"
 	"github.com/spf13/cobra"
@@ -22,6 +23,7 @@ var downCmd = &cobra.Command{
 
 This will:
 - Archive the database (if configured)
+- Remove database checkpoints
 - Stop Docker services
 - Remove volumes
 - Remove git worktree
@@ -82,7 +84,7 @@ func runDown(cmd *cobra.Command, args []string) error {
 	if cfg.Cleanup.ArchiveDatabase && !skipArchive {
 		fmt.Println("💾 Archiving database...")
 		cleanupLog.WriteString("Step 1: Archive database\n")
-		if err := archiveDatabase(cfg, agent, agentID, agent.PortSlot, reg.Project, verbose); err != nil {
+		if err := archiveDatabase(cfg, agent, agentID, reg.Project, verbose); err != nil {
 			fmt.Printf("  ⚠️  Warning: failed to archive database: %v\n", err)
 			cleanupLog.WriteString(fmt.Sprintf("  Status: FAILED - %v\n\n", err))
 			// Continue anyway
@@ -95,9 +97,25 @@ func runDown(cmd *cobra.Command, args []string) error {
 		cleanupLog.WriteString("  Status: SKIPPED\n\n")
 	}
 
-	// 5. Stop Docker services
+	// 5. Remove database checkpoints, while the database service still runs
+	cleanupLog.WriteString("Step 2: Remove database checkpoints\n")
+	if len(agent.Checkpoints) > 0 {
+		fmt.Println("\n📸 Removing database checkpoints...")
+		if err := removeCheckpoints(cfg, agent, reg.Project); err != nil {
+			fmt.Printf("  ⚠️  Warning: %v\n", err)
+			cleanupLog.WriteString(fmt.Sprintf("  Status: FAILED - %v\n\n", err))
+			// Continue anyway
+		} else {
+			fmt.Printf("✓ %d checkpoint(s) removed\n", len(agent.Checkpoints))
+			cleanupLog.WriteString("  Status: SUCCESS\n\n")
+		}
+	} else {
+		cleanupLog.WriteString("  Status: SKIPPED (no checkpoints)\n\n")
+	}
+
+	// 6. Stop Docker services
 	fmt.Println("\n🐳 Stopping Docker services...")
-	cleanupLog.WriteString("Step 2: Stop Docker services\n")
+	cleanupLog.WriteString("Step 3: Stop Docker services\n")
 	if err := stopDockerServices(cfg, agent, verbose); err != nil {
 		fmt.Printf("  ⚠️  Warning: failed to stop services: %v\n", err)
 		cleanupLog.WriteString(fmt.Sprintf("  Status: FAILED - %v\n\n", err))
@@ -107,10 +125,10 @@ func runDown(cmd *cobra.Command, args []string) error {
 		cleanupLog.WriteString("  Status: SUCCESS\n\n")
 	}
 
-	// 6. Remove volumes (if enabled)
+	// 7. Remove volumes (if enabled)
 	if cfg.Cleanup.RemoveVolumes {
 		fmt.Println("\n🗑️  Removing volumes...")
-		cleanupLog.WriteString("Step 3: Remove volumes\n")
+		cleanupLog.WriteString("Step 4: Remove volumes\n")
 		if err := removeVolumes(cfg, agent, verbose); err != nil {
 			fmt.Printf("  ⚠️  Warning: failed to remove volumes: %v\n", err)
 			cleanupLog.WriteString(fmt.Sprintf("  Status: FAILED - %v\n\n", err))
@@ -120,16 +138,16 @@ func runDown(cmd *cobra.Command, args []string) error {
 			cleanupLog.WriteString("  Status: SUCCESS\n\n")
 		}
 	} else {
-		cleanupLog.WriteString("Step 3: Remove volumes\n")
+		cleanupLog.WriteString("Step 4: Remove volumes\n")
 		cleanupLog.WriteString("  Status: SKIPPED\n\n")
 	}
 
-	// 7. Fix file permissions (Docker containers may create root-owned files)
+	// 8. Fix file permissions (Docker containers may create root-owned files)
 	if !keepWorktree {
 		if verbose {
 			fmt.Println("\n🔧 Fixing file permissions...")
 		}
-		cleanupLog.WriteString("Step 4: Fix file permissions\n")
+		cleanupLog.WriteString("Step 5: Fix file permissions\n")
 		// Use Docker to fix permissions (runs as root, can chown everything)
 		cmd := exec.Command("docker", "run", "--rm", "-v", fmt.Sprintf("%s:/workspace", agent.WorktreePath),
 			"alpine", "sh", "-c", "chmod -R 777 /workspace || true")
@@ -143,10 +161,10 @@ func runDown(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// 8. Remove git worktree
+	// 9. Remove git worktree
 	if !keepWorktree {
 		fmt.Printf("\n📂 Removing git worktree at %s...\n", agent.WorktreePath)
-		cleanupLog.WriteString("Step 5: Remove git worktree\n")
+		cleanupLog.WriteString("Step 6: Remove git worktree\n")
 		cleanupLog.WriteString(fmt.Sprintf("  Path: %s\n", agent.WorktreePath))
 		if err := git.RemoveWorktree(repoPath, agent.WorktreePath, true); err != nil {
 			fmt.Printf("  ⚠️  Warning: failed to remove worktree: %v\n", err)
@@ -157,12 +175,12 @@ func runDown(cmd *cobra.Command, args []string) error {
 			cleanupLog.WriteString("  Status: SUCCESS\n\n")
 		}
 	} else {
-		cleanupLog.WriteString("Step 5: Remove git worktree\n")
+		cleanupLog.WriteString("Step 6: Remove git worktree\n")
 		cleanupLog.WriteString("  Status: SKIPPED (--keep-worktree flag)\n\n")
 	}
 
-	// 9. Update registry
-	cleanupLog.WriteString("Step 6: Update registry\n")
+	// 10. Update registry
+	cleanupLog.WriteString("Step 7: Update registry\n")
 	if err := reg.RemoveAgent(agentID); err != nil {
 		cleanupLog.WriteString(fmt.Sprintf("  Status: FAILED - %v\n\n", err))
 		return fmt.Errorf("failed to remove agent from registry: %w", err)
@@ -173,7 +191,7 @@ func runDown(cmd *cobra.Command, args []string) error {
 	}
 	cleanupLog.WriteString("  Status: SUCCESS\n\n")
 
-	// 9. Save cleanup log
+	// 10. Save cleanup log
 	if err := os.MkdirAll(cfg.Cleanup.ArchiveLocation, 0755); err == nil {
 		timestamp := time.Now().Format("20060102-150405")
 		logFile := filepath.Join(cfg.Cleanup.ArchiveLocation,
@@ -189,74 +207,28 @@ func runDown(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-func archiveDatabase(cfg *config.Config, agent *registry.Agent, agentID string, numericID int, projectName string, verbose bool) error {
+func archiveDatabase(cfg *config.Config, agent *registry.Agent, agentID string, projectName string, verbose bool) error {
 	// Create archive directory if it doesn't exist
 	if err := os.MkdirAll(cfg.Cleanup.ArchiveLocation, 0755); err != nil {
 		return fmt.Errorf("failed to create archive directory: %w", err)
 	}
 
+	// Get database connection info
+	conn, err := database.AgentConnection(cfg, agent, projectName)
+	if err != nil {
+		return err
+	}
+
 	// Generate archive filename
 	timestamp := time.Now().Format("20060102-150405")
 	archiveFile := filepath.Join(cfg.Cleanup.ArchiveLocation,
-		fmt.Sprintf("%s-%s.sql", agentID, timestamp))
+		fmt.Sprintf("%s-%s%s", agentID, timestamp, conn.ArchiveExtension()))
 
-	// Get database connection info
-	dbService := cfg.Database.Service
-	dbPort, ok := agent.Ports[dbService]
-	if !ok {
-		return fmt.Errorf("database service %s not found in agent ports", dbService)
+	if err := database.Archive(conn, archiveFile, verbose); err != nil {
+		return err
 	}
 
-	// For PostgreSQL
-	if cfg.Database.Type == "postgresql" {
-		// Parse database name from environment
-		dbEnv := cfg.Docker.Services[dbService].Environment
-		dbName := dbEnv["POSTGRES_DB"]
-		if dbName == "" {
-			dbName = fmt.Sprintf("%s_agent%d", projectName, numericID)
-		}
-		// Replace template variables in database name
-		dbName = strings.ReplaceAll(dbName, "{id}", fmt.Sprintf("%d", numericID))
-
-		// Extract username and password from environment or use defaults
-		dbUser := dbEnv["POSTGRES_USER"]
-		if dbUser == "" {
-			dbUser = "postgres"
-		}
-		dbPassword := dbEnv["POSTGRES_PASSWORD"]
-		if dbPassword == "" {
-			dbPassword = "postgres"
-		}
-
-		// Run pg_dump
-		cmd := exec.Command("pg_dump",
-			"-h", "localhost",
-			"-p", fmt.Sprintf("%d", dbPort),
-			"-U", dbUser,
-			"-d", dbName,
-			"-f", archiveFile)
-
-		// Set PGPASSWORD environment variable
-		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", dbPassword))
-
-		// Capture output for error reporting
-		var stderr strings.Builder
-		if verbose {
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-		} else {
-			cmd.Stderr = &stderr
-		}
-
-		if err := cmd.Run(); err != nil {
-			if stderr.Len() > 0 {
-				return fmt.Errorf("pg_dump failed: %w\nOutput: %s", err, stderr.String())
-			}
-			return fmt.Errorf("pg_dump failed: %w", err)
-		}
-
-		fmt.Printf("  Archive saved to: %s\n", archiveFile)
-	}
+	fmt.Printf("  Archive saved to: %s\n", archiveFile)
 
 	return nil
 }
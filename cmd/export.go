@@ -1,125 +1,676 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"strconv"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/joshpurvis/agentenv/internal/config"
 	"github.com/joshpurvis/agentenv/internal/database"
+	"github.com/joshpurvis/agentenv/internal/git"
+	"github.com/joshpurvis/agentenv/internal/registry"
 	"github.com/spf13/cobra"
 )
 
 var (
 	exportOutputFile string
+	exportOptions    database.ExportOptions
+	exportWhere      string
+	exportIDs        []string
+	exportLimit      int
+	exportFormat     string
+	exportInto       string
+	exportFrom       string
+	exportRemap      database.RemapOptions
+	exportIDMap      string
+	exportWithSchema bool
+	exportPlan       bool
+	exportGraph      string
+	exportGraphRows  bool
+
+	// exportStatus receives progress and the summary: stderr when the
+	// export itself is written to stdout, so that it stays parseable
+	exportStatus io.Writer = os.Stdout
 )
 
 // exportCmd represents the export command
 var exportCmd = &cobra.Command{
-	Use:   "export <table> <id>",
+	Use:   "export <table> [key]",
 	Short: "Export database records with dependencies",
-	Long: `Export a database record and all its dependencies to SQL file.
+	Long: `Export database records and all their dependencies to SQL file.
 
 This tool recursively exports a record by following foreign key relationships,
 ensuring all dependent data is included. The output SQL can be imported into
-agent databases for testing.`,
+agent databases for testing.
+
+The table may be schema-qualified (schema.table); otherwise the database's
+default schema is used. Foreign keys are followed across schemas.
+
+The key is the record's primary key value. For composite primary keys, name
+each column as column=value, separated by commas.
+
+Instead of (or as well as) a key, root rows can be selected with --ids, a
+comma-separated list of primary key values, and --where, an SQL condition on
+the table. For composite keys, repeat --ids once per key, each written like
+the key argument (--ids order_id=5,line=2 --ids order_id=5,line=3). --limit caps the rows selected by --where, in primary key order.
+All roots share one traversal, so rows they have in common are exported once.
+
+With --children, rows that reference exported rows are exported too, such as
+a report's sections and comments, or the rows of many-to-many join tables.
+Children are followed only downwards from the root: the parents of a child
+row are exported, but not the other children of those parents.
+
+--format selects the output: sql-insert (the default), sql-copy (one COPY
+block per table, PostgreSQL only, for loading into empty tables), json (an
+array of records with typed values and base64 binary columns, in dependency
+order) or csv (a directory with one file per table and a manifest.json
+listing them in load order, with NULL as \N and binary columns as base64).
+Progress and the summary go to stderr when the export is written to stdout.
+
+With --into, the export is imported straight into a running agent's database
+in one transaction, skipping rows that already exist. Any error rolls the
+whole import back.
+
+With --from, records are exported from an agent's database instead of
+database.main_url, for example to share a row that reproduces a bug.
+Combined with --into, record graphs are copied from one agent to another.
+
+With --remap-ids, exported rows get fresh primary keys, so that they are
+not skipped as duplicates of rows already in the target: "offset" adds
+--id-offset to integer keys, "uuid" generates new UUIDs, and "sequence" takes
+values from the target database's sequences (requires --into). Foreign keys
+inside the export are rewritten to match, and the old and new keys are
+listed in the summary or written as JSON to --id-map.
+
+With --plan, nothing is exported: the foreign key graph is walked from the
+table under the same options, and the tables it reaches are listed with the
+foreign keys followed and their estimated row counts (the root rows are
+counted, other tables report their size). --graph writes the same tables and
+the foreign keys between them as a dot or mermaid graph, with reference
+cycles in red, to --output or stdout; with --graph-rows, the export runs and
+its rows are graphed instead.
+
+With --with-schema, the output starts with CREATE TABLE IF NOT EXISTS
+statements for every exported table, generated from the catalog with their
+enum types, sequences, keys and checks, so that the export loads into an
+empty database. Tables are created in dependency order; foreign keys in
+cycles are added once all tables exist.
+
+Records are written as soon as the records they reference are, so large
+exports are not held in memory; only rows waiting for their parents are
+buffered, and --max-buffered fails the export if too many are. (sql-copy
+collects each table's rows until the end, and --remap-ids and --with-schema
+need the whole export before writing it.) Interrupting an export rolls back --into imports
+and ends SQL output with ROLLBACK.
+
+Columns listed under database.export.masking in .agentenv.yml are masked in
+the output, and foreign keys to masked columns are masked the same way.`,
 	Example: `  agentenv export report 123 --output test-report.sql
-  agentenv export user 1 --output test-user.sql`,
-	Args: cobra.ExactArgs(2),
+  agentenv export user 1 --output test-user.sql
+  agentenv export order_items order_id=5,line=2
+  agentenv export billing.invoices 42
+  agentenv export users --ids 1,2,3
+  agentenv export orders --where "created_at > now() - interval '1 day'" --limit 50
+  agentenv export report 123 --children --child-depth 2 --exclude-tables audit_log
+  agentenv export report 123 --into claude1
+  agentenv export report 123 --from claude1 --into claude2
+  agentenv export report 123 --format json --output report.json
+  agentenv export report 123 --format csv --output fixtures/report
+  agentenv export report 123 --remap-ids offset --id-offset 900000 --id-map ids.json
+  agentenv export report 123 --into claude1 --remap-ids sequence
+  agentenv export report 123 --with-schema --output repro.sql
+  agentenv export report 123 --children --plan
+  agentenv export report 123 --children --graph dot --output report.dot`,
+	Args: cobra.RangeArgs(1, 2),
 	Run:  runExport,
 }
 
 func init() {
 	rootCmd.AddCommand(exportCmd)
 
-	exportCmd.Flags().StringVarP(&exportOutputFile, "output", "o", "", "Output file for SQL export (default: stdout)")
+	exportCmd.Flags().StringVarP(&exportOutputFile, "output", "o", "", "Output file, or directory for csv (default: stdout)")
+	exportCmd.Flags().StringVar(&exportFrom, "from", "", "Export from this agent's database instead of database.main_url")
+	exportCmd.Flags().StringVar(&exportInto, "into", "", "Import into this agent's database instead of writing a file")
+	exportCmd.Flags().StringVar(&exportFormat, "format", database.FormatSQLInsert, "Output format: sql-insert, sql-copy, json or csv")
+	exportCmd.Flags().BoolVar(&exportOptions.IncludeChildren, "children", false, "Also export rows that reference exported rows")
+	exportCmd.Flags().IntVar(&exportOptions.MaxDepth, "depth", 0, "Maximum foreign key hops from the root (0: unlimited)")
+	exportCmd.Flags().IntVar(&exportOptions.MaxParentDepth, "parent-depth", 0, "Maximum hops to referenced rows (0: unlimited)")
+	exportCmd.Flags().IntVar(&exportOptions.MaxChildDepth, "child-depth", 0, "Maximum hops to referencing rows (0: unlimited)")
+	exportCmd.Flags().StringSliceVar(&exportOptions.IncludeTables, "include-tables", nil, "Only follow foreign keys into these tables")
+	exportCmd.Flags().StringSliceVar(&exportOptions.ExcludeTables, "exclude-tables", nil, "Never follow foreign keys into these tables")
+	exportCmd.Flags().StringVar(&exportWhere, "where", "", "SQL condition selecting root rows")
+	exportCmd.Flags().StringArrayVar(&exportIDs, "ids", nil, "Primary key values of root rows, comma-separated, or one column=value,... composite key (repeatable)")
+	exportCmd.Flags().IntVar(&exportLimit, "limit", 0, "Maximum number of rows selected by --where (0: unlimited)")
+	exportCmd.Flags().IntVar(&exportOptions.MaxRows, "max-rows", 0, "Fail if the export exceeds this many rows (0: unlimited)")
+	exportCmd.Flags().IntVar(&exportOptions.MaxBufferedRows, "max-buffered", 0, "Fail if more rows than this wait in memory for their parents (0: unlimited)")
+	exportCmd.Flags().StringVar(&exportRemap.Strategy, "remap-ids", "", "Give exported rows fresh primary keys: offset, uuid or sequence")
+	exportCmd.Flags().Int64Var(&exportRemap.Offset, "id-offset", 1000000, "Offset added to integer keys by --remap-ids offset")
+	exportCmd.Flags().StringVar(&exportIDMap, "id-map", "", "Write the old and new keys of --remap-ids to this JSON file")
+	exportCmd.Flags().BoolVar(&exportWithSchema, "with-schema", false, "Create the exported tables if they don't exist before inserting")
+	exportCmd.Flags().BoolVar(&exportPlan, "plan", false, "Print the tables, foreign keys and estimated rows the export would touch, without exporting")
+	exportCmd.Flags().StringVar(&exportGraph, "graph", "", "Write the table graph of the export instead of its rows: dot or mermaid")
+	exportCmd.Flags().BoolVar(&exportGraphRows, "graph-rows", false, "With --graph, run the export and graph its rows instead of its tables")
 }
 
 func runExport(cmd *cobra.Command, args []string) {
-	table := args[0]
-	idStr := args[1]
+	if err := exportRecords(cmd, args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
 
-	// Try to parse ID as integer, fall back to string
-	var id interface{}
-	if idInt, err := strconv.Atoi(idStr); err == nil {
-		id = idInt
-	} else {
-		id = idStr
+// exportJob is a validated export: where its rows come from, how they are
+// masked and, with --into, the agent database they are imported into
+type exportJob struct {
+	cfg      *config.Config
+	table    database.TableName
+	roots    database.Roots
+	masker   *database.Masker
+	conn     *database.Connection
+	exporter *database.Exporter
+	importer *database.Importer
+}
+
+// exportRecords runs the export, or describes it with --plan and --graph
+func exportRecords(cmd *cobra.Command, args []string) error {
+	if err := validateExportFlags(cmd); err != nil {
+		return err
+	}
+	job, err := newExportJob(args)
+	if err != nil {
+		return err
+	}
+	if exportInto == "" && exportOutputFile == "" {
+		exportStatus = os.Stderr
+	}
+
+	// Stop the export on Ctrl-C, rolling back what was written
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	sourceURL, err := exportSourceURL(job.cfg)
+	if err != nil {
+		return err
+	}
+
+	// Create exporter
+	fmt.Fprintf(exportStatus, "Connecting to database...\n")
+	job.exporter, err = database.NewExporter(ctx, job.cfg.Database.Type, sourceURL)
+	if err != nil {
+		return err
+	}
+	defer job.exporter.Close()
+	job.exporter.SetProgress(os.Stderr)
+
+	// Describe the export instead of running it
+	if exportPlan || exportGraph != "" {
+		return describeExport(ctx, job.exporter, job.roots)
+	}
+
+	if err := job.run(ctx); err != nil {
+		return err
+	}
+	job.printSummary()
+	return nil
+}
+
+// validateExportFlags checks the flags that cannot be used, or combined,
+// before anything is loaded
+func validateExportFlags(cmd *cobra.Command) error {
+	switch {
+	case !slices.Contains(database.Formats, exportFormat):
+		return fmt.Errorf("unknown format %q (expected one of: %s)", exportFormat, strings.Join(database.Formats, ", "))
+	case exportInto != "" && (exportOutputFile != "" || cmd.Flags().Changed("format")):
+		return fmt.Errorf("--into cannot be combined with --output or --format")
+	case exportFrom != "" && exportFrom == exportInto:
+		return fmt.Errorf("--from and --into name the same agent")
+	case exportFormat == database.FormatCSV && exportOutputFile == "":
+		return fmt.Errorf("--format csv requires --output <directory>")
+	case exportRemap.Strategy != "" && !slices.Contains(database.RemapStrategies, exportRemap.Strategy):
+		return fmt.Errorf("unknown --remap-ids strategy %q (expected one of: %s)", exportRemap.Strategy, strings.Join(database.RemapStrategies, ", "))
+	case exportRemap.Strategy == database.RemapSequence && exportInto == "":
+		return fmt.Errorf("--remap-ids sequence requires --into")
+	case exportWithSchema && (exportFormat == database.FormatJSON || exportFormat == database.FormatCSV):
+		return fmt.Errorf("--with-schema requires an SQL format or --into")
+	case exportGraph != "" && !slices.Contains(database.GraphFormats, exportGraph):
+		return fmt.Errorf("unknown --graph format %q (expected one of: %s)", exportGraph, strings.Join(database.GraphFormats, ", "))
+	case exportGraphRows && exportGraph == "":
+		return fmt.Errorf("--graph-rows requires --graph")
+	case (exportPlan || exportGraph != "") && exportInto != "":
+		return fmt.Errorf("--plan and --graph cannot be combined with --into")
+	case exportIDMap != "" && exportRemap.Strategy == "":
+		return fmt.Errorf("--id-map requires --remap-ids")
+	}
+	return nil
+}
+
+// newExportJob parses the root rows and loads the configuration, masking
+// rules and --into connection of an export
+func newExportJob(args []string) (*exportJob, error) {
+	// Parse the table name, optionally schema-qualified
+	table, err := database.ParseTableName(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the root rows from the key argument, --ids and --where
+	roots, err := exportRoots(table, args[1:])
+	if err != nil {
+		return nil, err
 	}
 
 	// Load configuration to get database URL
 	cfg, err := config.LoadConfigFromPath(".agentenv.yml")
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to load .agentenv.yml: %v\n", err)
-		fmt.Fprintf(os.Stderr, "\nMake sure you're running this command from a directory with .agentenv.yml\n")
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to load .agentenv.yml: %w\n\nMake sure you're running this command from a directory with .agentenv.yml", err)
 	}
 
-	if cfg.Database.MainURL == "" {
-		fmt.Fprintf(os.Stderr, "Error: database.main_url not configured in .agentenv.yml\n")
-		os.Exit(1)
+	// Validate masking rules before connecting
+	masker, err := database.NewMasker(cfg.Database.Export)
+	if err != nil {
+		return nil, fmt.Errorf("invalid database.export.masking: %w", err)
 	}
 
-	// Create exporter
-	fmt.Printf("Connecting to database...\n")
-	exporter, err := database.NewExporter(cfg.Database.MainURL)
+	job := &exportJob{cfg: cfg, table: table, roots: roots, masker: masker}
+	if exportInto != "" {
+		if job.conn, err = agentConnection(cfg, exportInto); err != nil {
+			return nil, err
+		}
+	}
+	exportRemap.Target = job.conn
+	return job, nil
+}
+
+// run exports the records into the output or the --into import. Nothing
+// is left behind if it fails.
+func (j *exportJob) run(ctx context.Context) error {
+	// --remap-ids and --with-schema need the whole export before anything
+	// is written
+	fmt.Fprintf(exportStatus, "Exporting %s records...\n", j.table)
+	collect := exportRemap.Strategy != "" || exportWithSchema
+	var collected []database.Record
+	if collect {
+		var err error
+		if collected, err = collectRecords(ctx, j.exporter, j.roots); err != nil {
+			return err
+		}
+	}
+
+	writer, err := j.openWriter()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		return err
 	}
-	defer exporter.Close()
 
-	// Export records
-	fmt.Printf("Exporting %s record with id=%v...\n", table, id)
-	records, err := exporter.Export(table, id)
+	// Export records, or write the collected ones
+	output := j.exporter.FollowMasking(j.masker, writer)
+	if collect {
+		err = writeRecords(output, collected)
+	} else {
+		err = j.exporter.ExportTo(ctx, j.roots, exportOptions, output)
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		writer.Abort()
+		return err
+	}
+	if len(j.exporter.Tables()) == 0 {
+		writer.Abort()
+		return fmt.Errorf("no records found")
 	}
 
-	if len(records) == 0 {
-		fmt.Fprintf(os.Stderr, "Error: no records found\n")
-		os.Exit(1)
+	if err := writer.Close(); err != nil {
+		if j.importer != nil {
+			return fmt.Errorf("import failed and was rolled back: %w", err)
+		}
+		return fmt.Errorf("failed to write export: %w", err)
 	}
+	return nil
+}
 
-	fmt.Printf("✓ Found %d record(s) (including dependencies)\n", len(records))
+// openWriter opens the import or output that records are streamed into
+func (j *exportJob) openWriter() (database.RecordWriter, error) {
+	if j.conn == nil {
+		return openOutput(j.cfg, j.masker, j.exporter.LoadSteps())
+	}
 
-	// Summarize what was exported
-	tableCounts := make(map[string]int)
-	for _, record := range records {
-		tableCounts[record.Table]++
+	fmt.Fprintf(exportStatus, "\n💾 Importing into %s...\n", j.conn.Name)
+	importer, err := database.NewImporter(j.conn, j.exporter.LoadSteps(), j.masker)
+	if err != nil {
+		return nil, err
 	}
+	j.importer = importer
+	return importer, nil
+}
 
-	fmt.Println("\nExport summary:")
-	for table, count := range tableCounts {
-		fmt.Printf("  - %s: %d record(s)\n", table, count)
+// printSummary lists what was exported, in dependency order, and where it
+// went
+func (j *exportJob) printSummary() {
+	tables := j.exporter.Tables()
+	total := 0
+	for _, table := range tables {
+		total += j.exporter.Rows(table)
 	}
+	fmt.Fprintf(exportStatus, "✓ Exported %d record(s) (including dependencies)\n", total)
 
-	// Generate SQL output
-	var writer *os.File
+	fmt.Fprintln(exportStatus, "\nExport summary:")
+	for _, table := range tables {
+		fmt.Fprintf(exportStatus, "  - %s: %d record(s) (%s)\n", table, j.exporter.Rows(table), j.exporter.Reason(table))
+	}
+	if j.exporter.Skipped() > 0 {
+		fmt.Fprintf(exportStatus, "\n⚠️  %d reference(s) not followed because of depth limits or table filters;\n", j.exporter.Skipped())
+		fmt.Fprintln(exportStatus, "   the output may violate foreign keys unless those rows already exist")
+	}
+
+	if j.importer != nil {
+		printImportCounts(j.importer.Counts())
+		return
+	}
 	if exportOutputFile != "" {
-		f, err := os.Create(exportOutputFile)
+		fmt.Fprintf(exportStatus, "✓ Export complete: %s\n", exportOutputFile)
+		printImportHint(j.cfg, exportOutputFile)
+	}
+}
+
+// describeExport prints the --plan of the export and writes its --graph
+func describeExport(ctx context.Context, exporter *database.Exporter, roots database.Roots) error {
+	var tables []database.TableName
+	if exportPlan || !exportGraphRows {
+		plan, err := exporter.Plan(ctx, roots, exportOptions)
+		if err != nil {
+			return err
+		}
+		if exportPlan {
+			printPlan(plan)
+		}
+		for _, table := range plan.Tables {
+			tables = append(tables, table.Table)
+		}
+	}
+	if exportGraph == "" {
+		return nil
+	}
+
+	graph := database.TableGraph(tables, exporter.ForeignKeys())
+	if exportGraphRows {
+		records, err := exporter.Export(ctx, roots, exportOptions)
+		if err != nil {
+			return err
+		}
+		if graph, err = exporter.RowGraph(records); err != nil {
+			return err
+		}
+	}
+
+	if exportOutputFile == "" {
+		fmt.Fprintln(exportStatus, "\n--- Output ---")
+		return database.WriteGraph(os.Stdout, graph, exportGraph)
+	}
+
+	f, err := os.Create(exportOutputFile)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer f.Close()
+	if err := database.WriteGraph(f, graph, exportGraph); err != nil {
+		return err
+	}
+	fmt.Fprintf(exportStatus, "✓ Graph written to %s\n", exportOutputFile)
+	return nil
+}
+
+// printPlan lists the tables and foreign keys an export would touch
+func printPlan(plan *database.Plan) {
+	fmt.Println("\n📋 Export plan (no rows fetched):")
+	for i, table := range plan.Tables {
+		rows := fmt.Sprintf("up to %d row(s)", table.Rows)
+		if i == 0 {
+			rows = fmt.Sprintf("%d root row(s)", table.Rows)
+		}
+		fmt.Printf("  - %s: %s, depth %d (%s)\n", table.Table, rows, table.Depth, table.Reason)
+	}
+
+	fmt.Println("\nForeign keys followed:")
+	for _, edge := range plan.Edges {
+		direction := "parent"
+		if edge.Child {
+			direction = "child"
+		}
+		fmt.Printf("  - %s(%s) → %s(%s) [%s]\n", edge.Table, strings.Join(edge.ColumnNames, ", "),
+			edge.ForeignTable, strings.Join(edge.ForeignColumnNames, ", "), direction)
+	}
+
+	if len(plan.Skipped) > 0 {
+		fmt.Println("\n⚠️  Not followed because of depth limits or table filters:")
+		for _, fk := range plan.Skipped {
+			fmt.Printf("  - %s(%s) → %s\n", fk.Table, strings.Join(fk.ColumnNames, ", "), fk.ForeignTable)
+		}
+	}
+}
+
+// collectRecords exports the records into memory, remaps their keys with
+// --remap-ids, and loads the DDL of their tables with --with-schema
+func collectRecords(ctx context.Context, exporter *database.Exporter, roots database.Roots) ([]database.Record, error) {
+	records, err := exporter.Export(ctx, roots, exportOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	if exportRemap.Strategy != "" {
+		if err := remapIDs(exporter, records); err != nil {
+			return nil, err
+		}
+	}
+
+	if exportWithSchema {
+		if err := exporter.LoadSchema(); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// writeRecords writes records without closing the writer
+func writeRecords(w database.RecordWriter, records []database.Record) error {
+	for _, record := range records {
+		if err := w.WriteRecord(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openOutput returns the writer of the --format output, in the --output
+// file or directory or on stdout
+func openOutput(cfg *config.Config, masker *database.Masker, steps *database.LoadSteps) (database.RecordWriter, error) {
+	dialect, err := database.DialectFor(cfg.Database.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	output := database.OutputOptions{Format: exportFormat, Dialect: dialect, Masker: masker, Steps: steps}
+	if exportFormat == database.FormatCSV {
+		output.Dir = exportOutputFile
+		fmt.Fprintf(exportStatus, "\nWriting to %s/...\n", exportOutputFile)
+		return database.NewRecordWriter(output)
+	}
+	if exportOutputFile == "" {
+		output.Writer = os.Stdout
+		fmt.Fprintln(exportStatus, "\n--- Output ---")
+		return database.NewRecordWriter(output)
+	}
+
+	f, err := os.Create(exportOutputFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	output.Writer = f
+	fmt.Fprintf(exportStatus, "\nWriting to %s...\n", exportOutputFile)
+
+	writer, err := database.NewRecordWriter(output)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileWriter{RecordWriter: writer, file: f}, nil
+}
+
+// fileWriter closes the output file along with its RecordWriter
+type fileWriter struct {
+	database.RecordWriter
+	file *os.File
+}
+
+func (w *fileWriter) Close() error {
+	if err := w.RecordWriter.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+func (w *fileWriter) Abort() error {
+	w.RecordWriter.Abort()
+	return w.file.Close()
+}
+
+// remapIDs gives the exported records fresh primary keys and reports the
+// old and new keys, in the --id-map file or in the summary
+func remapIDs(exporter *database.Exporter, records []database.Record) error {
+	mappings, err := exporter.RemapIDs(records, exportRemap)
+	if err != nil {
+		return fmt.Errorf("failed to remap keys: %w", err)
+	}
+
+	fmt.Fprintf(exportStatus, "\n🔑 Remapped %d key(s) with --remap-ids %s\n", len(mappings), exportRemap.Strategy)
+	if exportIDMap == "" {
+		for _, m := range mappings {
+			fmt.Fprintf(exportStatus, "  - %s.%s: %v → %v\n", m.Table, m.Column, m.Old, m.New)
+		}
+		return nil
+	}
+
+	f, err := os.Create(exportIDMap)
+	if err != nil {
+		return fmt.Errorf("failed to create id map: %w", err)
+	}
+	defer f.Close()
+
+	if err := database.WriteIDMap(f, mappings); err != nil {
+		return fmt.Errorf("failed to write id map: %w", err)
+	}
+	fmt.Fprintf(exportStatus, "✓ Key mapping written to %s\n", exportIDMap)
+	return nil
+}
+
+// exportSourceURL returns the URL of the database to export from: the
+// --from agent's database, or the main database
+func exportSourceURL(cfg *config.Config) (string, error) {
+	if exportFrom != "" {
+		conn, err := agentConnection(cfg, exportFrom)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error: failed to create output file: %v\n", err)
-			os.Exit(1)
+			return "", err
+		}
+		return conn.URL(), nil
+	}
+
+	return mainDatabaseURL(cfg)
+}
+
+// mainDatabaseURL returns database.main_url, or for SQLite projects the
+// main repo's database file, also when run from an agent's worktree
+func mainDatabaseURL(cfg *config.Config) (string, error) {
+	mainURL := cfg.Database.MainURL
+	if mainURL == "" && cfg.Database.Type == "sqlite" && cfg.Database.Path != "" {
+		mainURL = cfg.Database.Path
+		if !filepath.IsAbs(mainURL) {
+			root, err := git.GetMainRepoRoot(".")
+			if err != nil {
+				return "", err
+			}
+			mainURL = filepath.Join(root, mainURL)
 		}
-		defer f.Close()
-		writer = f
-		fmt.Printf("\nWriting to %s...\n", exportOutputFile)
+	}
+
+	if mainURL == "" {
+		return "", fmt.Errorf("database.main_url not configured in .agentenv.yml")
+	}
+
+	return mainURL, nil
+}
+
+// printImportCounts reports what an import inserted per table
+func printImportCounts(counts []database.ImportCount) {
+	fmt.Printf("✓ Import complete\n")
+	for _, count := range counts {
+		fmt.Printf("  - %s: %d inserted, %d skipped (already present)\n", count.Table, count.Inserted, count.Skipped)
+	}
+}
+
+// agentConnection resolves the database connection of a registered agent
+func agentConnection(cfg *config.Config, agentID string) (*database.Connection, error) {
+	reg, err := registry.LoadRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load registry: %w", err)
+	}
+
+	agent, err := reg.GetAgent(agentID)
+	if err != nil {
+		return nil, fmt.Errorf("agent not found: %w", err)
+	}
+
+	return database.AgentConnection(cfg, agent, reg.Project)
+}
+
+// printImportHint shows how to load an SQL export into an agent database
+func printImportHint(cfg *config.Config, file string) {
+	if exportFormat != database.FormatSQLInsert && exportFormat != database.FormatSQLCopy {
+		return
+	}
+
+	fmt.Println("\nTo import into an agent database:")
+	fmt.Printf("  cd ../project-agentX\n")
+	if cfg.Database.Type == "mysql" || cfg.Database.Type == "mariadb" {
+		fmt.Printf("  mysql <database> < %s\n", file)
+	} else if cfg.Database.Type == "sqlite" {
+		fmt.Printf("  sqlite3 %s < %s\n", cfg.Database.Path, file)
 	} else {
-		writer = os.Stdout
-		fmt.Println("\n--- SQL Output ---")
+		fmt.Printf("  psql <database-url> < %s\n", file)
 	}
+}
 
-	if err := database.GenerateSQL(records, writer); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: failed to generate SQL: %v\n", err)
-		os.Exit(1)
+// exportRoots builds the export roots from an optional key argument and the
+// --ids, --where and --limit flags
+func exportRoots(table database.TableName, keyArgs []string) (database.Roots, error) {
+	roots := database.Roots{Table: table, Where: exportWhere, Limit: exportLimit}
+
+	// --ids holds comma-separated single values, or one composite key whose
+	// column=value pairs are themselves comma-separated
+	args := append([]string{}, keyArgs...)
+	for _, ids := range exportIDs {
+		if strings.Contains(ids, "=") {
+			args = append(args, ids)
+		} else {
+			args = append(args, strings.Split(ids, ",")...)
+		}
 	}
 
-	if exportOutputFile != "" {
-		fmt.Printf("✓ Export complete: %s\n", exportOutputFile)
-		fmt.Println("\nTo import into an agent database:")
-		fmt.Printf("  cd ../project-agentX\n")
-		fmt.Printf("  psql <database-url> < %s\n", exportOutputFile)
+	// Each key is a single value or column=value pairs
+	for _, arg := range args {
+		key, err := database.ParseKey(arg)
+		if err != nil {
+			return roots, err
+		}
+		roots.Keys = append(roots.Keys, key)
 	}
+
+	if len(roots.Keys) == 0 && roots.Where == "" && roots.Limit == 0 {
+		return roots, fmt.Errorf("specify a key, --ids, --where or --limit to select rows to export")
+	}
+
+	return roots, nil
 }
@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/fixtures"
+	"github.com/spf13/cobra"
+)
+
+// fixturesCmd groups the fixture recipe commands
+var fixturesCmd = &cobra.Command{
+	Use:   "fixtures",
+	Short: "Build and check the fixture recipes of database.fixtures",
+	Long: `Fixture recipes are named exports listed under database.fixtures in
+.agentenv.yml. Each recipe names a table and its root rows (ids, where, limit),
+how far to follow foreign keys, and masking rules added to
+database.export.masking.
+
+'agentenv fixtures build' exports recipes from database.main_url into
+versioned SQL files in database.fixtures_dir (default .agentenv/fixtures),
+and 'agentenv up --fixtures' loads the latest versions into a new agent's
+database.`,
+}
+
+// fixturesBuildCmd builds recipes
+var fixturesBuildCmd = &cobra.Command{
+	Use:   "build [recipe...]",
+	Short: "Export fixture recipes into versioned files",
+	Long: `Export fixture recipes from database.main_url. Each build writes the next
+version of the recipe's file, <recipe>.v<N>.sql, and records it in the
+directory's manifest.json. Without arguments, every recipe is built.
+
+Example:
+  agentenv fixtures build
+  agentenv fixtures build smoke billing`,
+	RunE: runFixturesBuild,
+}
+
+// fixturesStatusCmd reports stale recipes
+var fixturesStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Report which fixture files are stale",
+	Long: `Compare each recipe's latest build with the recipe and with the current
+schema of the tables it exported in database.main_url. A build is stale if it
+was never made, if the recipe or its masking changed, or if a column or key of
+one of its tables changed.`,
+	Args: cobra.NoArgs,
+	RunE: runFixturesStatus,
+}
+
+func init() {
+	rootCmd.AddCommand(fixturesCmd)
+	fixturesCmd.AddCommand(fixturesBuildCmd)
+	fixturesCmd.AddCommand(fixturesStatusCmd)
+}
+
+func runFixturesBuild(cmd *cobra.Command, args []string) error {
+	builder, err := newFixtureBuilder(cmd.Context())
+	if err != nil {
+		return err
+	}
+	defer builder.Close()
+
+	names := args
+	if len(names) == 0 {
+		names = builder.Recipes()
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no recipes in database.fixtures")
+	}
+
+	for _, name := range names {
+		fmt.Printf("📦 Building fixture %s...\n", name)
+		build, err := builder.Build(cmd.Context(), name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ %s: %d record(s) from %d table(s) → %s\n", name, build.Rows, len(build.Tables), build.File)
+	}
+
+	return nil
+}
+
+func runFixturesStatus(cmd *cobra.Command, args []string) error {
+	builder, err := newFixtureBuilder(cmd.Context())
+	if err != nil {
+		return err
+	}
+	defer builder.Close()
+
+	stale := 0
+	for _, name := range builder.Names() {
+		status, err := builder.Status(name)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case status.Build == nil:
+			fmt.Printf("  ✗ %s: %s\n", name, status.Reason)
+		case status.Stale:
+			fmt.Printf("  ✗ %s: v%d stale, %s\n", name, status.Build.Version, status.Reason)
+		default:
+			fmt.Printf("  ✓ %s: v%d up to date (%s)\n", name, status.Build.Version, status.Build.File)
+		}
+		if status.Stale {
+			stale++
+		}
+	}
+
+	if stale > 0 {
+		fmt.Printf("\n%d fixture(s) need rebuilding: agentenv fixtures build\n", stale)
+	}
+	return nil
+}
+
+// newFixtureBuilder connects a fixture builder to database.main_url
+func newFixtureBuilder(ctx context.Context) (*fixtures.Builder, error) {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	mainURL, err := mainDatabaseURL(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return fixtures.NewBuilder(ctx, cfg, mainURL)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// restoreCmd represents the restore command
+var restoreCmd = &cobra.Command{
+	Use:   "restore <agent-id> <archive-file>",
+	Short: "Restore a database archive into an agent's database",
+	Long: `Load a database archive (such as one written by 'agentenv down') into a
+running agent's database using the engine's client tool (psql or mysql).
+
+Example:
+  agentenv restore claude1 .agentenv/archives/claude1-20250120-103000.sql`,
+	Args: cobra.ExactArgs(2),
+	RunE: runRestore,
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runRestore(cmd *cobra.Command, args []string) error {
+	agentID := args[0]
+	archiveFile := args[1]
+
+	verbose, _ := cmd.Flags().GetBool("verbose")
+
+	if _, err := os.Stat(archiveFile); err != nil {
+		return fmt.Errorf("archive not found: %w", err)
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	conn, err := agentConnection(cfg, agentID)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("💾 Restoring %s into %s...\n", archiveFile, conn.Name)
+	if err := database.Restore(conn, archiveFile, verbose); err != nil {
+		return fmt.Errorf("failed to restore database: %w", err)
+	}
+	fmt.Println("✓ Database restored")
+
+	return nil
+}
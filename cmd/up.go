@@ -9,29 +9,40 @@ import (
 	"time"
 
 	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/database"
 	"github.com/joshpurvis/agentenv/internal/docker"
 	"github.com/joshpurvis/agentenv/internal/envpatch"
+	"github.com/joshpurvis/agentenv/internal/fixtures"
 	"github.com/joshpurvis/agentenv/internal/git"
 	"github.com/joshpurvis/agentenv/internal/registry"
 	"github.com/joshpurvis/agentenv/internal/terminal"
 	"github.com/spf13/cobra"
 )
 
+var upFixtures []string
+
 // upCmd represents the up command
 var upCmd = &cobra.Command{
 	Use:   "up <agent-name> <branch> <command>",
 	Short: "Launch a new agent environment",
 	Long: `Launch a new agent environment with isolated Docker services and git worktree.
 
+With --fixtures, the latest builds of the named recipes from database.fixtures
+are loaded into the agent's database once the post-start setup commands (such
+as migrations) have run.
+
 Example:
   agentenv up claude1 feat/fix-rendering claude
-  agentenv up codex1 feat/new-api codex`,
+  agentenv up codex1 feat/new-api codex
+  agentenv up claude2 feat/billing claude --fixtures smoke,billing`,
 	Args: cobra.ExactArgs(3),
 	RunE: runUp,
 }
 
 func init() {
 	rootCmd.AddCommand(upCmd)
+
+	upCmd.Flags().StringSliceVar(&upFixtures, "fixtures", nil, "Load these fixture recipes into the agent's database")
 }
 
 func runUp(cmd *cobra.Command, args []string) error {
@@ -58,6 +69,12 @@ func runUp(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Check the fixtures are built before creating anything
+	fixtureFiles, err := builtFixtureFiles(cfg, repoPath)
+	if err != nil {
+		return err
+	}
+
 	// 2. Load or create registry
 	if verbose {
 		fmt.Println("📝 Loading registry...")
@@ -124,12 +141,34 @@ func runUp(cmd *cobra.Command, args []string) error {
 
 	// 9. Patch environment files
 	fmt.Println("\n⚙️  Patching environment files...")
-	if err := envpatch.PatchEnvFiles(cfg, worktreePath, ports, portSlot, agentName); err != nil {
+	patchTarget := envpatch.Worktree{Path: worktreePath, Ports: ports, AgentID: portSlot, AgentName: agentName}
+	if err := envpatch.PatchEnvFiles(cfg, patchTarget); err != nil {
 		return fmt.Errorf("failed to patch env files: %w", err)
 	}
 	fmt.Println("✓ Environment files patched")
 
-	// 10. Run setup commands (before services start)
+	// 10. Patch config files
+	if len(cfg.ConfigFiles) > 0 {
+		fmt.Println("\n⚙️  Patching config files...")
+		if err := envpatch.PatchConfigFiles(cfg, patchTarget); err != nil {
+			return fmt.Errorf("failed to patch config files: %w", err)
+		}
+		fmt.Println("✓ Config files patched")
+	}
+
+	// 11. Copy the SQLite database into the worktree (unless it will be seeded)
+	if cfg.Database.Type == "sqlite" && cfg.Database.Seed == "" {
+		mainDBPath := filepath.Join(repoPath, cfg.Database.Path)
+		if _, err := os.Stat(mainDBPath); err == nil {
+			fmt.Println("\n💾 Copying SQLite database...")
+			if err := database.CopySQLite(mainDBPath, filepath.Join(worktreePath, cfg.Database.Path)); err != nil {
+				return fmt.Errorf("failed to copy SQLite database: %w", err)
+			}
+			fmt.Println("✓ Database copied")
+		}
+	}
+
+	// 12. Run setup commands (before services start)
 	if len(cfg.SetupCommands) > 0 {
 		hasBeforeCommands := false
 		for _, setupCmd := range cfg.SetupCommands {
@@ -149,19 +188,34 @@ func runUp(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// 11. Start Docker services
+	// 13. Start Docker services
 	fmt.Println("\n🐳 Starting Docker services...")
 	if err := startDockerServices(cfg, worktreePath, agent.DockerComposeOverride, verbose); err != nil {
 		return fmt.Errorf("failed to start Docker services: %w", err)
 	}
 	fmt.Println("✓ Docker services started")
 
-	// 12. Wait for services to be healthy
+	// 14. Wait for services to be healthy
 	fmt.Println("\n⏳ Waiting for services to be ready...")
 	time.Sleep(5 * time.Second) // Simple wait for now
 	fmt.Println("✓ Services ready")
 
-	// 13. Run setup commands (after services start)
+	// 15. Seed the agent database (if configured)
+	if cfg.Database.Seed != "" {
+		fmt.Println("\n🌱 Seeding database...")
+		conn, err := database.AgentConnection(cfg, agent, reg.Project)
+		if err == nil {
+			err = database.Restore(conn, filepath.Join(repoPath, cfg.Database.Seed), verbose)
+		}
+		if err != nil {
+			fmt.Printf("  ⚠️  Warning: failed to seed database: %v\n", err)
+			// Continue anyway
+		} else {
+			fmt.Println("✓ Database seeded")
+		}
+	}
+
+	// 16. Run setup commands (after services start)
 	if len(cfg.SetupCommands) > 0 {
 		hasAfterCommands := false
 		for _, setupCmd := range cfg.SetupCommands {
@@ -181,12 +235,23 @@ func runUp(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// 14. Save registry
+	// 17. Load fixtures (after migrations)
+	if len(fixtureFiles) > 0 {
+		fmt.Println("\n📦 Loading fixtures...")
+		if err := loadFixtures(cfg, agent, fixtureFiles, reg.Project); err != nil {
+			fmt.Printf("  ⚠️  Warning: failed to load fixtures: %v\n", err)
+			// Continue anyway
+		} else {
+			fmt.Println("✓ Fixtures loaded")
+		}
+	}
+
+	// 18. Save registry
 	if err := reg.Save(); err != nil {
 		return fmt.Errorf("failed to save registry: %w", err)
 	}
 
-	// 15. Launch agent in terminal (if configured)
+	// 19. Launch agent in terminal (if configured)
 	if cfg.AgentLaunch.Terminal != "" || cfg.AgentLaunch.WorkingDirectory != "" {
 		fmt.Println("\n🚀 Launching agent in terminal...")
 		windowTitle := fmt.Sprintf("agentenv: %s", agentName)
@@ -198,7 +263,7 @@ func runUp(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// 16. Print summary
+	// 20. Print summary
 	separator := strings.Repeat("═", 60)
 	fmt.Println("\n" + separator)
 	fmt.Printf("🎉 Agent %s is ready!\n\n", agentID)
@@ -241,6 +306,36 @@ func startDockerServices(cfg *config.Config, worktreePath, overrideFile string,
 	return nil
 }
 
+// builtFixtureFiles returns the latest files of the --fixtures recipes
+func builtFixtureFiles(cfg *config.Config, repoPath string) ([]string, error) {
+	if len(upFixtures) == 0 {
+		return nil, nil
+	}
+
+	dir := filepath.Join(repoPath, cfg.Database.FixturesDir)
+	manifest, err := fixtures.LoadManifest(dir)
+	if err != nil {
+		return nil, err
+	}
+	return manifest.Files(dir, upFixtures)
+}
+
+// loadFixtures restores fixture files into the agent's database, in order
+func loadFixtures(cfg *config.Config, agent *registry.Agent, files []string, projectName string) error {
+	conn, err := database.AgentConnection(cfg, agent, projectName)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		fmt.Printf("  Loading %s\n", filepath.Base(file))
+		if err := database.Restore(conn, file, false); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(file), err)
+		}
+	}
+	return nil
+}
+
 func runSetupCommand(setupCmd config.SetupCommand, worktreePath string, verbose bool) error {
 	cmd := exec.Command("sh", "-c", setupCmd.Command)
 	workDir := filepath.Join(worktreePath, setupCmd.WorkingDir)
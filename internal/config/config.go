@@ -11,6 +11,7 @@ import (
 type Config struct {
 	Docker         DockerConfig        `yaml:"docker"`
 	EnvFiles       []EnvFile           `yaml:"env_files"`
+	ConfigFiles    []ConfigFile        `yaml:"config_files"`
 	Database       DatabaseConfig      `yaml:"database"`
 	SetupCommands  []SetupCommand      `yaml:"setup_commands"`
 	AgentLaunch    AgentLaunchConfig   `yaml:"agent_launch"`
@@ -38,9 +39,23 @@ type PortMapping struct {
 }
 
 // EnvFile represents an environment file to patch
+// Regex patches run first, then the key-based set, unset and
+// append_if_missing operations, whose values are templated
 type EnvFile struct {
-	Path    string      `yaml:"path"`
-	Patches []EnvPatch  `yaml:"patches"`
+	Path            string             `yaml:"path"`
+	Patches         []EnvPatch         `yaml:"patches"`
+	Set             map[string]string  `yaml:"set"`               // Replace or add KEY=value
+	Unset           []string           `yaml:"unset"`             // Remove KEY
+	AppendIfMissing map[string]string  `yaml:"append_if_missing"` // Add KEY=value unless KEY is set
+}
+
+// ConfigFile represents a YAML, JSON, TOML or INI file to patch by key path,
+// such as database.port
+type ConfigFile struct {
+	Path   string             `yaml:"path"`
+	Format string             `yaml:"format"` // yaml, json, toml or ini; detected from the extension if empty
+	Set    map[string]string  `yaml:"set"`    // Key path → templated value
+	Unset  []string           `yaml:"unset"`
 }
 
 // EnvPatch represents a regex replacement in an env file
@@ -51,10 +66,58 @@ type EnvPatch struct {
 
 // DatabaseConfig contains database initialization settings
 type DatabaseConfig struct {
-	Service    string           `yaml:"service"`
-	Type       string           `yaml:"type"`
-	MainURL    string           `yaml:"main_url"`
-	Migrations MigrationsConfig `yaml:"migrations"`
+	Service     string                   `yaml:"service"`
+	Type        string                   `yaml:"type"`
+	MainURL     string                   `yaml:"main_url"`
+	Path        string                   `yaml:"path"`
+	Seed        string                   `yaml:"seed"`
+	Migrations  MigrationsConfig         `yaml:"migrations"`
+	Export      ExportConfig             `yaml:"export"`
+	Fixtures    map[string]FixtureRecipe `yaml:"fixtures"`
+	FixturesDir string                   `yaml:"fixtures_dir"`
+}
+
+// ExportConfig contains settings for agentenv export
+type ExportConfig struct {
+	Salt    string              `yaml:"salt"`
+	Masking map[string]MaskRule `yaml:"masking"`
+}
+
+// MaskRule describes how to mask a column: null, fixed, fake_email,
+// fake_name, hash or keep. Rules without a value can be written as just
+// the strategy name, e.g. "users.email: fake_email".
+type MaskRule struct {
+	Strategy string `yaml:"strategy"`
+	Value    string `yaml:"value"`
+}
+
+// UnmarshalYAML accepts either a strategy name or a strategy/value mapping
+func (r *MaskRule) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		r.Strategy = node.Value
+		return nil
+	}
+
+	type plain MaskRule
+	return node.Decode((*plain)(r))
+}
+
+// FixtureRecipe is a named export that agentenv fixtures build writes to a
+// versioned file. Roots, filters and depths mean the same as the flags of
+// agentenv export; masking rules are added to database.export.masking.
+type FixtureRecipe struct {
+	Table         string              `yaml:"table"`
+	IDs           []string            `yaml:"ids"`
+	Where         string              `yaml:"where"`
+	Limit         int                 `yaml:"limit"`
+	Children      bool                `yaml:"children"`
+	Depth         int                 `yaml:"depth"`
+	ParentDepth   int                 `yaml:"parent_depth"`
+	ChildDepth    int                 `yaml:"child_depth"`
+	IncludeTables []string            `yaml:"include_tables"`
+	ExcludeTables []string            `yaml:"exclude_tables"`
+	MaxRows       int                 `yaml:"max_rows"`
+	Masking       map[string]MaskRule `yaml:"masking"`
 }
 
 // MigrationsConfig contains migration command settings
@@ -105,6 +168,12 @@ func LoadConfigFromPath(path string) (*Config, error) {
 	if config.Docker.ComposeFile == "" {
 		config.Docker.ComposeFile = "docker-compose.yml"
 	}
+	if config.Database.Type == "" {
+		config.Database.Type = "postgresql"
+	}
+	if config.Database.FixturesDir == "" {
+		config.Database.FixturesDir = ".agentenv/fixtures"
+	}
 	if config.Cleanup.ArchiveLocation == "" {
 		config.Cleanup.ArchiveLocation = "agent-archives"
 	}
@@ -0,0 +1,235 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/docker"
+	"github.com/joshpurvis/agentenv/internal/registry"
+)
+
+// Connection holds what the dump and restore tools need to reach an agent's database
+type Connection struct {
+	Type     string
+	Host     string
+	Port     int
+	Name     string
+	User     string
+	Password string
+	Path     string // SQLite database file
+}
+
+// AgentConnection builds the connection details for an agent's database from
+// the environment its database service runs with: the compose file's
+// environment and env_file entries, the rendered .agentenv.yml environment,
+// and any *_FILE secrets
+func AgentConnection(cfg *config.Config, agent *registry.Agent, projectName string) (*Connection, error) {
+	// SQLite databases live in the worktree rather than in a service
+	if cfg.Database.Type == "sqlite" {
+		if cfg.Database.Path == "" {
+			return nil, fmt.Errorf("database.path is required for sqlite databases")
+		}
+		return &Connection{
+			Type: cfg.Database.Type,
+			Path: filepath.Join(agent.WorktreePath, cfg.Database.Path),
+		}, nil
+	}
+
+	dbService := cfg.Database.Service
+	dbPort, ok := agent.Ports[dbService]
+	if !ok {
+		return nil, fmt.Errorf("database service %s not found in agent ports", dbService)
+	}
+	dbEnv, err := docker.ServiceEnvironment(cfg, agent, dbService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve the environment of %s: %w", dbService, err)
+	}
+
+	conn := &Connection{
+		Type: cfg.Database.Type,
+		Host: "127.0.0.1",
+		Port: dbPort,
+	}
+	defaultName := fmt.Sprintf("%s_agent%d", projectName, agent.PortSlot)
+	if err := conn.setCredentials(dbEnv, defaultName); err != nil {
+		return nil, err
+	}
+	// Replace template variables in database name
+	conn.Name = strings.ReplaceAll(conn.Name, "{id}", fmt.Sprintf("%d", agent.PortSlot))
+
+	return conn, nil
+}
+
+// setCredentials sets the database name, user and password the way the
+// service's image reads them from its environment, using defaultName for a
+// MySQL service that names no database
+func (c *Connection) setCredentials(dbEnv map[string]string, defaultName string) error {
+	switch c.Type {
+	case "postgresql", "postgres":
+		// The image names the database after the user unless told otherwise
+		c.User = valueOrDefault(dbEnv["POSTGRES_USER"], "postgres")
+		c.Password = valueOrDefault(dbEnv["POSTGRES_PASSWORD"], "postgres")
+		c.Name = valueOrDefault(dbEnv["POSTGRES_DB"], c.User)
+	case "mysql", "mariadb":
+		c.Name = valueOrDefault(dbEnv["MYSQL_DATABASE"], defaultName)
+		c.User = dbEnv["MYSQL_USER"]
+		c.Password = dbEnv["MYSQL_PASSWORD"]
+		// Images without MYSQL_USER only create the root account
+		if c.User == "" {
+			c.User = "root"
+			c.Password = dbEnv["MYSQL_ROOT_PASSWORD"]
+		}
+	default:
+		return fmt.Errorf("unsupported database type: %q", c.Type)
+	}
+	return nil
+}
+
+// URL returns the connection as a URL accepted by NewExporter
+func (c *Connection) URL() string {
+	if c.Type == "sqlite" {
+		return c.Path
+	}
+
+	scheme := "postgres"
+	if c.Type == "mysql" || c.Type == "mariadb" {
+		scheme = "mysql"
+	}
+
+	u := url.URL{
+		Scheme: scheme,
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   fmt.Sprintf("%s:%d", c.Host, c.Port),
+		Path:   "/" + c.Name,
+	}
+	if scheme == "postgres" {
+		u.RawQuery = "sslmode=disable"
+	}
+
+	return u.String()
+}
+
+// open connects to the database for reading and writing
+func (c *Connection) open(dialect connector) (*sql.DB, error) {
+	// SQLite files are opened read-only unless the URI sets a mode
+	if c.Type == "sqlite" {
+		return dialect.Open(sqliteURI(c.Path, "rw"))
+	}
+	return dialect.Open(c.URL())
+}
+
+// ArchiveExtension returns the file extension for archives written by Archive
+func (c *Connection) ArchiveExtension() string {
+	if c.Type == "sqlite" {
+		return ".sqlite"
+	}
+	return ".sql"
+}
+
+// Archive dumps the database to archiveFile using the engine's dump tool,
+// or the online backup API for SQLite
+func Archive(conn *Connection, archiveFile string, verbose bool) error {
+	var cmd *exec.Cmd
+
+	switch conn.Type {
+	case "sqlite":
+		return CopySQLite(conn.Path, archiveFile)
+	case "postgresql", "postgres":
+		cmd = exec.Command("pg_dump",
+			"-h", conn.Host,
+			"-p", strconv.Itoa(conn.Port),
+			"-U", conn.User,
+			"-d", conn.Name,
+			"-f", archiveFile)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+	case "mysql", "mariadb":
+		cmd = exec.Command("mysqldump",
+			"-h", conn.Host,
+			"-P", strconv.Itoa(conn.Port),
+			"-u", conn.User,
+			"--single-transaction",
+			"--routines",
+			"--triggers",
+			"--result-file", archiveFile,
+			conn.Name)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", conn.Password))
+	default:
+		return fmt.Errorf("unsupported database type: %q", conn.Type)
+	}
+
+	return runDatabaseTool(cmd, verbose)
+}
+
+// Restore loads a dump written by Archive, or a plain SQL file, into the database
+func Restore(conn *Connection, dumpFile string, verbose bool) error {
+	var cmd *exec.Cmd
+
+	switch conn.Type {
+	case "sqlite":
+		return restoreSQLite(conn.Path, dumpFile)
+	case "postgresql", "postgres":
+		cmd = exec.Command("psql",
+			"-h", conn.Host,
+			"-p", strconv.Itoa(conn.Port),
+			"-U", conn.User,
+			"-d", conn.Name,
+			"-v", "ON_ERROR_STOP=1",
+			"-q",
+			"-f", dumpFile)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", conn.Password))
+	case "mysql", "mariadb":
+		// The mysql client has no file flag, so feed the dump on stdin
+		f, err := os.Open(dumpFile)
+		if err != nil {
+			return fmt.Errorf("failed to open dump file: %w", err)
+		}
+		defer f.Close()
+
+		cmd = exec.Command("mysql",
+			"-h", conn.Host,
+			"-P", strconv.Itoa(conn.Port),
+			"-u", conn.User,
+			conn.Name)
+		cmd.Stdin = f
+		cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", conn.Password))
+	default:
+		return fmt.Errorf("unsupported database type: %q", conn.Type)
+	}
+
+	return runDatabaseTool(cmd, verbose)
+}
+
+// runDatabaseTool runs a dump or restore command, capturing stderr for errors
+func runDatabaseTool(cmd *exec.Cmd, verbose bool) error {
+	var stderr strings.Builder
+	if verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stderr = &stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s failed: %w\nOutput: %s", cmd.Args[0], err, stderr.String())
+		}
+		return fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	}
+
+	return nil
+}
+
+// valueOrDefault returns value, or fallback when value is empty
+func valueOrDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
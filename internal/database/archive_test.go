@@ -0,0 +1,98 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/registry"
+)
+
+func TestAgentConnection(t *testing.T) {
+	tests := []struct {
+		name     string
+		dbType   string
+		env      map[string]string
+		expected Connection
+	}{
+		{
+			name:     "postgres defaults",
+			dbType:   "postgresql",
+			env:      map[string]string{"POSTGRES_DB": "shop_agent{id}"},
+			expected: Connection{Name: "shop_agent2", User: "postgres", Password: "postgres"},
+		},
+		{
+			name:     "postgres database named after the user",
+			dbType:   "postgresql",
+			env:      map[string]string{"POSTGRES_USER": "shop"},
+			expected: Connection{Name: "shop", User: "shop", Password: "postgres"},
+		},
+		{
+			name:   "mysql user",
+			dbType: "mysql",
+			env: map[string]string{
+				"MYSQL_DATABASE": "shop_agent{id}",
+				"MYSQL_USER":     "app",
+				"MYSQL_PASSWORD": "secret",
+			},
+			expected: Connection{Name: "shop_agent2", User: "app", Password: "secret"},
+		},
+		{
+			name:     "mysql root fallback",
+			dbType:   "mysql",
+			env:      map[string]string{"MYSQL_ROOT_PASSWORD": "rootpw"},
+			expected: Connection{Name: "myapp_agent2", User: "root", Password: "rootpw"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{
+				Docker: config.DockerConfig{
+					Services: map[string]config.ServiceConfig{
+						"db": {Environment: tt.env},
+					},
+				},
+				Database: config.DatabaseConfig{Service: "db", Type: tt.dbType},
+			}
+			agent := &registry.Agent{Ports: map[string]int{"db": 5434}, PortSlot: 2}
+
+			conn, err := AgentConnection(cfg, agent, "myapp")
+			if err != nil {
+				t.Fatalf("AgentConnection failed: %v", err)
+			}
+			if conn.Name != tt.expected.Name || conn.User != tt.expected.User || conn.Password != tt.expected.Password {
+				t.Errorf("AgentConnection() = %+v, want %+v", *conn, tt.expected)
+			}
+			if conn.Port != 5434 {
+				t.Errorf("AgentConnection() port = %d, want 5434", conn.Port)
+			}
+		})
+	}
+}
+
+func TestConnectionOpenSQLitePath(t *testing.T) {
+	content, err := os.ReadFile(createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+	// ? and # would otherwise start the URI's query and fragment
+	path := filepath.Join(t.TempDir(), "agent #1", "app?.db")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatalf("failed to write database: %v", err)
+	}
+
+	conn := &Connection{Type: "sqlite", Path: path}
+	db, err := conn.open(sqliteDialect{})
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("INSERT INTO users (email) VALUES ('new@example.com')"); err != nil {
+		t.Errorf("failed to write through the connection: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// checkpointNamePattern keeps checkpoint names usable in database names
+// without quoting
+var checkpointNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,24}$`)
+
+// ValidateCheckpointName checks that a checkpoint name is lowercase letters,
+// digits and underscores
+func ValidateCheckpointName(name string) error {
+	if !checkpointNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid checkpoint name %q: use up to 24 lowercase letters, digits and underscores", name)
+	}
+	return nil
+}
+
+// ServiceExec runs a command inside the container of a database service
+type ServiceExec interface {
+	Exec(env []string, args ...string) ([]byte, error)
+}
+
+// Checkpointer saves and restores copies of an agent's database. Postgres
+// checkpoints are template databases and MySQL checkpoints are database
+// copies, both made inside the database's container; SQLite checkpoints
+// are backup files.
+type Checkpointer struct {
+	conn    *Connection
+	service ServiceExec // The database's container, unused for SQLite
+	dir     string      // Where SQLite checkpoint files are kept
+}
+
+// NewCheckpointer creates a checkpointer for the database behind conn
+func NewCheckpointer(conn *Connection, service ServiceExec, dir string) *Checkpointer {
+	return &Checkpointer{conn: conn, service: service, dir: dir}
+}
+
+// Location returns where the checkpoint called name is kept: a database
+// next to the agent's, or a file for SQLite
+func (c *Checkpointer) Location(name string) string {
+	if c.conn.Type == "sqlite" {
+		return filepath.Join(c.dir, name+".sqlite")
+	}
+	return c.conn.Name + "_cp_" + name
+}
+
+// Create copies the database to location, replacing an earlier checkpoint
+// there. Postgres can only copy a database nobody is connected to, so the
+// database's open connections are closed first.
+func (c *Checkpointer) Create(location string) error {
+	switch c.conn.Type {
+	case "sqlite":
+		if err := os.MkdirAll(c.dir, 0755); err != nil {
+			return fmt.Errorf("failed to create checkpoint directory: %w", err)
+		}
+		return CopySQLite(c.conn.Path, location)
+	case "postgresql", "postgres":
+		return c.psql(
+			postgresDropDatabase(location),
+			postgresTerminate(c.conn.Name),
+			postgresCreateDatabase(location, c.conn.Name),
+			fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS false", postgresDialect{}.QuoteIdentifier(location)),
+		)
+	case "mysql", "mariadb":
+		if err := c.mysql(mysqlRecreateDatabase(location)); err != nil {
+			return err
+		}
+		return c.mysqlCopy(c.conn.Name, location)
+	default:
+		return fmt.Errorf("unsupported database type: %q", c.conn.Type)
+	}
+}
+
+// Rollback replaces the database with the checkpoint at location, which
+// is kept for later rollbacks. Open connections to the database are closed.
+func (c *Checkpointer) Rollback(location string) error {
+	switch c.conn.Type {
+	case "sqlite":
+		if _, err := os.Stat(location); err != nil {
+			return fmt.Errorf("checkpoint file not found: %w", err)
+		}
+		return restoreSQLite(c.conn.Path, location)
+	case "postgresql", "postgres":
+		return c.postgresRollback(location)
+	case "mysql", "mariadb":
+		if err := c.mysql(mysqlRecreateDatabase(c.conn.Name)); err != nil {
+			return err
+		}
+		return c.mysqlCopy(location, c.conn.Name)
+	default:
+		return fmt.Errorf("unsupported database type: %q", c.conn.Type)
+	}
+}
+
+// Drop deletes the checkpoint at location, if it exists
+func (c *Checkpointer) Drop(location string) error {
+	switch c.conn.Type {
+	case "sqlite":
+		if err := os.Remove(location); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	case "postgresql", "postgres":
+		return c.psql(postgresDropDatabase(location))
+	case "mysql", "mariadb":
+		return c.mysql(fmt.Sprintf("DROP DATABASE IF EXISTS %s", mysqlDialect{}.QuoteIdentifier(location)))
+	default:
+		return fmt.Errorf("unsupported database type: %q", c.conn.Type)
+	}
+}
+
+// postgresRollback copies the checkpoint, then swaps the copy in by renaming
+// both databases. The original database is only dropped once the copy has
+// its name, and a failed swap puts it back and lets clients connect again.
+func (c *Checkpointer) postgresRollback(location string) error {
+	d := postgresDialect{}
+	name := d.QuoteIdentifier(c.conn.Name)
+	restored, replaced := c.conn.Name+"_rollback", c.conn.Name+"_replaced"
+
+	err := c.psql(postgresDropDatabase(restored), postgresDropDatabase(replaced), postgresCreateDatabase(restored, location))
+	if err != nil {
+		return err
+	}
+
+	err = c.psql(
+		fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS false", name),
+		postgresTerminate(c.conn.Name),
+		fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", name, d.QuoteIdentifier(replaced)),
+		fmt.Sprintf("ALTER DATABASE %s RENAME TO %s", d.QuoteIdentifier(restored), name),
+	)
+	if err != nil {
+		restore := fmt.Sprintf("DO $$ BEGIN IF NOT EXISTS (SELECT FROM pg_database WHERE datname = %s) THEN ALTER DATABASE %s RENAME TO %s; END IF; END $$",
+			postgresString(c.conn.Name), d.QuoteIdentifier(replaced), name)
+		if restoreErr := c.psql(restore, fmt.Sprintf("ALTER DATABASE %s WITH ALLOW_CONNECTIONS true", name)); restoreErr != nil {
+			return fmt.Errorf("failed to swap in the checkpoint: %w (restoring the original database also failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("failed to swap in the checkpoint, the original database was kept: %w", err)
+	}
+
+	if err := c.psql(postgresDropDatabase(replaced)); err != nil {
+		return fmt.Errorf("failed to drop the replaced database %s: %w", replaced, err)
+	}
+	return nil
+}
+
+// psql runs statements one by one, outside a transaction as CREATE and
+// DROP DATABASE require, connected to a maintenance database
+func (c *Checkpointer) psql(statements ...string) error {
+	maintenance := "postgres"
+	if c.conn.Name == maintenance {
+		maintenance = "template1"
+	}
+
+	args := []string{"psql", "-U", c.conn.User, "-d", maintenance, "-v", "ON_ERROR_STOP=1", "-q"}
+	for _, statement := range statements {
+		args = append(args, "-c", statement)
+	}
+	_, err := c.service.Exec([]string{"PGPASSWORD=" + c.conn.Password}, args...)
+	return err
+}
+
+// mysql runs statements with the mysql client
+func (c *Checkpointer) mysql(statements string) error {
+	_, err := c.service.Exec([]string{"MYSQL_PWD=" + c.conn.Password}, "mysql", "-u", c.conn.User, "-e", statements)
+	return err
+}
+
+// mysqlCopy copies one database into another, empty one by piping
+// mysqldump into mysql inside the container
+func (c *Checkpointer) mysqlCopy(from, to string) error {
+	script := `mysqldump -u "$0" --single-transaction --routines --triggers --events "$1" | mysql -u "$0" "$2"`
+	_, err := c.service.Exec([]string{"MYSQL_PWD=" + c.conn.Password}, "sh", "-c", script, c.conn.User, from, to)
+	return err
+}
+
+// postgresTerminate closes the other sessions connected to a database
+func postgresTerminate(name string) string {
+	return fmt.Sprintf("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = %s AND pid <> pg_backend_pid()", postgresString(name))
+}
+
+// postgresCreateDatabase copies a database with CREATE DATABASE ... TEMPLATE
+func postgresCreateDatabase(name, template string) string {
+	d := postgresDialect{}
+	return fmt.Sprintf("CREATE DATABASE %s TEMPLATE %s", d.QuoteIdentifier(name), d.QuoteIdentifier(template))
+}
+
+// postgresDropDatabase drops a database if it exists
+func postgresDropDatabase(name string) string {
+	return fmt.Sprintf("DROP DATABASE IF EXISTS %s", postgresDialect{}.QuoteIdentifier(name))
+}
+
+// mysqlRecreateDatabase empties a database by dropping and creating it
+func mysqlRecreateDatabase(name string) string {
+	quoted := mysqlDialect{}.QuoteIdentifier(name)
+	return fmt.Sprintf("DROP DATABASE IF EXISTS %s; CREATE DATABASE %s", quoted, quoted)
+}
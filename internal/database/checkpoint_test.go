@@ -0,0 +1,134 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// recordingService is a ServiceExec that records the commands it is given,
+// failing those that contain failOn
+type recordingService struct {
+	commands [][]string
+	failOn   string
+}
+
+func (s *recordingService) Exec(env []string, args ...string) ([]byte, error) {
+	s.commands = append(s.commands, args)
+	if s.failOn != "" && strings.Contains(strings.Join(args, " "), s.failOn) {
+		return nil, fmt.Errorf("command failed")
+	}
+	return nil, nil
+}
+
+// countUsers returns the number of rows in the fixture's users table
+func countUsers(t *testing.T, path string) int {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		t.Fatalf("failed to count users: %v", err)
+	}
+	return count
+}
+
+func TestSQLiteCheckpointRollback(t *testing.T) {
+	path := createSQLiteFixture(t)
+	checkpointer := NewCheckpointer(&Connection{Type: "sqlite", Path: path}, nil, filepath.Join(t.TempDir(), "checkpoints"))
+
+	location := checkpointer.Location("before")
+	if err := checkpointer.Create(location); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	execSQLite(t, path, "INSERT INTO users (id, email) VALUES (2, 'new@example.com')")
+	if err := checkpointer.Rollback(location); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+	if count := countUsers(t, path); count != 1 {
+		t.Errorf("users after rollback = %d, want 1", count)
+	}
+
+	if err := checkpointer.Drop(location); err != nil {
+		t.Fatalf("Drop failed: %v", err)
+	}
+	if err := checkpointer.Rollback(location); err == nil {
+		t.Error("Rollback to a dropped checkpoint succeeded, want an error")
+	}
+}
+
+func TestPostgresCheckpointCommands(t *testing.T) {
+	service := &recordingService{}
+	conn := &Connection{Type: "postgres", Name: "app_agent1", User: "postgres", Password: "secret"}
+	checkpointer := NewCheckpointer(conn, service, "")
+
+	location := checkpointer.Location("before")
+	if location != "app_agent1_cp_before" {
+		t.Errorf("Location = %q, want app_agent1_cp_before", location)
+	}
+	if err := checkpointer.Create(location); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := checkpointer.Rollback(location); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	create := strings.Join(service.commands[0], " ")
+	if !strings.Contains(create, `CREATE DATABASE "app_agent1_cp_before" TEMPLATE "app_agent1"`) {
+		t.Errorf("checkpoint command does not copy the database: %s", create)
+	}
+
+	// The checkpoint is copied, swapped in by name, and only then is the
+	// agent's old database dropped
+	if len(service.commands) != 4 {
+		t.Fatalf("ran %d commands, want 1 to checkpoint and 3 to roll back", len(service.commands))
+	}
+	if copied := strings.Join(service.commands[1], " "); !strings.Contains(copied, `CREATE DATABASE "app_agent1_rollback" TEMPLATE "app_agent1_cp_before"`) {
+		t.Errorf("rollback does not copy the checkpoint first: %s", copied)
+	}
+	swap := strings.Join(service.commands[2], " ")
+	replaced := strings.Index(swap, `ALTER DATABASE "app_agent1" RENAME TO "app_agent1_replaced"`)
+	restored := strings.Index(swap, `ALTER DATABASE "app_agent1_rollback" RENAME TO "app_agent1"`)
+	if replaced < 0 || restored < 0 || replaced > restored {
+		t.Errorf("rollback does not swap the databases by name: %s", swap)
+	}
+	if dropped := strings.Join(service.commands[3], " "); !strings.Contains(dropped, `DROP DATABASE IF EXISTS "app_agent1_replaced"`) {
+		t.Errorf("rollback does not drop the replaced database last: %s", dropped)
+	}
+	for _, command := range service.commands {
+		if strings.Contains(strings.Join(command, " "), `DROP DATABASE IF EXISTS "app_agent1" `) {
+			t.Errorf("rollback drops the agent's database: %v", command)
+		}
+	}
+
+	// A failed swap puts the original database back and reopens it
+	failing := &recordingService{failOn: "ALLOW_CONNECTIONS false"}
+	if err := NewCheckpointer(conn, failing, "").Rollback(location); err == nil {
+		t.Fatal("Rollback with a failing swap should fail")
+	}
+	restore := strings.Join(failing.commands[len(failing.commands)-1], " ")
+	if !strings.Contains(restore, `ALTER DATABASE "app_agent1" WITH ALLOW_CONNECTIONS true`) {
+		t.Errorf("failed rollback does not reopen the original database: %s", restore)
+	}
+}
+
+func TestValidateCheckpointName(t *testing.T) {
+	for _, name := range []string{"before_migration", "20240102_150405"} {
+		if err := ValidateCheckpointName(name); err != nil {
+			t.Errorf("ValidateCheckpointName(%q) failed: %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "Before", "a-b", "x; DROP DATABASE y", strings.Repeat("a", 25)} {
+		if err := ValidateCheckpointName(name); err == nil {
+			t.Errorf("ValidateCheckpointName(%q) succeeded, want an error", name)
+		}
+	}
+}
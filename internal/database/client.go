@@ -0,0 +1,85 @@
+package database
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// JDBCURL returns the connection as a JDBC URL, with the user as a query
+// parameter. The password is left out, so it does not end up in shell
+// history or logs; JDBC tools take it separately.
+func (c *Connection) JDBCURL() string {
+	if c.Type == "sqlite" {
+		return "jdbc:sqlite:" + c.Path
+	}
+
+	subprotocol := "postgresql"
+	if c.Type == "mysql" || c.Type == "mariadb" {
+		subprotocol = "mysql"
+	}
+
+	query := url.Values{}
+	query.Set("user", c.User)
+	return fmt.Sprintf("jdbc:%s://%s:%d/%s?%s", subprotocol, c.Host, c.Port, c.Name, query.Encode())
+}
+
+// Variables returns the connection as DATABASE_URL followed by the
+// variables the engine's command-line client reads, as KEY=VALUE pairs
+func (c *Connection) Variables() []string {
+	vars := []string{"DATABASE_URL=" + c.URL()}
+
+	switch c.Type {
+	case "postgresql", "postgres":
+		vars = append(vars,
+			"PGHOST="+c.Host,
+			"PGPORT="+strconv.Itoa(c.Port),
+			"PGDATABASE="+c.Name,
+			"PGUSER="+c.User,
+			"PGPASSWORD="+c.Password,
+		)
+	case "mysql", "mariadb":
+		vars = append(vars,
+			"MYSQL_HOST="+c.Host,
+			"MYSQL_TCP_PORT="+strconv.Itoa(c.Port),
+			"MYSQL_PWD="+c.Password,
+		)
+	}
+	return vars
+}
+
+// Client is a command line that opens an engine's interactive client
+type Client struct {
+	Command string
+	Args    []string
+	Env     []string // Variables that pass the client the password
+}
+
+// ClientCommand returns the command line that opens the engine's
+// interactive client on the database. Inside the database's container the
+// client connects over the local socket, so no host or port is given.
+func (c *Connection) ClientCommand(inContainer bool) (Client, error) {
+	switch c.Type {
+	case "sqlite":
+		if inContainer {
+			return Client{}, fmt.Errorf("sqlite databases have no container")
+		}
+		return Client{Command: "sqlite3", Args: []string{c.Path}}, nil
+	case "postgresql", "postgres":
+		client := Client{Command: "psql", Env: []string{"PGPASSWORD=" + c.Password}}
+		if !inContainer {
+			client.Args = append(client.Args, "-h", c.Host, "-p", strconv.Itoa(c.Port))
+		}
+		client.Args = append(client.Args, "-U", c.User, "-d", c.Name)
+		return client, nil
+	case "mysql", "mariadb":
+		client := Client{Command: "mysql", Env: []string{"MYSQL_PWD=" + c.Password}}
+		if !inContainer {
+			client.Args = append(client.Args, "-h", c.Host, "-P", strconv.Itoa(c.Port))
+		}
+		client.Args = append(client.Args, "-u", c.User, c.Name)
+		return client, nil
+	default:
+		return Client{}, fmt.Errorf("unsupported database type: %q", c.Type)
+	}
+}
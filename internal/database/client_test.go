@@ -0,0 +1,61 @@
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConnectionJDBCURL(t *testing.T) {
+	tests := []struct {
+		conn     Connection
+		expected string
+	}{
+		{
+			conn:     Connection{Type: "postgresql", Host: "127.0.0.1", Port: 5434, Name: "shop_agent2", User: "app", Password: "p&w"},
+			expected: "jdbc:postgresql://127.0.0.1:5434/shop_agent2?user=app",
+		},
+		{
+			conn:     Connection{Type: "mysql", Host: "127.0.0.1", Port: 3308, Name: "shop", User: "r&d", Password: "pw"},
+			expected: "jdbc:mysql://127.0.0.1:3308/shop?user=r%26d",
+		},
+		{
+			conn:     Connection{Type: "sqlite", Path: "/work/agent1/db.sqlite"},
+			expected: "jdbc:sqlite:/work/agent1/db.sqlite",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := tt.conn.JDBCURL(); got != tt.expected {
+			t.Errorf("JDBCURL() = %q, want %q", got, tt.expected)
+		}
+	}
+}
+
+func TestConnectionClientCommand(t *testing.T) {
+	conn := Connection{Type: "postgresql", Host: "127.0.0.1", Port: 5434, Name: "shop", User: "app", Password: "pw"}
+
+	client, err := conn.ClientCommand(false)
+	if err != nil {
+		t.Fatalf("ClientCommand failed: %v", err)
+	}
+	if got := client.Command + " " + strings.Join(client.Args, " "); got != "psql -h 127.0.0.1 -p 5434 -U app -d shop" {
+		t.Errorf("host command = %q", got)
+	}
+	if len(client.Env) != 1 || client.Env[0] != "PGPASSWORD=pw" {
+		t.Errorf("host env = %v, want [PGPASSWORD=pw]", client.Env)
+	}
+
+	// Inside the container the client uses the local socket
+	client, err = conn.ClientCommand(true)
+	if err != nil {
+		t.Fatalf("ClientCommand failed: %v", err)
+	}
+	if got := client.Command + " " + strings.Join(client.Args, " "); got != "psql -U app -d shop" {
+		t.Errorf("container command = %q", got)
+	}
+
+	sqlite := Connection{Type: "sqlite", Path: "db.sqlite"}
+	if _, err := sqlite.ClientCommand(true); err == nil {
+		t.Error("ClientCommand(true) succeeded for sqlite, want an error")
+	}
+}
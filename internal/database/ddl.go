@@ -0,0 +1,77 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// TableDDL is the DDL that creates one table
+type TableDDL struct {
+	Before []string // Statements the table needs first, such as schemas, types and sequences
+	Create string   // CREATE TABLE IF NOT EXISTS statement
+	After  []string // Statements run once every table exists, such as deferred foreign keys
+}
+
+// createTablePattern matches the start of a CREATE TABLE statement
+var createTablePattern = regexp.MustCompile(`(?i)^\s*CREATE\s+TABLE\s+(IF\s+NOT\s+EXISTS\s+)?`)
+
+// createIfNotExists rewrites a CREATE TABLE statement from the catalog to
+// skip tables that already exist
+func createIfNotExists(statement string) string {
+	return createTablePattern.ReplaceAllString(statement, "CREATE TABLE IF NOT EXISTS ") + ";\n"
+}
+
+// LoadSchema adds the DDL of the tables of the last export to its load
+// steps. Tables are created in the order their first records were written,
+// which puts referenced tables first; foreign keys to tables created later
+// or not exported at all are deferred, and the dialect adds them once every
+// table exists, where it needs to.
+func (e *Exporter) LoadSchema() error {
+	tables, ok := e.catalog.(ddlSource)
+	if !ok {
+		return fmt.Errorf("the export source cannot describe tables as DDL")
+	}
+
+	var before, creates, after []string
+	seen := make(map[string]bool)
+	created := make(map[TableName]bool)
+
+	for _, table := range e.tables {
+		fks, err := e.schema.ForeignKeys(table)
+		if err != nil {
+			return err
+		}
+
+		var deferred []ForeignKey
+		for _, fk := range fks {
+			if fk.ForeignTable != table && !created[fk.ForeignTable] {
+				deferred = append(deferred, fk)
+			}
+		}
+
+		ddl, err := tables.CreateTable(table, deferred)
+		if err != nil {
+			return fmt.Errorf("failed to get schema of table %s: %w", table, err)
+		}
+
+		before = appendNew(before, seen, ddl.Before)
+		creates = append(creates, ddl.Create)
+		after = appendNew(after, seen, ddl.After)
+		created[table] = true
+	}
+
+	e.steps.Schema = append(append(before, creates...), after...)
+	return nil
+}
+
+// appendNew appends the statements not seen before, such as a type used by
+// several tables
+func appendNew(statements []string, seen map[string]bool, add []string) []string {
+	for _, statement := range add {
+		if !seen[statement] {
+			seen[statement] = true
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrNoPrimaryKey is returned by PrimaryKeyColumns for tables without a
+// primary key
+var ErrNoPrimaryKey = errors.New("no primary key")
+
+// Dialect holds the engine-specific parts of the exporter: connecting,
+// reading the catalog, and writing statements the engine understands. Each
+// part is the small interface of the code that uses it. Engines without
+// sequences, separately listed constraints or ALTER support for columns and
+// foreign keys leave those parts nil.
+type Dialect struct {
+	conn        connector
+	catalog     catalogReader
+	keys        keyReader
+	queries     queryBuilder
+	inserts     insertDialect
+	ddl         ddlDialect
+	estimates   estimateDialect
+	sequences   sequenceDialect
+	constraints constraintDialect
+	migrations  migrationDialect
+}
+
+// connector opens a database connection
+type connector interface {
+	// Open connects to the database described by a URL from .agentenv.yml
+	Open(url string) (*sql.DB, error)
+}
+
+// queryBuilder writes the identifiers and bind parameters of queries
+type queryBuilder interface {
+	// QuoteIdentifier quotes a table, schema or column name
+	QuoteIdentifier(name string) string
+
+	// Placeholder returns the bind parameter for the n-th (1-based) argument
+	Placeholder(n int) string
+
+	// MatchAny returns a condition matching rows whose columns equal any of
+	// the value tuples, with its bind arguments
+	MatchAny(columns []string, tuples [][]interface{}) (string, []interface{})
+}
+
+// Column is a table column and its type as declared in the catalog
+type Column struct {
+	Name      string
+	Type      string
+	Generated bool // Computed by the database, which rejects values for it
+}
+
+// Index is a table index with the statements that create and drop it
+type Index struct {
+	Name   string
+	Create string
+	Drop   string
+}
+
+// DialectFor returns the dialect for a database.type value from .agentenv.yml
+func DialectFor(dbType string) (Dialect, error) {
+	switch dbType {
+	case "postgresql", "postgres":
+		return postgresDialect{}.dialect(), nil
+	case "mysql", "mariadb":
+		return mysqlDialect{}.dialect(), nil
+	case "sqlite":
+		return sqliteDialect{}.dialect(), nil
+	default:
+		return Dialect{}, fmt.Errorf("unsupported database type: %q", dbType)
+	}
+}
+
+// queryStrings runs a query returning a single text column
+func queryStrings(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	return values, rows.Err()
+}
+
+// queryTables runs a query returning the table names of a schema
+func queryTables(db *sql.DB, schema string, query string, args ...interface{}) ([]TableName, error) {
+	names, err := queryStrings(db, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]TableName, len(names))
+	for i, name := range names {
+		tables[i] = TableName{Schema: schema, Name: name}
+	}
+	return tables, nil
+}
+
+// queryColumns runs a query returning column names, types and whether the
+// column is generated
+func queryColumns(db *sql.DB, query string, args ...interface{}) ([]Column, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var columns []Column
+	for rows.Next() {
+		var column Column
+		if err := rows.Scan(&column.Name, &column.Type, &column.Generated); err != nil {
+			return nil, err
+		}
+		columns = append(columns, column)
+	}
+
+	return columns, rows.Err()
+}
+
+// queryForeignKeys runs a catalog query returning constraint name, schema,
+// table, column, foreign schema, foreign table, foreign column and whether
+// the constraint is deferrable, in that order. The query must return one
+// row per column pair, ordered by constraint and column position, so that
+// the rows of a composite key can be merged into one ForeignKey.
+func queryForeignKeys(db *sql.DB, query string, args ...interface{}) ([]ForeignKey, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fks []ForeignKey
+	for rows.Next() {
+		var constraintName, column, foreignColumn string
+		var table, foreignTable TableName
+		var deferrable bool
+		if err := rows.Scan(&constraintName, &table.Schema, &table.Name, &column,
+			&foreignTable.Schema, &foreignTable.Name, &foreignColumn, &deferrable); err != nil {
+			return nil, err
+		}
+
+		// Continue the previous constraint for the next column of a composite key
+		if n := len(fks); n > 0 && fks[n-1].ConstraintName == constraintName {
+			fks[n-1].ColumnNames = append(fks[n-1].ColumnNames, column)
+			fks[n-1].ForeignColumnNames = append(fks[n-1].ForeignColumnNames, foreignColumn)
+			continue
+		}
+
+		fks = append(fks, ForeignKey{
+			ConstraintName:     constraintName,
+			Table:              table,
+			ColumnNames:        []string{column},
+			ForeignTable:       foreignTable,
+			ForeignColumnNames: []string{foreignColumn},
+			Deferrable:         deferrable,
+		})
+	}
+
+	return fks, rows.Err()
+}
+
+// matchAnyList builds "a IN (?, ?)" for one column, or
+// "(a = ? AND b = ?) OR (...)" for several, which every engine accepts
+func matchAnyList(d queryBuilder, columns []string, tuples [][]interface{}) (string, []interface{}) {
+	quoted := quoteColumns(d, columns)
+	var args []interface{}
+
+	if len(columns) == 1 {
+		placeholders := make([]string, len(tuples))
+		for i, tuple := range tuples {
+			args = append(args, tuple[0])
+			placeholders[i] = d.Placeholder(len(args))
+		}
+		return fmt.Sprintf("%s IN (%s)", quoted[0], strings.Join(placeholders, ", ")), args
+	}
+
+	alternatives := make([]string, len(tuples))
+	for i, tuple := range tuples {
+		conditions := make([]string, len(columns))
+		for j := range columns {
+			args = append(args, tuple[j])
+			conditions[j] = fmt.Sprintf("%s = %s", quoted[j], d.Placeholder(len(args)))
+		}
+		alternatives[i] = "(" + strings.Join(conditions, " AND ") + ")"
+	}
+	return strings.Join(alternatives, " OR "), args
+}
@@ -0,0 +1,66 @@
+package database
+
+import "testing"
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		name      string
+		dialect   queryBuilder
+		columns   []string
+		tuples    [][]interface{}
+		condition string
+		args      int
+	}{
+		{
+			name:      "postgres single column",
+			dialect:   postgresDialect{},
+			columns:   []string{"id"},
+			tuples:    [][]interface{}{{1}, {2}, {3}},
+			condition: `"id" = ANY($1)`,
+			args:      1,
+		},
+		{
+			name:      "postgres composite",
+			dialect:   postgresDialect{},
+			columns:   []string{"order_id", "line"},
+			tuples:    [][]interface{}{{5, 1}, {5, 2}},
+			condition: `("order_id" = $1 AND "line" = $2) OR ("order_id" = $3 AND "line" = $4)`,
+			args:      4,
+		},
+		{
+			name:      "mysql single column",
+			dialect:   mysqlDialect{},
+			columns:   []string{"id"},
+			tuples:    [][]interface{}{{1}, {2}},
+			condition: "`id` IN (?, ?)",
+			args:      2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			condition, args := tt.dialect.MatchAny(tt.columns, tt.tuples)
+			if condition != tt.condition {
+				t.Errorf("MatchAny condition = %s, want %s", condition, tt.condition)
+			}
+			if len(args) != tt.args {
+				t.Errorf("MatchAny returned %d args, want %d", len(args), tt.args)
+			}
+		})
+	}
+}
+
+func TestRefKey(t *testing.T) {
+	users := TableName{Schema: "main", Name: "users"}
+
+	// Keys read back from different drivers name the same row
+	if refKey(users, []string{"email"}, []interface{}{[]byte("a@example.com")}) != refKey(users, []string{"email"}, []interface{}{"a@example.com"}) {
+		t.Error("refKey differs between bytes and string values")
+	}
+	if refKey(users, []string{"id"}, []interface{}{int64(2)}) != refKey(users, []string{"id"}, []interface{}{2}) {
+		t.Error("refKey differs between int64 and int values")
+	}
+	if refKey(users, []string{"id"}, []interface{}{1}) == refKey(TableName{Schema: "main", Name: "posts"}, []string{"id"}, []interface{}{1}) {
+		t.Error("refKey is the same for rows of different tables")
+	}
+}
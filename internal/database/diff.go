@@ -0,0 +1,385 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SchemaOptions selects what ReadSchema reads besides the catalog
+type SchemaOptions struct {
+	Rows bool // Count the rows of each table
+	DDL  bool // Read the DDL that creates each table
+}
+
+// DatabaseSchema is the catalog of the tables in a database's default schema
+type DatabaseSchema struct {
+	Tables []TableSchema
+}
+
+// TableSchema is what ReadSchema reads about one table
+type TableSchema struct {
+	Table       TableName
+	Columns     []Column
+	PrimaryKey  []string
+	ForeignKeys []ForeignKey
+	Indexes     []Index
+	Constraints []Constraint // Unique, check and exclusion constraints, where the dialect lists them
+	Rows        int64        // Number of rows, or -1 if not counted
+	DDL         TableDDL     // With every foreign key to another table deferred
+}
+
+// Constraint is a named table constraint and its definition
+type Constraint struct {
+	Name       string
+	Definition string
+}
+
+// constraintDialect is implemented by dialects that list unique, check and
+// exclusion constraints apart from the table's columns and indexes
+type constraintDialect interface {
+	Constraints(db *sql.DB, table TableName) ([]Constraint, error)
+}
+
+// ReadSchema reads the tables of a database's default schema
+func ReadSchema(ctx context.Context, dbType string, dbURL string, opts SchemaOptions) (*DatabaseSchema, error) {
+	dialect, err := DialectFor(dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := dialect.conn.Open(dbURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	schema, err := dialect.catalog.DefaultSchema(db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default schema: %w", err)
+	}
+	tables, err := dialect.catalog.Tables(db, schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+
+	source := &sqlSource{db: db, dialect: dialect}
+	result := &DatabaseSchema{}
+	for _, table := range tables {
+		t, err := readTableSchema(ctx, source, table, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read table %s: %w", table, err)
+		}
+		result.Tables = append(result.Tables, t)
+	}
+
+	return result, nil
+}
+
+// readTableSchema reads the catalog entries of one table
+func readTableSchema(ctx context.Context, source *sqlSource, table TableName, opts SchemaOptions) (TableSchema, error) {
+	t := TableSchema{Table: table, Rows: -1}
+	var err error
+
+	if t.Columns, err = source.TableColumns(table); err != nil {
+		return t, err
+	}
+	// Log and join tables often have no primary key, which is not an error here
+	if t.PrimaryKey, err = source.PrimaryKeyColumns(table); err != nil && !errors.Is(err, ErrNoPrimaryKey) {
+		return t, err
+	}
+	if t.ForeignKeys, err = source.ForeignKeys(table); err != nil {
+		return t, err
+	}
+	if t.Indexes, err = source.dialect.ddl.Indexes(source.db, table); err != nil {
+		return t, err
+	}
+	if constraints := source.dialect.constraints; constraints != nil {
+		if t.Constraints, err = constraints.Constraints(source.db, table); err != nil {
+			return t, err
+		}
+	}
+
+	if opts.Rows {
+		if t.Rows, err = source.CountRows(ctx, RowQuery{Table: table}); err != nil {
+			return t, err
+		}
+	}
+	if opts.DDL {
+		var deferred []ForeignKey
+		for _, fk := range t.ForeignKeys {
+			if fk.ForeignTable != table {
+				deferred = append(deferred, fk)
+			}
+		}
+		if t.DDL, err = source.CreateTable(table, deferred); err != nil {
+			return t, err
+		}
+	}
+
+	return t, nil
+}
+
+// SchemaDiff is what changed from a base database's schema to a target's.
+// Tables are matched by name, whatever schema they are in.
+type SchemaDiff struct {
+	Added   []TableSchema // Tables only in the target
+	Dropped []TableSchema // Tables only in the base
+	Changed []TableDiff   // Tables in both that differ
+}
+
+// TableDiff is what changed in a table both databases have
+type TableDiff struct {
+	Table              TableName // The table in the target
+	AddedColumns       []Column
+	DroppedColumns     []Column
+	ChangedColumns     []ColumnChange
+	OldPrimaryKey      []string // Both set if the primary key changed
+	NewPrimaryKey      []string
+	AddedForeignKeys   []ForeignKey
+	DroppedForeignKeys []ForeignKey
+	AddedIndexes       []Index
+	DroppedIndexes     []Index
+	AddedConstraints   []Constraint
+	DroppedConstraints []Constraint
+	OldRows            int64 // Row counts, -1 if not counted
+	NewRows            int64
+}
+
+// ColumnChange is a column whose type changed
+type ColumnChange struct {
+	Name    string
+	OldType string
+	NewType string
+}
+
+// Empty reports whether the databases have the same tables, columns, keys,
+// indexes, constraints and, where counted, row counts
+func (d *SchemaDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Dropped) == 0 && len(d.Changed) == 0
+}
+
+// SchemaChanged reports whether anything besides the row count changed
+func (t *TableDiff) SchemaChanged() bool {
+	return len(t.AddedColumns)+len(t.DroppedColumns)+len(t.ChangedColumns)+len(t.OldPrimaryKey)+len(t.NewPrimaryKey)+
+		len(t.AddedForeignKeys)+len(t.DroppedForeignKeys)+len(t.AddedIndexes)+len(t.DroppedIndexes)+
+		len(t.AddedConstraints)+len(t.DroppedConstraints) > 0
+}
+
+// rowsChanged reports whether both row counts are known and differ
+func (t *TableDiff) rowsChanged() bool {
+	return t.OldRows >= 0 && t.NewRows >= 0 && t.OldRows != t.NewRows
+}
+
+// DiffSchemas compares the schema of a base database with a target's
+func DiffSchemas(base, target *DatabaseSchema) *SchemaDiff {
+	baseTables := make(map[string]TableSchema)
+	for _, t := range base.Tables {
+		baseTables[t.Table.Name] = t
+	}
+	targetTables := make(map[string]bool)
+
+	diff := &SchemaDiff{}
+	for _, t := range target.Tables {
+		targetTables[t.Table.Name] = true
+		old, ok := baseTables[t.Table.Name]
+		if !ok {
+			diff.Added = append(diff.Added, t)
+			continue
+		}
+		if changes := diffTable(old, t); changes.SchemaChanged() || changes.rowsChanged() {
+			diff.Changed = append(diff.Changed, changes)
+		}
+	}
+	for _, t := range base.Tables {
+		if !targetTables[t.Table.Name] {
+			diff.Dropped = append(diff.Dropped, t)
+		}
+	}
+
+	return diff
+}
+
+// diffTable compares the two versions of a table
+func diffTable(old, new TableSchema) TableDiff {
+	diff := TableDiff{Table: new.Table, OldRows: old.Rows, NewRows: new.Rows}
+
+	oldTypes := make(map[string]string)
+	for _, column := range old.Columns {
+		oldTypes[column.Name] = column.Type
+	}
+	newTypes := make(map[string]bool)
+	for _, column := range new.Columns {
+		newTypes[column.Name] = true
+		oldType, ok := oldTypes[column.Name]
+		switch {
+		case !ok:
+			diff.AddedColumns = append(diff.AddedColumns, column)
+		case oldType != column.Type:
+			diff.ChangedColumns = append(diff.ChangedColumns, ColumnChange{Name: column.Name, OldType: oldType, NewType: column.Type})
+		}
+	}
+	for _, column := range old.Columns {
+		if !newTypes[column.Name] {
+			diff.DroppedColumns = append(diff.DroppedColumns, column)
+		}
+	}
+
+	if strings.Join(old.PrimaryKey, ",") != strings.Join(new.PrimaryKey, ",") {
+		diff.OldPrimaryKey, diff.NewPrimaryKey = old.PrimaryKey, new.PrimaryKey
+	}
+
+	diff.AddedForeignKeys, diff.DroppedForeignKeys = diffForeignKeys(old.ForeignKeys, new.ForeignKeys)
+	diff.AddedIndexes, diff.DroppedIndexes = diffIndexes(old.Indexes, new.Indexes)
+	diff.AddedConstraints, diff.DroppedConstraints = diffConstraints(old.Constraints, new.Constraints)
+	return diff
+}
+
+// diffForeignKeys compares foreign keys by their columns and the columns
+// they reference, since SQLite makes up constraint names
+func diffForeignKeys(old, new []ForeignKey) (added, dropped []ForeignKey) {
+	seen := make(map[string]bool)
+	for _, fk := range old {
+		seen[foreignKeySignature(fk)] = true
+	}
+	kept := make(map[string]bool)
+	for _, fk := range new {
+		kept[foreignKeySignature(fk)] = true
+		if !seen[foreignKeySignature(fk)] {
+			added = append(added, fk)
+		}
+	}
+	for _, fk := range old {
+		if !kept[foreignKeySignature(fk)] {
+			dropped = append(dropped, fk)
+		}
+	}
+	return added, dropped
+}
+
+// foreignKeySignature identifies a foreign key apart from its name and
+// the schemas of its tables
+func foreignKeySignature(fk ForeignKey) string {
+	return fmt.Sprintf("(%s) %s (%s) %t", strings.Join(fk.ColumnNames, ","), fk.ForeignTable.Name,
+		strings.Join(fk.ForeignColumnNames, ","), fk.Deferrable)
+}
+
+// diffIndexes compares indexes by name and definition
+func diffIndexes(old, new []Index) (added, dropped []Index) {
+	seen := make(map[Index]bool)
+	for _, index := range old {
+		seen[index] = true
+	}
+	kept := make(map[Index]bool)
+	for _, index := range new {
+		kept[index] = true
+		if !seen[index] {
+			added = append(added, index)
+		}
+	}
+	for _, index := range old {
+		if !kept[index] {
+			dropped = append(dropped, index)
+		}
+	}
+	return added, dropped
+}
+
+// diffConstraints compares constraints by name and definition
+func diffConstraints(old, new []Constraint) (added, dropped []Constraint) {
+	seen := make(map[Constraint]bool)
+	for _, c := range old {
+		seen[c] = true
+	}
+	kept := make(map[Constraint]bool)
+	for _, c := range new {
+		kept[c] = true
+		if !seen[c] {
+			added = append(added, c)
+		}
+	}
+	for _, c := range old {
+		if !kept[c] {
+			dropped = append(dropped, c)
+		}
+	}
+	return added, dropped
+}
+
+// WriteSchemaDiff writes a readable report of a diff: "+" for what the
+// target added, "-" for what it dropped and "~" for what it changed
+func WriteSchemaDiff(w io.Writer, diff *SchemaDiff) {
+	if diff.Empty() {
+		fmt.Fprintln(w, "No differences")
+		return
+	}
+
+	for _, t := range diff.Added {
+		fmt.Fprintf(w, "+ table %s (%s)\n", t.Table.Name, tableSummary(t))
+	}
+	for _, t := range diff.Dropped {
+		fmt.Fprintf(w, "- table %s (%s)\n", t.Table.Name, tableSummary(t))
+	}
+	for _, t := range diff.Changed {
+		fmt.Fprintf(w, "~ table %s\n", t.Table.Name)
+		writeTableDiff(w, t)
+	}
+}
+
+// tableSummary describes the size of an added or dropped table
+func tableSummary(t TableSchema) string {
+	summary := fmt.Sprintf("%d columns", len(t.Columns))
+	if t.Rows >= 0 {
+		summary += fmt.Sprintf(", %d rows", t.Rows)
+	}
+	return summary
+}
+
+// writeTableDiff writes the changes to one table
+func writeTableDiff(w io.Writer, t TableDiff) {
+	for _, column := range t.AddedColumns {
+		fmt.Fprintf(w, "    + column %s %s\n", column.Name, column.Type)
+	}
+	for _, column := range t.DroppedColumns {
+		fmt.Fprintf(w, "    - column %s %s\n", column.Name, column.Type)
+	}
+	for _, change := range t.ChangedColumns {
+		fmt.Fprintf(w, "    ~ column %s: %s → %s\n", change.Name, change.OldType, change.NewType)
+	}
+	if len(t.NewPrimaryKey) > 0 || len(t.OldPrimaryKey) > 0 {
+		fmt.Fprintf(w, "    ~ primary key: (%s) → (%s)\n", strings.Join(t.OldPrimaryKey, ", "), strings.Join(t.NewPrimaryKey, ", "))
+	}
+	for _, fk := range t.AddedForeignKeys {
+		fmt.Fprintf(w, "    + foreign key %s\n", describeForeignKey(fk))
+	}
+	for _, fk := range t.DroppedForeignKeys {
+		fmt.Fprintf(w, "    - foreign key %s\n", describeForeignKey(fk))
+	}
+	for _, index := range t.AddedIndexes {
+		fmt.Fprintf(w, "    + index %s\n", index.Name)
+	}
+	for _, index := range t.DroppedIndexes {
+		fmt.Fprintf(w, "    - index %s\n", index.Name)
+	}
+	for _, c := range t.AddedConstraints {
+		fmt.Fprintf(w, "    + constraint %s: %s\n", c.Name, c.Definition)
+	}
+	for _, c := range t.DroppedConstraints {
+		fmt.Fprintf(w, "    - constraint %s: %s\n", c.Name, c.Definition)
+	}
+	if t.rowsChanged() {
+		fmt.Fprintf(w, "    ~ rows: %d → %d (%+d)\n", t.OldRows, t.NewRows, t.NewRows-t.OldRows)
+	}
+}
+
+// describeForeignKey writes a foreign key as "(columns) → table (columns)"
+func describeForeignKey(fk ForeignKey) string {
+	return fmt.Sprintf("(%s) → %s (%s)", strings.Join(fk.ColumnNames, ", "), fk.ForeignTable.Name, strings.Join(fk.ForeignColumnNames, ", "))
+}
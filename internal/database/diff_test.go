@@ -0,0 +1,121 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+// execSQLite runs statements against an SQLite database file
+func execSQLite(t *testing.T, path string, statements string) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(statements); err != nil {
+		t.Fatalf("failed to run %q: %v", statements, err)
+	}
+}
+
+// readSQLiteSchema reads the schema of an SQLite database file
+func readSQLiteSchema(t *testing.T, path string, opts SchemaOptions) *DatabaseSchema {
+	t.Helper()
+
+	schema, err := ReadSchema(context.Background(), "sqlite", path, opts)
+	if err != nil {
+		t.Fatalf("ReadSchema failed: %v", err)
+	}
+	return schema
+}
+
+func TestSQLiteSchemaDiff(t *testing.T) {
+	base := createSQLiteFixture(t)
+	agent := createSQLiteFixture(t)
+	execSQLite(t, agent, `
+		ALTER TABLE users ADD COLUMN nickname TEXT;
+		ALTER TABLE posts DROP COLUMN title;
+		CREATE INDEX posts_user_id ON posts (user_id);
+		CREATE TABLE comments (id INTEGER PRIMARY KEY, post_id INTEGER REFERENCES posts (id), body TEXT);
+		DROP TABLE shipments;
+		INSERT INTO users (id, email) VALUES (2, 'new@example.com');
+	`)
+
+	opts := SchemaOptions{Rows: true, DDL: true}
+	diff := DiffSchemas(readSQLiteSchema(t, base, opts), readSQLiteSchema(t, agent, opts))
+
+	var report bytes.Buffer
+	WriteSchemaDiff(&report, diff)
+	expected := `+ table comments (3 columns, 0 rows)
+- table shipments (3 columns, 1 rows)
+~ table posts
+    - column title TEXT
+    + index posts_user_id
+~ table users
+    + column nickname TEXT
+    ~ rows: 1 → 2 (+1)
+`
+	if report.String() != expected {
+		t.Errorf("report =\n%s\nwant\n%s", report.String(), expected)
+	}
+
+	// Applying the migration to the base gives it the agent's schema
+	var migration bytes.Buffer
+	if err := WriteMigration(&migration, "sqlite", diff); err != nil {
+		t.Fatalf("WriteMigration failed: %v", err)
+	}
+	execSQLite(t, base, migration.String())
+
+	after := DiffSchemas(readSQLiteSchema(t, base, SchemaOptions{}), readSQLiteSchema(t, agent, SchemaOptions{}))
+	if !after.Empty() {
+		var remaining bytes.Buffer
+		WriteSchemaDiff(&remaining, after)
+		t.Errorf("schemas still differ after the migration:\n%s\nmigration:\n%s", remaining.String(), migration.String())
+	}
+}
+
+func TestWriteMigrationComments(t *testing.T) {
+	users := TableName{Schema: "main", Name: "users"}
+	diff := &SchemaDiff{Changed: []TableDiff{{
+		Table:          users,
+		ChangedColumns: []ColumnChange{{Name: "age", OldType: "TEXT", NewType: "INTEGER"}},
+		AddedForeignKeys: []ForeignKey{{ConstraintName: "users_org_id_fkey", Table: users, ColumnNames: []string{"org_id"},
+			ForeignTable: TableName{Schema: "main", Name: "orgs"}, ForeignColumnNames: []string{"id"}}},
+	}}}
+
+	tests := []struct {
+		dbType   string
+		expected []string
+	}{
+		{"sqlite", []string{
+			"-- Rebuild users to change the type of age from TEXT to INTEGER",
+			"-- Rebuild users to add its foreign key (org_id) → orgs (id)",
+		}},
+		{"postgres", []string{
+			`ALTER TABLE "users" ALTER COLUMN "age" TYPE INTEGER;`,
+			`ALTER TABLE "users" ADD CONSTRAINT "users_org_id_fkey" FOREIGN KEY ("org_id") REFERENCES "orgs" ("id");`,
+		}},
+		{"mysql", []string{
+			"ALTER TABLE `users` MODIFY COLUMN `age` INTEGER;",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dbType, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteMigration(&buf, tt.dbType, diff); err != nil {
+				t.Fatalf("WriteMigration failed: %v", err)
+			}
+			for _, line := range tt.expected {
+				if !strings.Contains(buf.String(), line) {
+					t.Errorf("migration does not contain %q:\n%s", line, buf.String())
+				}
+			}
+		})
+	}
+}
@@ -1,309 +1,736 @@
 package database
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
 	"io"
+	"os"
 	"strings"
 	"time"
-
-	_ "github.com/lib/pq" // PostgreSQL driver
 )
 
-// ExportOptions contains configuration for database export
+// ExportOptions controls how far an export walks the foreign key graph.
+// Zero values mean no limit.
 type ExportOptions struct {
-	Table      string
-	ID         interface{}
-	OutputFile string
-	DBUrl      string
+	IncludeChildren bool     // Also export rows that reference exported rows
+	MaxDepth        int      // Maximum foreign key hops from the root, in any direction
+	MaxParentDepth  int      // Maximum hops to referenced (parent) rows
+	MaxChildDepth   int      // Maximum hops to referencing (child) rows
+	IncludeTables   []string // Only follow foreign keys into these tables
+	ExcludeTables   []string // Never follow foreign keys into these tables
+	MaxRows         int      // Fail once the export would exceed this many rows
+	MaxBufferedRows int      // Fail once more rows than this wait for the rows they reference
 }
 
-// ForeignKey represents a foreign key relationship
+// ForeignKey represents a foreign key relationship. Composite keys list
+// their columns in constraint order, paired by position.
 type ForeignKey struct {
-	ConstraintName    string
-	TableName         string
-	ColumnName        string
-	ForeignTableName  string
-	ForeignColumnName string
+	ConstraintName     string
+	Table              TableName
+	ColumnNames        []string
+	ForeignTable       TableName
+	ForeignColumnNames []string
+	Deferrable         bool // Whether checks can be deferred to commit
 }
 
 // Record represents a database record with table and column data
 type Record struct {
-	Table   string
+	Table   TableName
 	Columns []string
+	Types   []string // Column types from the catalog, if known
 	Values  []interface{}
 }
 
-// Exporter handles database export operations
+// Exporter handles database export operations. Records are written to a
+// RecordWriter as soon as the records they reference are written, so only
+// records waiting for their parents are held in memory.
 type Exporter struct {
-	db      *sql.DB
-	visited map[string]bool // Track visited records to avoid cycles
-	records []Record        // Collected records in dependency order
+	catalog     SchemaSource // Tables, columns and keys of the source
+	source      RowSource    // Rows of the source
+	closer      io.Closer    // Connection closed with the exporter, if any
+	opts        ExportOptions
+	schema      *schemaCache             // Catalog lookups of the current export
+	visited     map[string]bool          // Track visited records to avoid cycles
+	reasons     map[TableName]string     // Why each table is part of the export
+	skipped     int                      // Parent references not followed because of limits or filters
+	queue       []*batch                 // Rows to fetch in the next round of the traversal
+	progress    io.Writer                // Where progress is reported, if anywhere
+	steps       LoadSteps                // What loading the last export needs besides its inserts
+	out         RecordWriter             // Where records are written
+	found       int                      // Records visited so far, numbering pending records
+	pending     map[int]*pendingRecord   // Visited records not written yet
+	ready       indexHeap                // Pending records that wait for nothing
+	waiters     map[string][]int         // Pending records by the refKey of a row they wait for
+	waitColumns map[TableName][][]string // Referenced column lists that records wait for
+	written     map[string]bool          // refKeys of rows written
+	tables      []TableName              // Tables in the order of their first written record
+	rows        map[TableName]int        // Records written per table
+}
+
+// hop describes how the traversal reached a record
+type hop struct {
+	parentDepth int    // Parent edges followed from the root
+	childDepth  int    // Child edges followed from the root
+	children    bool   // Whether rows referencing this record are followed
+	reason      string // Why the record's table is part of the export
+	required    bool   // Whether a missing row is an error rather than a warning
 }
 
-// NewExporter creates a new database exporter
-func NewExporter(dbUrl string) (*Exporter, error) {
-	db, err := sql.Open("postgres", dbUrl)
+// batch collects the rows to fetch from one table by one set of columns,
+// so that they can be loaded with a single query
+type batch struct {
+	table   TableName
+	columns []string
+	values  [][]interface{} // Value tuples to match, in request order
+	hops    map[string]hop  // How each tuple was reached, by formatted values
+}
+
+// fetchBatchSize caps the value tuples matched by one query
+const fetchBatchSize = 500
+
+// NewExporter creates a new database exporter for the given database.type
+func NewExporter(ctx context.Context, dbType string, dbUrl string) (*Exporter, error) {
+	dialect, err := DialectFor(dbType)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := dialect.conn.Open(dbUrl)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
-	if err := db.Ping(); err != nil {
+	if err := db.PingContext(ctx); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
+	source := &sqlSource{db: db, dialect: dialect}
+	e := NewSourceExporter(source, source)
+	e.closer = db
+	return e, nil
+}
+
+// NewSourceExporter creates an exporter that reads tables from schema and
+// rows from rows, such as a MemorySource in tests
+func NewSourceExporter(schema SchemaSource, rows RowSource) *Exporter {
 	return &Exporter{
-		db:      db,
+		catalog: schema,
+		source:  rows,
+		schema:  newSchemaCache(schema),
 		visited: make(map[string]bool),
-		records: []Record{},
-	}, nil
+		reasons: make(map[TableName]string),
+	}
 }
 
 // Close closes the database connection
 func (e *Exporter) Close() error {
-	return e.db.Close()
+	if e.closer == nil {
+		return nil
+	}
+	return e.closer.Close()
 }
 
-// Export recursively exports a record and all its dependencies
-func (e *Exporter) Export(table string, id interface{}) ([]Record, error) {
-	// Reset state for new export
-	e.visited = make(map[string]bool)
-	e.records = []Record{}
+// SetProgress reports the rows and tables visited to w as an export runs,
+// along with its warnings, which otherwise go to stderr
+func (e *Exporter) SetProgress(w io.Writer) {
+	e.progress = w
+}
 
-	// Start recursive export
-	if err := e.exportRecord(table, id); err != nil {
-		return nil, err
+// Reason returns why a table is part of the last export, e.g.
+// "parent of posts via posts(user_id)"
+func (e *Exporter) Reason(table TableName) string {
+	return e.reasons[table]
+}
+
+// Skipped returns how many references to parent rows the last export did
+// not follow because of depth limits or table filters
+func (e *Exporter) Skipped() int {
+	return e.skipped
+}
+
+// Tables returns the tables of the last export, in the order of their first
+// written record
+func (e *Exporter) Tables() []TableName {
+	return e.tables
+}
+
+// Rows returns how many records of a table the last export wrote
+func (e *Exporter) Rows(table TableName) int {
+	return e.rows[table]
+}
+
+// ForeignKeys returns the foreign keys of and to the tables of the last export
+func (e *Exporter) ForeignKeys() []ForeignKey {
+	return e.schema.AllForeignKeys()
+}
+
+// FollowMasking wraps a writer so that masker follows the foreign keys of
+// each table before its first record is written. A record's parents are
+// written before it, so their rules are in place by then.
+func (e *Exporter) FollowMasking(masker *Masker, w RecordWriter) RecordWriter {
+	if masker == nil {
+		return w
 	}
+	return &maskingWriter{RecordWriter: w, exporter: e, masker: masker, seen: make(map[TableName]bool)}
+}
 
-	return e.records, nil
+// maskingWriter is the RecordWriter of FollowMasking
+type maskingWriter struct {
+	RecordWriter
+	exporter *Exporter
+	masker   *Masker
+	seen     map[TableName]bool
 }
 
-// exportRecord recursively exports a single record and its dependencies
-func (e *Exporter) exportRecord(table string, id interface{}) error {
-	// Create unique key for this record
-	recordKey := fmt.Sprintf("%s:%v", table, id)
+func (w *maskingWriter) WriteRecord(record Record) error {
+	if !w.seen[record.Table] {
+		w.seen[record.Table] = true
+		w.masker.FollowForeignKeys(w.exporter.ForeignKeys())
+	}
+	return w.RecordWriter.WriteRecord(record)
+}
 
-	// Skip if already visited (handles circular dependencies)
-	if e.visited[recordKey] {
-		return nil
+// SchemaFingerprint hashes the current columns and keys of tables, to tell
+// whether an export made earlier still matches the schema
+func (e *Exporter) SchemaFingerprint(tables []TableName) (string, error) {
+	return newSchemaCache(e.catalog).Fingerprint(tables)
+}
+
+// LoadSteps returns what loading the last export needs besides inserting its
+// records in order: cycle breaking and sequence resets
+func (e *Exporter) LoadSteps() *LoadSteps {
+	return &e.steps
+}
+
+// Roots selects the rows an export starts from: explicit primary keys, rows
+// matching a WHERE condition, or both. All roots share one traversal, so
+// rows reachable from several roots are exported once.
+type Roots struct {
+	Table TableName
+	Keys  []Key  // Primary keys of root rows
+	Where string // SQL condition selecting root rows
+	Limit int    // Maximum number of rows selected by Where (0: unlimited)
+}
+
+// Export exports the root rows and all their dependencies and returns them
+// in dependency order. It holds the whole export in memory; ExportTo
+// streams it instead.
+func (e *Exporter) Export(ctx context.Context, roots Roots, opts ExportOptions) ([]Record, error) {
+	collector := &recordCollector{}
+	if err := e.ExportTo(ctx, roots, opts, collector); err != nil {
+		return nil, err
 	}
-	e.visited[recordKey] = true
+	return collector.records, nil
+}
 
-	// Get the primary key column name
-	pkColumn, err := e.getPrimaryKeyColumn(table)
-	if err != nil {
-		return fmt.Errorf("failed to get primary key for table %s: %w", table, err)
+// ExportTo exports the root rows and all their dependencies to w, in
+// dependency order, without closing it. A table without a schema is looked
+// up in the connection's default schema. The foreign key graph is walked
+// breadth-first, loading the rows of each round with one query per table
+// and key, and each record is written once the records it references are.
+func (e *Exporter) ExportTo(ctx context.Context, roots Roots, opts ExportOptions, w RecordWriter) error {
+	e.reset(opts, w)
+
+	table := roots.Table
+	if table.Schema == "" {
+		schema, err := e.catalog.DefaultSchema()
+		if err != nil {
+			return fmt.Errorf("failed to get default schema: %w", err)
+		}
+		table.Schema = schema
 	}
 
-	// Get foreign keys that this record references
-	foreignKeys, err := e.getForeignKeys(table)
+	pkColumns, err := e.schema.PrimaryKey(table)
 	if err != nil {
-		return fmt.Errorf("failed to get foreign keys for table %s: %w", table, err)
+		return err
 	}
 
-	// Fetch the record
-	record, err := e.fetchRecord(table, pkColumn, id)
-	if err != nil {
-		return fmt.Errorf("failed to fetch record from %s: %w", table, err)
+	root := hop{children: opts.IncludeChildren, reason: "root", required: true}
+
+	// Queue each explicit key
+	for _, key := range roots.Keys {
+		values, err := key.valuesFor(pkColumns)
+		if err != nil {
+			return fmt.Errorf("invalid key for table %s: %w", table, err)
+		}
+		e.enqueue(table, pkColumns, values, root)
+	}
+	if err := e.fetchRound(ctx); err != nil {
+		return err
 	}
 
-	if record == nil {
-		return fmt.Errorf("record not found: %s.%s = %v", table, pkColumn, id)
+	// Visit each row matching the WHERE condition (or LIMIT alone)
+	if roots.Where != "" || roots.Limit > 0 {
+		if err := e.visitRoots(ctx, table, pkColumns, roots); err != nil {
+			return fmt.Errorf("failed to select root rows from %s: %w", table, err)
+		}
 	}
 
-	// Recursively export foreign key dependencies first
-	for _, fk := range foreignKeys {
-		// Find the column index
-		colIndex := -1
-		for i, col := range record.Columns {
-			if col == fk.ColumnName {
-				colIndex = i
-				break
-			}
+	if err := e.traverse(ctx); err != nil {
+		return err
+	}
+	if err := e.writeCycles(); err != nil {
+		return err
+	}
+
+	return e.loadSequences()
+}
+
+// reset clears the state of the previous export
+func (e *Exporter) reset(opts ExportOptions, w RecordWriter) {
+	e.opts = opts
+	e.schema = newSchemaCache(e.catalog)
+	e.visited = make(map[string]bool)
+	e.reasons = make(map[TableName]string)
+	e.skipped = 0
+	e.queue = nil
+	e.steps = LoadSteps{}
+	e.out = w
+	e.found = 0
+	e.pending = make(map[int]*pendingRecord)
+	e.ready = nil
+	e.waiters = make(map[string][]int)
+	e.waitColumns = make(map[TableName][][]string)
+	e.written = make(map[string]bool)
+	e.tables = nil
+	e.rows = make(map[TableName]int)
+}
+
+// visitRoots visits the rows matching the roots' WHERE condition, ordered
+// by primary key so that a LIMIT picks the same rows every time. Whenever a
+// batch of roots waits for its parents, the traversal catches up, so that
+// large selections are not held in memory.
+func (e *Exporter) visitRoots(ctx context.Context, table TableName, pkColumns []string, roots Roots) error {
+	q := RowQuery{Table: table, Where: roots.Where, OrderBy: pkColumns, Limit: roots.Limit}
+	root := hop{children: e.opts.IncludeChildren, reason: "root", required: true}
+	return e.eachRecord(ctx, q, func(record *Record) error {
+		if err := e.visitRecord(record, root); err != nil {
+			return err
 		}
+		if err := e.writeReady(); err != nil {
+			return err
+		}
+		if len(e.pending) >= fetchBatchSize {
+			return e.traverse(ctx)
+		}
+		return nil
+	})
+}
 
-		if colIndex == -1 {
-			continue
+// traverse fetches queued rows round by round until nothing new is queued
+func (e *Exporter) traverse(ctx context.Context) error {
+	for len(e.queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := e.fetchRound(ctx); err != nil {
+			return err
 		}
+	}
 
-		// Get the foreign key value
-		fkValue := record.Values[colIndex]
+	e.reportProgress(true)
+	return nil
+}
 
-		// Skip NULL foreign keys
-		if fkValue == nil {
-			continue
+// fetchRound fetches the rows queued so far. Rows they lead to are queued
+// for the next round.
+func (e *Exporter) fetchRound(ctx context.Context) error {
+	round := e.queue
+	e.queue = nil
+
+	for _, b := range round {
+		if err := e.fetchBatch(ctx, b); err != nil {
+			return err
 		}
+		e.reportProgress(false)
+	}
+	return nil
+}
 
-		// Recursively export the referenced record
-		if err := e.exportRecord(fk.ForeignTableName, fkValue); err != nil {
-			// Log warning but continue - some FKs might be optional
-			fmt.Printf("Warning: failed to export FK %s.%s -> %s: %v\n",
-				table, fk.ColumnName, fk.ForeignTableName, err)
+// enqueue asks for the rows of table whose columns equal values in the next
+// round. The first request for a tuple decides how its rows were reached.
+func (e *Exporter) enqueue(table TableName, columns []string, values []interface{}, h hop) {
+	var b *batch
+	for _, queued := range e.queue {
+		if queued.table == table && sameColumns(queued.columns, columns) {
+			b = queued
+			break
 		}
 	}
+	if b == nil {
+		b = &batch{table: table, columns: columns, hops: make(map[string]hop)}
+		e.queue = append(e.queue, b)
+	}
 
-	// Add this record after its dependencies
-	e.records = append(e.records, *record)
+	id := keyID(values)
+	if _, ok := b.hops[id]; !ok {
+		b.hops[id] = h
+		b.values = append(b.values, values)
+	}
+}
 
+// fetchBatch loads the rows of a batch, a chunk of value tuples at a time,
+// visits them in request order and writes the records that are ready
+func (e *Exporter) fetchBatch(ctx context.Context, b *batch) error {
+	for start := 0; start < len(b.values); start += fetchBatchSize {
+		end := min(start+fetchBatchSize, len(b.values))
+		if err := e.fetchChunk(ctx, b, b.values[start:end]); err != nil {
+			return err
+		}
+		if err := e.writeReady(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// fetchRecord retrieves a single record from the database
-func (e *Exporter) fetchRecord(table string, pkColumn string, id interface{}) (*Record, error) {
-	// Get column names
-	columns, err := e.getTableColumns(table)
+// fetchChunk loads and visits the rows of some of a batch's value tuples
+func (e *Exporter) fetchChunk(ctx context.Context, b *batch, tuples [][]interface{}) error {
+	records, err := e.queryRecords(ctx, RowQuery{Table: b.table, Match: b.columns, Tuples: tuples})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("failed to fetch records from %s: %w", b.table, err)
 	}
 
-	// Build query
-	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1",
-		strings.Join(columns, ", "),
-		table,
-		pkColumn,
-	)
+	found := make(map[string][]Record)
+	for _, record := range records {
+		values, _ := record.columnValues(b.columns)
+		id := keyID(values)
+		found[id] = append(found[id], record)
+	}
 
-	// Execute query
-	row := e.db.QueryRow(query, id)
+	for _, values := range tuples {
+		id := keyID(values)
+		h := b.hops[id]
 
-	// Prepare scan destinations
-	values := make([]interface{}, len(columns))
-	valuePtrs := make([]interface{}, len(columns))
-	for i := range values {
-		valuePtrs[i] = &values[i]
-	}
+		// Report referenced rows that do not exist. Only parents are reached
+		// without following children, and a record may have no children.
+		if len(found[id]) == 0 && h.required {
+			return fmt.Errorf("record not found: %s (%s) = (%s)", b.table, strings.Join(b.columns, ", "), id)
+		}
+		if len(found[id]) == 0 && !h.children {
+			e.warn("record not found: %s (%s) = (%s), %s",
+				b.table, strings.Join(b.columns, ", "), id, h.reason)
+			// Records referencing the missing row are written without it
+			e.resolve(refKey(b.table, b.columns, values))
+		}
 
-	// Scan row
-	if err := row.Scan(valuePtrs...); err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		for i := range found[id] {
+			if err := e.visitRecord(&found[id][i], h); err != nil {
+				return err
+			}
 		}
-		return nil, err
 	}
 
-	return &Record{
-		Table:   table,
-		Columns: columns,
-		Values:  values,
-	}, nil
+	return nil
 }
 
-// getTableColumns returns all column names for a table
-func (e *Exporter) getTableColumns(table string) ([]string, error) {
-	query := `
-		SELECT column_name
-		FROM information_schema.columns
-		WHERE table_name = $1
-		ORDER BY ordinal_position
-	`
-
-	rows, err := e.db.Query(query, table)
+// visitRecord adds a fetched record to the export and queues the records it
+// references and, if enabled, the records referencing it. The record waits
+// to be written until the records it references are written.
+func (e *Exporter) visitRecord(record *Record, h hop) error {
+	// Mark the full primary key tuple as visited before following foreign keys
+	key, err := e.recordID(record)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if e.visited[key] {
+		// A row fetched again by other columns may be awaited by them now
+		return e.revisit(record)
+	}
+	if e.opts.MaxRows > 0 && len(e.visited) >= e.opts.MaxRows {
+		return fmt.Errorf("export exceeds the maximum of %d rows", e.opts.MaxRows)
 	}
-	defer rows.Close()
+	e.visited[key] = true
 
-	var columns []string
-	for rows.Next() {
-		var col string
-		if err := rows.Scan(&col); err != nil {
-			return nil, err
+	if _, ok := e.reasons[record.Table]; !ok {
+		e.reasons[record.Table] = h.reason
+	}
+	id := e.addPending(*record)
+
+	if err := e.queueParents(record, h, id); err != nil {
+		return err
+	}
+
+	if h.children && e.withinDepth(h.parentDepth, h.childDepth+1) {
+		if err := e.queueChildren(record, h); err != nil {
+			return err
 		}
-		columns = append(columns, col)
 	}
 
-	return columns, nil
+	e.release(id)
+	return nil
 }
 
-// getPrimaryKeyColumn returns the primary key column name for a table
-func (e *Exporter) getPrimaryKeyColumn(table string) (string, error) {
-	query := `
-		SELECT a.attname
-		FROM pg_index i
-		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
-		WHERE i.indrelid = $1::regclass
-		AND i.indisprimary
-	`
+// revisit marks a record visited before as written again if it was, so
+// that records waiting for it by columns other than its primary key are
+// released. A record still pending does this once it is written.
+func (e *Exporter) revisit(record *Record) error {
+	pkColumns, err := e.schema.PrimaryKey(record.Table)
+	if err != nil {
+		return err
+	}
+	if pkValues, ok := record.columnValues(pkColumns); ok && e.written[refKey(record.Table, pkColumns, pkValues)] {
+		return e.provide(record)
+	}
+	return nil
+}
 
-	var pkColumn string
-	err := e.db.QueryRow(query, table).Scan(&pkColumn)
+// recordID returns the visited-set key of a record
+func (e *Exporter) recordID(record *Record) (string, error) {
+	pkColumns, err := e.schema.PrimaryKey(record.Table)
 	if err != nil {
 		return "", err
 	}
 
-	return pkColumn, nil
-}
-
-// getForeignKeys returns all foreign key relationships for a table
-func (e *Exporter) getForeignKeys(table string) ([]ForeignKey, error) {
-	query := `
-		SELECT
-			tc.constraint_name,
-			tc.table_name,
-			kcu.column_name,
-			ccu.table_name AS foreign_table_name,
-			ccu.column_name AS foreign_column_name
-		FROM information_schema.table_constraints AS tc
-		JOIN information_schema.key_column_usage AS kcu
-			ON tc.constraint_name = kcu.constraint_name
-			AND tc.table_schema = kcu.table_schema
-		JOIN information_schema.constraint_column_usage AS ccu
-			ON ccu.constraint_name = tc.constraint_name
-			AND ccu.table_schema = tc.table_schema
-		WHERE tc.constraint_type = 'FOREIGN KEY'
-			AND tc.table_name = $1
-	`
-
-	rows, err := e.db.Query(query, table)
+	pkValues, ok := record.columnValues(pkColumns)
+	if !ok {
+		// SQLite allows NULLs in primary keys other than INTEGER PRIMARY
+		// KEY, so such rows are told apart by all their values
+		pkValues = record.Values
+	}
+	return recordKey(record.Table, pkValues), nil
+}
+
+// queueParents queues the records that a record references, and makes the
+// pending record id wait for them
+func (e *Exporter) queueParents(record *Record, h hop, id int) error {
+	foreignKeys, err := e.schema.ForeignKeys(record.Table)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	var fks []ForeignKey
-	for rows.Next() {
-		var fk ForeignKey
-		if err := rows.Scan(&fk.ConstraintName, &fk.TableName, &fk.ColumnName,
-			&fk.ForeignTableName, &fk.ForeignColumnName); err != nil {
-			return nil, err
+	for _, fk := range foreignKeys {
+		// A foreign key with any NULL column is not enforced, so skip it
+		fkValues, ok := record.columnValues(fk.ColumnNames)
+		if !ok {
+			continue
+		}
+
+		if !e.followsTable(fk.ForeignTable) || !e.withinDepth(h.parentDepth+1, h.childDepth) {
+			e.skipped++
+			continue
+		}
+
+		// A row referencing itself can be inserted in one statement
+		if !isSelfReference(record, fk, fkValues) {
+			e.await(id, fk, fkValues)
+		}
+
+		// Skip parents already exported by primary key
+		pkColumns, err := e.schema.PrimaryKey(fk.ForeignTable)
+		if err != nil {
+			return err
 		}
-		fks = append(fks, fk)
+		if sameColumns(fk.ForeignColumnNames, pkColumns) && e.visited[recordKey(fk.ForeignTable, fkValues)] {
+			continue
+		}
+
+		// Parents of a record never pull in their own children
+		parent := hop{
+			parentDepth: h.parentDepth + 1,
+			childDepth:  h.childDepth,
+			reason: fmt.Sprintf("parent of %s via %s(%s)",
+				record.Table, record.Table, strings.Join(fk.ColumnNames, ", ")),
+		}
+		e.enqueue(fk.ForeignTable, fk.ForeignColumnNames, fkValues, parent)
 	}
 
-	return fks, nil
+	return nil
 }
 
-// GenerateSQL converts records to SQL INSERT statements
-func GenerateSQL(records []Record, writer io.Writer) error {
-	// Write header
-	fmt.Fprintf(writer, "-- Database export generated by agentenv\n")
-	fmt.Fprintf(writer, "-- Generated at: %s\n\n", time.Now().Format(time.RFC3339))
-	fmt.Fprintf(writer, "BEGIN;\n\n")
+// isSelfReference reports whether a foreign key of a record references the
+// record itself
+func isSelfReference(record *Record, fk ForeignKey, fkValues []interface{}) bool {
+	if fk.ForeignTable != record.Table {
+		return false
+	}
+	own, ok := record.columnValues(fk.ForeignColumnNames)
+	return ok && keyID(own) == keyID(fkValues)
+}
 
-	// Generate INSERT statements for each record
-	for _, record := range records {
-		// Build column list
-		columns := strings.Join(record.Columns, ", ")
+// queueChildren queues the records that reference a record, including rows
+// of many-to-many join tables, whose other parents follow as parents
+func (e *Exporter) queueChildren(record *Record, h hop) error {
+	referencingKeys, err := e.schema.ReferencingKeys(record.Table)
+	if err != nil {
+		return err
+	}
 
-		// Build value list with proper escaping
-		values := make([]string, len(record.Values))
-		for i, val := range record.Values {
-			values[i] = formatValue(val)
+	for _, fk := range referencingKeys {
+		parentValues, ok := record.columnValues(fk.ForeignColumnNames)
+		if !ok {
+			continue
 		}
-		valueList := strings.Join(values, ", ")
 
-		// Generate INSERT statement with ON CONFLICT DO NOTHING to handle duplicates
-		fmt.Fprintf(writer, "INSERT INTO %s (%s)\n", record.Table, columns)
-		fmt.Fprintf(writer, "VALUES (%s)\n", valueList)
-		fmt.Fprintf(writer, "ON CONFLICT DO NOTHING;\n\n")
+		if !e.followsTable(fk.Table) {
+			continue
+		}
+
+		child := hop{
+			parentDepth: h.parentDepth,
+			childDepth:  h.childDepth + 1,
+			children:    true,
+			reason: fmt.Sprintf("child of %s via %s(%s)",
+				record.Table, fk.Table, strings.Join(fk.ColumnNames, ", ")),
+		}
+		e.enqueue(fk.Table, fk.ColumnNames, parentValues, child)
 	}
 
-	fmt.Fprintf(writer, "COMMIT;\n")
+	return nil
+}
 
+// reportProgress writes the rows and tables visited so far, ending the
+// line once the traversal is done
+func (e *Exporter) reportProgress(done bool) {
+	if e.progress == nil {
+		return
+	}
+
+	fmt.Fprintf(e.progress, "\r  %d row(s) from %d table(s)", len(e.visited), len(e.reasons))
+	if done {
+		fmt.Fprintln(e.progress)
+	}
+}
+
+// warn reports a problem that does not stop the export, on a line of its
+// own. It never goes to stdout, which may hold the export itself.
+func (e *Exporter) warn(format string, args ...interface{}) {
+	w := e.progress
+	if w == nil {
+		w = os.Stderr
+	}
+	fmt.Fprintf(w, "\rWarning: "+format+"\n", args...)
+}
+
+// withinDepth checks the hop counts of a record against the depth limits
+func (e *Exporter) withinDepth(parentDepth, childDepth int) bool {
+	if e.opts.MaxDepth > 0 && parentDepth+childDepth > e.opts.MaxDepth {
+		return false
+	}
+	if e.opts.MaxParentDepth > 0 && parentDepth > e.opts.MaxParentDepth {
+		return false
+	}
+	return e.opts.MaxChildDepth == 0 || childDepth <= e.opts.MaxChildDepth
+}
+
+// followsTable applies the include and exclude table filters. Filters match
+// either the bare table name or schema.table.
+func (e *Exporter) followsTable(table TableName) bool {
+	if matchesTable(e.opts.ExcludeTables, table) {
+		return false
+	}
+	return len(e.opts.IncludeTables) == 0 || matchesTable(e.opts.IncludeTables, table)
+}
+
+// matchesTable reports whether a table appears in a list of table names
+func matchesTable(names []string, table TableName) bool {
+	for _, name := range names {
+		if name == table.Name || name == table.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// queryRecords retrieves all columns of the rows a query selects
+func (e *Exporter) queryRecords(ctx context.Context, q RowQuery) ([]Record, error) {
+	var records []Record
+	err := e.eachRecord(ctx, q, func(record *Record) error {
+		records = append(records, *record)
+		return nil
+	})
+	return records, err
+}
+
+// eachRecord calls fn with each row a query selects, as it is read. All
+// columns of the table are read, whatever columns the query lists.
+func (e *Exporter) eachRecord(ctx context.Context, q RowQuery, fn func(*Record) error) error {
+	// Get column names and types
+	tableColumns, err := e.schema.RowColumns(q.Table)
+	if err != nil {
+		return err
+	}
+	columns := make([]string, len(tableColumns))
+	types := make([]string, len(tableColumns))
+	for i, column := range tableColumns {
+		columns[i] = column.Name
+		types[i] = column.Type
+	}
+
+	q.Columns = columns
+	return e.source.Rows(ctx, q, func(values []interface{}) error {
+		record := Record{Table: q.Table, Columns: columns, Types: types, Values: values}
+		return fn(&record)
+	})
+}
+
+// recordCollector is a RecordWriter that keeps the records in memory
+type recordCollector struct {
+	records []Record
+}
+
+func (c *recordCollector) WriteRecord(record Record) error {
+	c.records = append(c.records, record)
 	return nil
 }
 
-// formatValue formats a value for SQL INSERT statement
+func (c *recordCollector) Close() error { return nil }
+func (c *recordCollector) Abort() error { return nil }
+
+// columnValues returns the record's values for the given columns, and false
+// if any column is missing or NULL
+func (r *Record) columnValues(columns []string) ([]interface{}, bool) {
+	values := make([]interface{}, len(columns))
+	for i, column := range columns {
+		found := false
+		for j, recordColumn := range r.Columns {
+			if recordColumn == column {
+				values[i] = r.Values[j]
+				found = r.Values[j] != nil
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// columnType returns the catalog type of the i-th column, or "" if unknown
+func (r *Record) columnType(i int) string {
+	if i < len(r.Types) {
+		return r.Types[i]
+	}
+	return ""
+}
+
+// recordKey builds the visited-set key for a record from its full primary key tuple
+func recordKey(table TableName, pkValues []interface{}) string {
+	return fmt.Sprintf("%s:(%s)", table, keyID(pkValues))
+}
+
+// sameColumns reports whether two column lists are identical
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// GenerateSQL converts records to SQL INSERT statements for the given
+// dialect, masking column values with masker unless it is nil
+func GenerateSQL(records []Record, dialect Dialect, masker *Masker, writer io.Writer) error {
+	return WriteRecords(newInsertWriter(OutputOptions{Dialect: dialect, Masker: masker, Writer: writer}), records)
+}
+
+// formatValue formats a value for an SQL INSERT statement without type
+// information, escaping backslashes as MySQL expects
 func formatValue(val interface{}) string {
 	if val == nil {
 		return "NULL"
@@ -311,7 +738,7 @@ func formatValue(val interface{}) string {
 
 	switch v := val.(type) {
 	case []byte:
-		// Handle bytea columns
+		// Text that the driver scans as bytes
 		return fmt.Sprintf("'%s'", escapeSQLString(string(v)))
 	case string:
 		return fmt.Sprintf("'%s'", escapeSQLString(v))
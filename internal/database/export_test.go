@@ -100,19 +100,19 @@ func TestEscapeSQLString(t *testing.T) {
 func TestGenerateSQL(t *testing.T) {
 	records := []Record{
 		{
-			Table:   "users",
+			Table:   TableName{Name: "users"},
 			Columns: []string{"id", "email", "active"},
 			Values:  []interface{}{1, "test@example.com", true},
 		},
 		{
-			Table:   "posts",
+			Table:   TableName{Schema: "public", Name: "posts"},
 			Columns: []string{"id", "user_id", "title"},
 			Values:  []interface{}{100, 1, "Test Post"},
 		},
 	}
 
 	var buf bytes.Buffer
-	err := GenerateSQL(records, &buf)
+	err := GenerateSQL(records, postgresDialect{}.dialect(), nil, &buf)
 	if err != nil {
 		t.Fatalf("GenerateSQL failed: %v", err)
 	}
@@ -123,8 +123,9 @@ func TestGenerateSQL(t *testing.T) {
 	expectedStrings := []string{
 		"BEGIN;",
 		"COMMIT;",
-		"INSERT INTO users",
-		"INSERT INTO posts",
+		`INSERT INTO "users" ("id", "email", "active")`,
+		`INSERT INTO "public"."posts" ("id", "user_id", "title")`,
+		"OVERRIDING SYSTEM VALUE",
 		"ON CONFLICT DO NOTHING",
 		"test@example.com",
 		"Test Post",
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graph output formats for agentenv export --graph
+const (
+	GraphDot     = "dot"
+	GraphMermaid = "mermaid"
+)
+
+// GraphFormats lists the supported graph formats
+var GraphFormats = []string{GraphDot, GraphMermaid}
+
+// Graph is a directed graph of tables or rows, with an edge from each
+// referencing node to the node it references
+type Graph struct {
+	Nodes []GraphNode
+	Edges []GraphEdge
+}
+
+// GraphNode is a table or a row
+type GraphNode struct {
+	Label string
+	Cycle bool // Part of a reference cycle
+}
+
+// GraphEdge is a foreign key between two nodes, by node index
+type GraphEdge struct {
+	From  int
+	To    int
+	Label string
+	Cycle bool // Part of a reference cycle
+}
+
+// TableGraph returns the graph of tables and of the foreign keys between
+// them; foreign keys to other tables are left out
+func TableGraph(tables []TableName, fks []ForeignKey) Graph {
+	var g Graph
+	index := make(map[TableName]int)
+	for i, table := range tables {
+		index[table] = i
+		g.Nodes = append(g.Nodes, GraphNode{Label: table.String()})
+	}
+
+	seen := make(map[string]bool)
+	for _, fk := range fks {
+		from, ok := index[fk.Table]
+		to, found := index[fk.ForeignTable]
+		if !ok || !found || seen[foreignKeyID(fk)] {
+			continue
+		}
+		seen[foreignKeyID(fk)] = true
+		g.Edges = append(g.Edges, GraphEdge{From: from, To: to, Label: strings.Join(fk.ColumnNames, ", ")})
+	}
+
+	markCycles(&g)
+	return g
+}
+
+// RowGraph returns the graph of exported records and of the foreign key
+// references between them
+func (e *Exporter) RowGraph(records []Record) (Graph, error) {
+	var g Graph
+
+	// Index each row by the columns that foreign keys reference
+	referenced := make(map[TableName][][]string)
+	for _, fk := range e.ForeignKeys() {
+		referenced[fk.ForeignTable] = append(referenced[fk.ForeignTable], fk.ForeignColumnNames)
+	}
+	rows := make(map[string]int)
+	for i, record := range records {
+		pkColumns, err := e.schema.PrimaryKey(record.Table)
+		if err != nil {
+			return g, err
+		}
+		pkValues, _ := record.columnValues(pkColumns)
+		g.Nodes = append(g.Nodes, GraphNode{Label: record.Table.String() + " " + formatKeyValues(pkValues)})
+
+		for _, columns := range referenced[record.Table] {
+			if values, ok := record.columnValues(columns); ok {
+				rows[refKey(record.Table, columns, values)] = i
+			}
+		}
+	}
+
+	for i, record := range records {
+		fks, err := e.schema.ForeignKeys(record.Table)
+		if err != nil {
+			return g, err
+		}
+		for _, fk := range fks {
+			values, ok := record.columnValues(fk.ColumnNames)
+			if !ok {
+				continue
+			}
+			if j, ok := rows[refKey(fk.ForeignTable, fk.ForeignColumnNames, values)]; ok {
+				g.Edges = append(g.Edges, GraphEdge{From: i, To: j, Label: strings.Join(fk.ColumnNames, ", ")})
+			}
+		}
+	}
+
+	markCycles(&g)
+	return g, nil
+}
+
+// markCycles marks the nodes and edges of reference cycles: edges within a
+// strongly connected component of several nodes, and self-references
+func markCycles(g *Graph) {
+	adjacent := make([][]int, len(g.Nodes))
+	for _, edge := range g.Edges {
+		adjacent[edge.From] = append(adjacent[edge.From], edge.To)
+	}
+
+	component := stronglyConnected(adjacent)
+	size := make(map[int]int)
+	for _, c := range component {
+		size[c]++
+	}
+
+	for i := range g.Edges {
+		edge := &g.Edges[i]
+		if component[edge.From] == component[edge.To] && (size[component[edge.From]] > 1 || edge.From == edge.To) {
+			edge.Cycle = true
+			g.Nodes[edge.From].Cycle = true
+			g.Nodes[edge.To].Cycle = true
+		}
+	}
+}
+
+// stronglyConnected numbers the strongly connected components of a graph
+// with Tarjan's algorithm and returns each node's component
+func stronglyConnected(adjacent [][]int) []int {
+	n := len(adjacent)
+	index := make([]int, n)
+	low := make([]int, n)
+	onStack := make([]bool, n)
+	component := make([]int, n)
+	for i := range index {
+		index[i] = -1
+	}
+
+	var stack []int
+	next, components := 0, 0
+	var visit func(v int)
+	visit = func(v int) {
+		index[v], low[v] = next, next
+		next++
+		stack = append(stack, v)
+		onStack[v] = true
+
+		for _, w := range adjacent[v] {
+			if index[w] < 0 {
+				visit(w)
+				low[v] = min(low[v], low[w])
+			} else if onStack[w] {
+				low[v] = min(low[v], index[w])
+			}
+		}
+
+		if low[v] == index[v] {
+			for {
+				w := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[w] = false
+				component[w] = components
+				if w == v {
+					break
+				}
+			}
+			components++
+		}
+	}
+
+	for v := range adjacent {
+		if index[v] < 0 {
+			visit(v)
+		}
+	}
+	return component
+}
+
+// WriteGraph writes a graph in the dot or mermaid format, drawing reference
+// cycles in red
+func WriteGraph(w io.Writer, g Graph, format string) error {
+	switch format {
+	case GraphDot:
+		return writeDot(w, g)
+	case GraphMermaid:
+		return writeMermaid(w, g)
+	default:
+		return fmt.Errorf("unknown graph format %q (expected one of: %s)", format, strings.Join(GraphFormats, ", "))
+	}
+}
+
+// writeDot writes a Graphviz digraph
+func writeDot(w io.Writer, g Graph) error {
+	var b strings.Builder
+	b.WriteString("digraph export {\n  rankdir=LR;\n  node [shape=box];\n")
+	for i, node := range g.Nodes {
+		style := ""
+		if node.Cycle {
+			style = ", color=red"
+		}
+		fmt.Fprintf(&b, "  n%d [label=%s%s];\n", i, dotString(node.Label), style)
+	}
+	for _, edge := range g.Edges {
+		style := ""
+		if edge.Cycle {
+			style = ", color=red, penwidth=2"
+		}
+		fmt.Fprintf(&b, "  n%d -> n%d [label=%s%s];\n", edge.From, edge.To, dotString(edge.Label), style)
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// dotString quotes a label for Graphviz
+func dotString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// writeMermaid writes a Mermaid flowchart
+func writeMermaid(w io.Writer, g Graph) error {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	var cycleNodes []string
+	for i, node := range g.Nodes {
+		fmt.Fprintf(&b, "  n%d[%s]\n", i, mermaidString(node.Label))
+		if node.Cycle {
+			cycleNodes = append(cycleNodes, fmt.Sprintf("n%d", i))
+		}
+	}
+	var cycleEdges []string
+	for i, edge := range g.Edges {
+		fmt.Fprintf(&b, "  n%d -->|%s| n%d\n", edge.From, mermaidString(edge.Label), edge.To)
+		if edge.Cycle {
+			cycleEdges = append(cycleEdges, fmt.Sprint(i))
+		}
+	}
+	if len(cycleNodes) > 0 {
+		b.WriteString("  classDef cycle stroke:red,stroke-width:2px\n")
+		fmt.Fprintf(&b, "  class %s cycle\n", strings.Join(cycleNodes, ","))
+	}
+	if len(cycleEdges) > 0 {
+		fmt.Fprintf(&b, "  linkStyle %s stroke:red,stroke-width:2px\n", strings.Join(cycleEdges, ","))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// mermaidString quotes a label for Mermaid, which has no escape for quotes
+// other than its #quot; entity
+func mermaidString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
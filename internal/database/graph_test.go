@@ -0,0 +1,54 @@
+package database
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTableGraph(t *testing.T) {
+	teams := TableName{Schema: "public", Name: "teams"}
+	players := TableName{Schema: "public", Name: "players"}
+	matches := TableName{Schema: "public", Name: "matches"}
+	fks := []ForeignKey{
+		{ConstraintName: "captain", Table: teams, ColumnNames: []string{"captain_id"}, ForeignTable: players},
+		{ConstraintName: "team", Table: players, ColumnNames: []string{"team_id"}, ForeignTable: teams},
+		{ConstraintName: "home", Table: matches, ColumnNames: []string{"home_id"}, ForeignTable: teams},
+		{ConstraintName: "venue", Table: matches, ColumnNames: []string{"venue_id"}, ForeignTable: TableName{Schema: "public", Name: "venues"}},
+	}
+
+	g := TableGraph([]TableName{teams, players, matches}, fks)
+	if len(g.Edges) != 3 {
+		t.Fatalf("TableGraph() has %d edges, want 3 (venues is not in the graph)", len(g.Edges))
+	}
+	for _, edge := range g.Edges {
+		if want := edge.Label != "home_id"; edge.Cycle != want {
+			t.Errorf("edge %s: Cycle = %v, want %v", edge.Label, edge.Cycle, want)
+		}
+	}
+	if g.Nodes[2].Cycle {
+		t.Error("matches is not part of a cycle")
+	}
+
+	tests := []struct {
+		format   string
+		expected []string
+	}{
+		{GraphDot, []string{`n0 [label="public.teams", color=red];`, `n2 -> n0 [label="home_id"];`, `n0 -> n1 [label="captain_id", color=red, penwidth=2];`}},
+		{GraphMermaid, []string{`n0["public.teams"]`, `n2 -->|"home_id"| n0`, "class n0,n1 cycle", "linkStyle 0,1 stroke:red"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := WriteGraph(&out, g, tt.format); err != nil {
+				t.Fatalf("WriteGraph failed: %v", err)
+			}
+			for _, line := range tt.expected {
+				if !strings.Contains(out.String(), line) {
+					t.Errorf("WriteGraph() output lacks %q:\n%s", line, out.String())
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// ImportCount is the number of rows an import inserted into a table, and
+// the number it skipped because they already existed
+type ImportCount struct {
+	Table    TableName
+	Inserted int
+	Skipped  int
+}
+
+// Import inserts exported records into the database in one transaction,
+// skipping rows that already exist, then runs the export's load steps if
+// steps is not nil. Values are masked with masker unless it is nil. On any
+// error the transaction is rolled back and nothing is imported.
+func Import(conn *Connection, records []Record, steps *LoadSteps, masker *Masker) ([]ImportCount, error) {
+	importer, err := NewImporter(conn, steps, masker)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := WriteRecords(importer, records); err != nil {
+		return nil, err
+	}
+	return importer.Counts(), nil
+}
+
+// Importer is a RecordWriter that inserts records into a database as they
+// are exported, in one transaction committed by Close
+type Importer struct {
+	db       *sql.DB
+	tx       *sql.Tx
+	dialect  Dialect
+	steps    *LoadSteps
+	masker   *Masker
+	deferred bool
+	counts   []ImportCount
+	index    map[TableName]int
+}
+
+// NewImporter connects to the database, starts the import transaction and
+// creates the tables of steps.Schema. The other load steps, if steps is not
+// nil, are run by Close, and may still change while records are written.
+func NewImporter(conn *Connection, steps *LoadSteps, masker *Masker) (*Importer, error) {
+	dialect, err := DialectFor(conn.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := conn.open(dialect.conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to start transaction: %w", err)
+	}
+
+	if steps != nil {
+		for _, statement := range steps.Schema {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				db.Close()
+				return nil, fmt.Errorf("failed to create tables: %w", err)
+			}
+		}
+	}
+
+	return &Importer{db: db, tx: tx, dialect: dialect, steps: steps, masker: masker, index: make(map[TableName]int)}, nil
+}
+
+// Counts returns the rows inserted and skipped per table, in order of each
+// table's first record
+func (im *Importer) Counts() []ImportCount {
+	return im.counts
+}
+
+// WriteRecord runs the record's parameterized insert
+func (im *Importer) WriteRecord(record Record) error {
+	if !im.deferred && im.steps != nil && im.steps.DeferConstraints {
+		im.deferred = true
+		if _, err := im.tx.Exec("SET CONSTRAINTS ALL DEFERRED"); err != nil {
+			return fmt.Errorf("failed to defer constraints: %w", err)
+		}
+	}
+
+	i, ok := im.index[record.Table]
+	if !ok {
+		i = len(im.counts)
+		im.index[record.Table] = i
+		im.counts = append(im.counts, ImportCount{Table: record.Table})
+	}
+
+	placeholders := make([]string, len(record.Columns))
+	args := make([]interface{}, len(record.Columns))
+	for j, val := range im.steps.insertValues(record, im.masker) {
+		placeholders[j] = im.dialect.queries.Placeholder(j + 1)
+		args[j] = importValue(val, record.columnType(j))
+	}
+
+	result, err := im.tx.Exec(im.dialect.inserts.InsertStatement(record.Table, record.Columns, placeholders), args...)
+	if err != nil {
+		return fmt.Errorf("failed to insert into %s: %w", record.Table, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected > 0 {
+		im.counts[i].Inserted++
+	} else {
+		im.counts[i].Skipped++
+	}
+	return nil
+}
+
+// Close runs the load steps and commits the import, or rolls it back if
+// either fails
+func (im *Importer) Close() error {
+	defer im.db.Close()
+
+	if err := runSteps(im.tx, im.dialect, im.steps, im.masker); err != nil {
+		im.tx.Rollback()
+		return err
+	}
+	if err := im.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit import: %w", err)
+	}
+	return nil
+}
+
+// Abort rolls the import back
+func (im *Importer) Abort() error {
+	defer im.db.Close()
+	return im.tx.Rollback()
+}
+
+// runSteps sets the foreign keys inserted as NULL and advances sequences
+func runSteps(tx *sql.Tx, dialect Dialect, steps *LoadSteps, masker *Masker) error {
+	if steps == nil {
+		return nil
+	}
+
+	for _, fixup := range steps.Fixups {
+		masked := masker.Values(fixup.Record)
+		indexes := fixup.indexes()
+		placeholders := make([]string, len(indexes))
+		args := make([]interface{}, len(indexes))
+		for i, j := range indexes {
+			placeholders[i] = dialect.queries.Placeholder(i + 1)
+			args[i] = importValue(masked[j], fixup.Record.columnType(j))
+		}
+
+		if _, err := tx.Exec(fixup.updateStatement(dialect.queries, placeholders), args...); err != nil {
+			return fmt.Errorf("failed to update %s: %w", fixup.Record.Table, err)
+		}
+	}
+
+	if sequences := dialect.sequences; sequences != nil {
+		for _, seq := range steps.Sequences {
+			if _, err := tx.Exec(sequences.SetSequenceStatement(seq)); err != nil {
+				return fmt.Errorf("failed to reset sequence %s: %w", seq.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// importValue converts a scanned value for use as a query argument. Drivers
+// return text-like types such as numeric as bytes; they are sent back as
+// text, as the SQL output does. Values of binary columns stay bytes.
+func importValue(val interface{}, columnType string) interface{} {
+	if b, ok := val.([]byte); ok && !isBytesType(columnType) {
+		return string(b)
+	}
+	return val
+}
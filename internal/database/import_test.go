@@ -0,0 +1,105 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestImportSQLite(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{100}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// The target already has the user but not the post
+	target := &Connection{Type: "sqlite", Path: createSQLiteFixture(t)}
+	db, err := sql.Open("sqlite", target.Path)
+	if err != nil {
+		t.Fatalf("failed to open target: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("DELETE FROM post_tags; DELETE FROM posts"); err != nil {
+		t.Fatalf("failed to prepare target: %v", err)
+	}
+
+	counts, err := Import(target, records, nil, nil)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	expected := []ImportCount{
+		{Table: TableName{Schema: "main", Name: "users"}, Inserted: 0, Skipped: 1},
+		{Table: TableName{Schema: "main", Name: "posts"}, Inserted: 1, Skipped: 0},
+	}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Import counts = %+v, want %+v", counts, expected)
+	}
+
+	// A failing record rolls back the rows before it
+	missing := Record{Table: TableName{Name: "missing"}, Columns: []string{"id"}, Values: []interface{}{1}}
+	if _, err := db.Exec("DELETE FROM posts"); err != nil {
+		t.Fatalf("failed to reset target: %v", err)
+	}
+	if _, err := Import(target, []Record{records[1], missing}, nil, nil); err == nil {
+		t.Fatal("Import into a missing table should fail")
+	}
+
+	var posts int
+	if err := db.QueryRow("SELECT COUNT(*) FROM posts").Scan(&posts); err != nil {
+		t.Fatalf("failed to count posts: %v", err)
+	}
+	if posts != 0 {
+		t.Errorf("posts after rolled back import = %d, want 0", posts)
+	}
+
+	// Only duplicate keys are skipped, constraint violations still fail
+	invalid := Record{Table: TableName{Name: "users"}, Columns: []string{"id", "email"}, Values: []interface{}{2, nil}}
+	if _, err := Import(target, []Record{invalid}, nil, nil); err == nil {
+		t.Error("Import of a NULL into a NOT NULL column should fail")
+	}
+}
+
+func TestImportSQLiteBinary(t *testing.T) {
+	target := &Connection{Type: "sqlite", Path: filepath.Join(t.TempDir(), "target.db")}
+	db, err := sql.Open("sqlite", target.Path)
+	if err != nil {
+		t.Fatalf("failed to open target: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE files (id INTEGER PRIMARY KEY, data BLOB, name TEXT)"); err != nil {
+		t.Fatalf("failed to create table: %v", err)
+	}
+
+	// Not valid UTF-8, with a NUL byte
+	data := []byte{0x00, 0xff, 0xfe, 'a'}
+	record := Record{
+		Table:   TableName{Name: "files"},
+		Columns: []string{"id", "data", "name"},
+		Types:   []string{"INTEGER", "BLOB", "TEXT"},
+		Values:  []interface{}{int64(1), data, []byte("a.bin")},
+	}
+	if _, err := Import(target, []Record{record}, nil, nil); err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+
+	var stored []byte
+	var dataType, nameType string
+	if err := db.QueryRow("SELECT data, typeof(data), typeof(name) FROM files").Scan(&stored, &dataType, &nameType); err != nil {
+		t.Fatalf("failed to read row: %v", err)
+	}
+	if !bytes.Equal(stored, data) || dataType != "blob" {
+		t.Errorf("data = %v (%s), want %v (blob)", stored, dataType, data)
+	}
+	if nameType != "text" {
+		t.Errorf("name stored as %s, want text", nameType)
+	}
+}
@@ -0,0 +1,112 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Key identifies a record by its primary key. Values are either positional,
+// in primary key order, or paired with the column names in Columns.
+type Key struct {
+	Columns []string
+	Values  []interface{}
+}
+
+// ParseKey parses a key from the command line. "123" is a single positional
+// value; "order_id=5,line=2" names each primary key column.
+func ParseKey(s string) (Key, error) {
+	if !strings.Contains(s, "=") {
+		return Key{Values: []interface{}{parseKeyValue(s)}}, nil
+	}
+
+	var key Key
+	for _, part := range strings.Split(s, ",") {
+		column, value, ok := strings.Cut(part, "=")
+		column = strings.TrimSpace(column)
+		if !ok || column == "" {
+			return Key{}, fmt.Errorf("invalid key part %q (expected column=value)", part)
+		}
+		key.Columns = append(key.Columns, column)
+		key.Values = append(key.Values, parseKeyValue(strings.TrimSpace(value)))
+	}
+
+	return key, nil
+}
+
+// String formats the key for messages, e.g. "order_id=5, line=2"
+func (k Key) String() string {
+	if len(k.Columns) == 0 {
+		return formatKeyValues(k.Values)
+	}
+
+	parts := make([]string, len(k.Columns))
+	for i, column := range k.Columns {
+		parts[i] = fmt.Sprintf("%s=%s", column, formatKeyValues(k.Values[i:i+1]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// valuesFor orders the key's values to match the primary key columns
+func (k Key) valuesFor(pkColumns []string) ([]interface{}, error) {
+	if len(k.Values) != len(pkColumns) {
+		return nil, fmt.Errorf("key has %d value(s) but primary key (%s) has %d column(s)",
+			len(k.Values), strings.Join(pkColumns, ", "), len(pkColumns))
+	}
+	if len(k.Columns) == 0 {
+		return k.Values, nil
+	}
+
+	values := make([]interface{}, len(pkColumns))
+	for i, pkColumn := range pkColumns {
+		found := false
+		for j, column := range k.Columns {
+			if column == pkColumn {
+				values[i] = k.Values[j]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("key is missing primary key column %s", pkColumn)
+		}
+	}
+
+	return values, nil
+}
+
+// parseKeyValue treats integers as integers and anything else as a string
+func parseKeyValue(s string) interface{} {
+	if i, err := strconv.Atoi(s); err == nil {
+		return i
+	}
+	return s
+}
+
+// formatKeyValues renders a key tuple so that equal keys read back from
+// different drivers (int, int64, []byte) produce the same string
+func formatKeyValues(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		if b, ok := value.([]byte); ok {
+			value = string(b)
+		}
+		parts[i] = fmt.Sprintf("%v", value)
+	}
+	return strings.Join(parts, ",")
+}
+
+// keyID identifies a key tuple in maps and comparisons. Like
+// formatKeyValues it ignores how drivers typed the values, but each value
+// is quoted and NULL is not, so tuples of values holding commas stay apart.
+func keyID(values []interface{}) string {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		if value == nil {
+			parts[i] = "NULL"
+			continue
+		}
+		parts[i] = strconv.Quote(formatKeyValues(values[i : i+1]))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,93 @@
+package database
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected Key
+		wantErr  bool
+	}{
+		{
+			name:     "integer id",
+			input:    "123",
+			expected: Key{Values: []interface{}{123}},
+		},
+		{
+			name:     "string id",
+			input:    "abc-def",
+			expected: Key{Values: []interface{}{"abc-def"}},
+		},
+		{
+			name:  "composite key",
+			input: "order_id=5,line=2",
+			expected: Key{
+				Columns: []string{"order_id", "line"},
+				Values:  []interface{}{5, 2},
+			},
+		},
+		{
+			name:    "missing column name",
+			input:   "order_id=5,=2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseKey(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKey(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("ParseKey(%q) = %+v, want %+v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestKeyValuesFor(t *testing.T) {
+	pkColumns := []string{"order_id", "line"}
+
+	key := Key{Columns: []string{"line", "order_id"}, Values: []interface{}{2, 5}}
+	values, err := key.valuesFor(pkColumns)
+	if err != nil {
+		t.Fatalf("valuesFor failed: %v", err)
+	}
+	if !reflect.DeepEqual(values, []interface{}{5, 2}) {
+		t.Errorf("valuesFor() = %v, want [5 2]", values)
+	}
+
+	if _, err := (Key{Values: []interface{}{5}}).valuesFor(pkColumns); err == nil {
+		t.Error("valuesFor() with too few values should fail")
+	}
+
+	if _, err := (Key{Columns: []string{"order_id", "sku"}, Values: []interface{}{5, 2}}).valuesFor(pkColumns); err == nil {
+		t.Error("valuesFor() with a non-key column should fail")
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  []interface{}
+		equal bool
+	}{
+		{name: "driver types", a: []interface{}{int64(5), []byte("x")}, b: []interface{}{5, "x"}, equal: true},
+		{name: "commas in values", a: []interface{}{"a,b", "c"}, b: []interface{}{"a", "b,c"}},
+		{name: "quotes in values", a: []interface{}{`a","b`}, b: []interface{}{"a", "b"}},
+		{name: "NULL", a: []interface{}{nil}, b: []interface{}{"<nil>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if equal := keyID(tt.a) == keyID(tt.b); equal != tt.equal {
+				t.Errorf("keyID(%v) == keyID(%v) is %v, want %v", tt.a, tt.b, equal, tt.equal)
+			}
+		})
+	}
+}
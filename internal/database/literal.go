@@ -0,0 +1,130 @@
+package database
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// postgresLiteral formats a value scanned by lib/pq for a column of the
+// given format_type(). lib/pq decodes integers, floats, booleans, text and
+// timestamps; every other type arrives as its text form in bytes and is
+// written as a string literal cast to the column's type, which Postgres
+// parses with the type's own input function.
+func postgresLiteral(value interface{}, columnType string) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return strconv.FormatBool(v)
+	case int, int8, int16, int32, int64, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", v)
+	case float32:
+		return postgresFloat(float64(v), columnType)
+	case float64:
+		return postgresFloat(v, columnType)
+	case time.Time:
+		return castLiteral(postgresTime(v, columnType), columnType)
+	case []byte:
+		if columnType == "bytea" {
+			return postgresString(`\x`+hex.EncodeToString(v)) + "::bytea"
+		}
+		return castLiteral(string(v), columnType)
+	case string:
+		return castLiteral(v, columnType)
+	default:
+		return castLiteral(fmt.Sprintf("%v", v), columnType)
+	}
+}
+
+// postgresString quotes s as a string constant. Strings containing
+// backslashes use the E'' syntax, so that the literal means the same
+// whether standard_conforming_strings is on or off.
+func postgresString(s string) string {
+	quoted := "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	if strings.Contains(s, `\`) {
+		return "E" + strings.ReplaceAll(quoted, `\`, `\\`)
+	}
+	return quoted
+}
+
+// castLiteral quotes s and casts it to the column's type, unless the type is
+// unknown or a character type that needs no cast
+func castLiteral(s string, columnType string) string {
+	literal := postgresString(s)
+	if columnType == "" || columnType == "text" || strings.HasPrefix(columnType, "character") {
+		return literal
+	}
+	return literal + "::" + columnType
+}
+
+// postgresFloat formats a float with the shortest exact representation,
+// spelling out the special values Postgres accepts
+func postgresFloat(v float64, columnType string) string {
+	switch {
+	case math.IsNaN(v):
+		return castLiteral("NaN", columnType)
+	case math.IsInf(v, 1):
+		return castLiteral("Infinity", columnType)
+	case math.IsInf(v, -1):
+		return castLiteral("-Infinity", columnType)
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// postgresTime formats a time for a date, time or timestamp column with
+// microsecond precision, including the offset only for types with a time zone
+func postgresTime(t time.Time, columnType string) string {
+	withZone := strings.HasSuffix(columnType, "with time zone")
+
+	switch {
+	case columnType == "date":
+		return t.Format("2006-01-02")
+	case strings.HasPrefix(columnType, "time ") || strings.HasPrefix(columnType, "time("):
+		if withZone {
+			return t.Format("15:04:05.999999-07:00")
+		}
+		return t.Format("15:04:05.999999")
+	case strings.HasPrefix(columnType, "timestamp") && !withZone:
+		return t.Format("2006-01-02 15:04:05.999999")
+	default:
+		return t.Format("2006-01-02 15:04:05.999999-07:00")
+	}
+}
+
+// hexLiteral formats bytes as an X'...' blob literal for MySQL and SQLite
+func hexLiteral(b []byte) string {
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+// sqliteLiteral formats a value scanned by the SQLite driver. SQLite
+// returns BLOB values as bytes and TEXT as strings, and has no backslash
+// escapes in string literals.
+func sqliteLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case []byte:
+		return hexLiteral(v)
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case time.Time:
+		return "'" + v.Format("2006-01-02 15:04:05.999999999-07:00") + "'"
+	default:
+		return formatValue(v)
+	}
+}
+
+// isBinaryType reports whether a MySQL column_type holds raw bytes
+func isBinaryType(columnType string) bool {
+	return strings.Contains(columnType, "blob") || strings.Contains(columnType, "binary")
+}
+
+// isBytesType reports whether a column type of any engine holds raw bytes:
+// PostgreSQL's bytea, SQLite's BLOB or a MySQL binary type
+func isBytesType(columnType string) bool {
+	columnType = strings.ToLower(columnType)
+	return columnType == "bytea" || isBinaryType(columnType)
+}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+// Masking strategies for database.export.masking
+const (
+	MaskNull      = "null"
+	MaskFixed     = "fixed"
+	MaskFakeEmail = "fake_email"
+	MaskFakeName  = "fake_name"
+	MaskHash      = "hash"
+	MaskKeep      = "keep"
+)
+
+var fakeFirstNames = []string{
+	"Alex", "Blair", "Casey", "Dana", "Eli", "Frankie", "Gray", "Harper",
+	"Indy", "Jordan", "Kai", "Logan", "Morgan", "Noel", "Parker", "Quinn",
+	"Riley", "Sage", "Taylor", "Val",
+}
+
+var fakeLastNames = []string{
+	"Adams", "Brooks", "Carter", "Diaz", "Ellis", "Fischer", "Garcia", "Hughes",
+	"Ito", "Jensen", "Kowalski", "Lopez", "Murphy", "Nguyen", "Okafor", "Patel",
+	"Reyes", "Silva", "Tanaka", "Walsh",
+}
+
+// Masker rewrites sensitive column values before records are written out.
+// Every strategy is a function of the value alone, so the same input maps to
+// the same output in every table and every export with the same salt.
+type Masker struct {
+	salt  string
+	rules map[string]config.MaskRule // Keyed by table.column or schema.table.column
+}
+
+// NewMasker validates the masking rules from .agentenv.yml
+func NewMasker(cfg config.ExportConfig) (*Masker, error) {
+	m := &Masker{salt: cfg.Salt, rules: make(map[string]config.MaskRule)}
+
+	for column, rule := range cfg.Masking {
+		if !strings.Contains(column, ".") {
+			return nil, fmt.Errorf("invalid masking column %q (expected table.column)", column)
+		}
+
+		// "users.notes: null" decodes to a rule without a strategy
+		if rule.Strategy == "" {
+			rule.Strategy = MaskNull
+		}
+
+		switch rule.Strategy {
+		case MaskNull, MaskFixed, MaskFakeEmail, MaskFakeName, MaskHash, MaskKeep:
+			m.rules[column] = rule
+		default:
+			return nil, fmt.Errorf("unknown masking strategy %q for %s", rule.Strategy, column)
+		}
+	}
+
+	return m, nil
+}
+
+// FollowForeignKeys gives foreign key columns the rule of the column they
+// reference, so that masked keys still match after masking. An explicit rule
+// on the referencing column takes precedence.
+func (m *Masker) FollowForeignKeys(fks []ForeignKey) {
+	// Repeat until no rule is added, to follow chains of foreign keys
+	for changed := true; changed; {
+		changed = false
+		for _, fk := range fks {
+			for i, column := range fk.ColumnNames {
+				rule, ok := m.rule(fk.ForeignTable, fk.ForeignColumnNames[i])
+				if !ok {
+					continue
+				}
+				if _, exists := m.rule(fk.Table, column); exists {
+					continue
+				}
+				m.rules[fk.Table.String()+"."+column] = rule
+				changed = true
+			}
+		}
+	}
+}
+
+// Values returns a record's values with the masking rules applied. NULL
+// values stay NULL. A nil Masker returns the values unchanged.
+func (m *Masker) Values(record Record) []interface{} {
+	if m == nil || len(m.rules) == 0 {
+		return record.Values
+	}
+
+	values := make([]interface{}, len(record.Values))
+	for i, column := range record.Columns {
+		values[i] = record.Values[i]
+		if rule, ok := m.rule(record.Table, column); ok && values[i] != nil {
+			values[i] = m.maskValue(rule, values[i], record.columnType(i))
+		}
+	}
+	return values
+}
+
+// rule returns the masking rule for a column, matching either the bare
+// table name or schema.table
+func (m *Masker) rule(table TableName, column string) (config.MaskRule, bool) {
+	if rule, ok := m.rules[table.String()+"."+column]; ok {
+		return rule, true
+	}
+	rule, ok := m.rules[table.Name+"."+column]
+	return rule, ok
+}
+
+// maskValue applies a single rule to a non-NULL value of a column type
+func (m *Masker) maskValue(rule config.MaskRule, value interface{}, columnType string) interface{} {
+	switch rule.Strategy {
+	case MaskNull:
+		return nil
+	case MaskFixed:
+		return rule.Value
+	case MaskFakeEmail:
+		return fmt.Sprintf("user-%s@example.com", m.digest(value)[:12])
+	case MaskFakeName:
+		sum := m.sum(value)
+		return fakeFirstNames[int(sum[0])%len(fakeFirstNames)] + " " + fakeLastNames[int(sum[1])%len(fakeLastNames)]
+	case MaskHash:
+		return m.hash(value, columnType)
+	default:
+		return value
+	}
+}
+
+// hash returns a value's digest in a form its column accepts, so hashed keys
+// still load: an integer of the same size for integer columns, a UUID for
+// uuid columns and 32 hex characters otherwise
+func (m *Masker) hash(value interface{}, columnType string) interface{} {
+	if n, ok := maskedInteger(value, columnType); ok {
+		return m.hashInteger(n)
+	}
+
+	sum := m.sum(value)
+	if strings.EqualFold(columnType, "uuid") {
+		return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+	}
+	return hex.EncodeToString(sum)[:32]
+}
+
+// integerTiers are the bit lengths that signed and unsigned integer column
+// types end at. A hashed integer stays in the tier of the original, so it
+// fits every column the original fits in.
+var integerTiers = []uint{7, 8, 15, 16, 23, 24, 31, 32, 63}
+
+// hashInteger maps an integer to another in the same tier with a keyed
+// permutation, so distinct keys stay distinct and foreign keys of any
+// integer type still match. Negative values map to negative values.
+func (m *Masker) hashInteger(n int64) int64 {
+	if n < 0 {
+		return ^m.hashInteger(^n)
+	}
+
+	var lo uint64
+	for _, bits := range integerTiers {
+		hi := uint64(1) << bits
+		if uint64(n) < hi {
+			return int64(m.permute(uint64(n), lo, hi, bits))
+		}
+		lo = hi
+	}
+	return n
+}
+
+// permute runs a Feistel network keyed by the salt over the smallest even
+// number of bits holding the tier [lo, hi), repeating it until the result
+// is back in the tier. That keeps it a permutation of the tier.
+func (m *Masker) permute(n, lo, hi uint64, bits uint) uint64 {
+	half := (bits + 1) / 2
+	mask := uint64(1)<<half - 1
+	for {
+		left, right := n>>half, n&mask
+		for round := byte(0); round < 4; round++ {
+			left, right = right, left^(m.round(round, bits, right)&mask)
+		}
+		n = left<<half | right
+		if n >= lo && n < hi {
+			return n
+		}
+	}
+}
+
+// round is the Feistel round function of a tier
+func (m *Masker) round(round byte, bits uint, n uint64) uint64 {
+	var input [10]byte
+	input[0], input[1] = round, byte(bits)
+	binary.BigEndian.PutUint64(input[2:], n)
+
+	mac := hmac.New(sha256.New, []byte(m.salt))
+	mac.Write(input[:])
+	return binary.BigEndian.Uint64(mac.Sum(nil))
+}
+
+// maskedInteger returns the value of an integer column. Values of columns
+// of unknown type are treated as integers if they were read as integers.
+func maskedInteger(value interface{}, columnType string) (int64, bool) {
+	if n, ok := value.(int64); ok && columnType == "" {
+		return n, true
+	}
+	if !isIntegerType(columnType) {
+		return 0, false
+	}
+	n, err := integerValue(value)
+	return n, err == nil
+}
+
+// isIntegerType reports whether a column type of any engine holds integers
+func isIntegerType(columnType string) bool {
+	base := strings.ToLower(columnType)
+	if i := strings.IndexAny(base, "( "); i >= 0 {
+		base = base[:i]
+	}
+
+	switch base {
+	case "tinyint", "smallint", "int2", "smallserial", "mediumint", "int", "integer",
+		"int4", "serial", "bigint", "int8", "bigserial":
+		return true
+	}
+	return false
+}
+
+// sum returns the salted HMAC-SHA256 of a value's text form
+func (m *Masker) sum(value interface{}) []byte {
+	mac := hmac.New(sha256.New, []byte(m.salt))
+	mac.Write([]byte(formatKeyValues([]interface{}{value})))
+	return mac.Sum(nil)
+}
+
+// digest returns the hex-encoded sum of a value
+func (m *Masker) digest(value interface{}) string {
+	return hex.EncodeToString(m.sum(value))
+}
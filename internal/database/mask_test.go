@@ -0,0 +1,178 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+func TestMaskerValues(t *testing.T) {
+	masker, err := NewMasker(config.ExportConfig{
+		Salt: "test",
+		Masking: map[string]config.MaskRule{
+			"users.email":       {Strategy: MaskFakeEmail},
+			"users.name":        {Strategy: MaskFakeName},
+			"users.token":       {Strategy: MaskHash},
+			"users.notes":       {},
+			"public.users.role": {Strategy: MaskFixed, Value: "member"},
+			"users.id":          {Strategy: MaskKeep},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMasker failed: %v", err)
+	}
+
+	record := Record{
+		Table:   TableName{Schema: "public", Name: "users"},
+		Columns: []string{"id", "email", "name", "token", "notes", "role", "bio"},
+		Values:  []interface{}{int64(1), "jane@corp.com", "Jane Doe", []byte("secret"), "vip", "admin", nil},
+	}
+	values := masker.Values(record)
+
+	if values[0] != int64(1) {
+		t.Errorf("kept id = %v, want 1", values[0])
+	}
+	if email, _ := values[1].(string); !strings.HasPrefix(email, "user-") || !strings.HasSuffix(email, "@example.com") {
+		t.Errorf("fake email = %v", values[1])
+	}
+	if name, _ := values[2].(string); name == "Jane Doe" || len(strings.Fields(name)) != 2 {
+		t.Errorf("fake name = %v", values[2])
+	}
+	if token, _ := values[3].(string); len(token) != 32 {
+		t.Errorf("hash = %v, want 32 hex characters", values[3])
+	}
+	if values[4] != nil {
+		t.Errorf("nulled notes = %v, want nil", values[4])
+	}
+	if values[5] != "member" {
+		t.Errorf("fixed role = %v, want member", values[5])
+	}
+	if values[6] != nil {
+		t.Errorf("unmasked NULL bio = %v, want nil", values[6])
+	}
+
+	// The same input always masks to the same output
+	again := masker.Values(record)
+	for i := range values {
+		if values[i] != again[i] {
+			t.Errorf("column %s masked to %v, then %v", record.Columns[i], values[i], again[i])
+		}
+	}
+}
+
+func TestMaskerHashFitsColumnType(t *testing.T) {
+	masker, err := NewMasker(config.ExportConfig{
+		Masking: map[string]config.MaskRule{
+			"users.id":       {Strategy: MaskHash},
+			"users.rank":     {Strategy: MaskHash},
+			"users.guid":     {Strategy: MaskHash},
+			"users.token":    {Strategy: MaskHash},
+			"users.legacy":   {Strategy: MaskHash},
+			"users.interval": {Strategy: MaskHash},
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewMasker failed: %v", err)
+	}
+
+	record := Record{
+		Table:   TableName{Name: "users"},
+		Columns: []string{"id", "rank", "guid", "token", "legacy", "interval"},
+		Types:   []string{"bigint", "smallint unsigned", "uuid", "character varying(64)", "", "interval"},
+		Values:  []interface{}{int64(1), int64(2), "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11", "secret", int64(3), "1 day"},
+	}
+	values := masker.Values(record)
+
+	if id, ok := values[0].(int64); !ok || id < 0 || id == 1 {
+		t.Errorf("hashed bigint = %v (%T), want another positive int64", values[0], values[0])
+	}
+	if rank, ok := values[1].(int64); !ok || rank < 0 || rank > 32767 {
+		t.Errorf("hashed smallint = %v (%T), want an int64 within smallint", values[1], values[1])
+	}
+	if guid, _ := values[2].(string); len(guid) != 36 || strings.Count(guid, "-") != 4 {
+		t.Errorf("hashed uuid = %v, want a UUID", values[2])
+	}
+	if token, _ := values[3].(string); len(token) != 32 {
+		t.Errorf("hashed varchar = %v, want 32 hex characters", values[3])
+	}
+	if legacy, ok := values[4].(int64); !ok || legacy > 1<<31-1 {
+		t.Errorf("hashed untyped integer = %v (%T), want an int64 within integer", values[4], values[4])
+	}
+	if interval, _ := values[5].(string); len(interval) != 32 {
+		t.Errorf("hashed interval = %v, want 32 hex characters", values[5])
+	}
+}
+
+func TestMaskerHashIntegersStayUnique(t *testing.T) {
+	masker, err := NewMasker(config.ExportConfig{Salt: "test"})
+	if err != nil {
+		t.Fatalf("NewMasker failed: %v", err)
+	}
+
+	tests := []struct {
+		from, to int64 // Range of values hashed
+		min, max int64 // Range the hashes must stay in
+	}{
+		{from: 0, to: 127, min: 0, max: 127},
+		{from: 0, to: 999, min: 0, max: 1<<15 - 1},
+		{from: 1<<32 + 1, to: 1<<32 + 1000, min: 1 << 32, max: 1<<63 - 1},
+		{from: -200, to: -1, min: -1 << 15, max: -1},
+	}
+
+	for _, test := range tests {
+		seen := make(map[int64]int64)
+		for n := test.from; n <= test.to; n++ {
+			hashed := masker.hashInteger(n)
+			if hashed < test.min || hashed > test.max {
+				t.Errorf("hashInteger(%d) = %d, want within [%d, %d]", n, hashed, test.min, test.max)
+			}
+			if other, ok := seen[hashed]; ok {
+				t.Errorf("hashInteger(%d) = hashInteger(%d) = %d", n, other, hashed)
+			}
+			seen[hashed] = n
+		}
+	}
+
+	// Keys of any integer type hash alike, so foreign keys still match
+	if masker.hash(int64(42), "integer") != masker.hash([]byte("42"), "bigint") {
+		t.Error("the same key hashed differently as integer and bigint")
+	}
+}
+
+func TestMaskerFollowForeignKeys(t *testing.T) {
+	masker, err := NewMasker(config.ExportConfig{
+		Masking: map[string]config.MaskRule{"users.email": {Strategy: MaskHash}},
+	})
+	if err != nil {
+		t.Fatalf("NewMasker failed: %v", err)
+	}
+
+	users := TableName{Schema: "public", Name: "users"}
+	invites := TableName{Schema: "public", Name: "invites"}
+	reminders := TableName{Schema: "public", Name: "reminders"}
+	masker.FollowForeignKeys([]ForeignKey{
+		{Table: reminders, ColumnNames: []string{"invite_email"}, ForeignTable: invites, ForeignColumnNames: []string{"email"}},
+		{Table: invites, ColumnNames: []string{"email"}, ForeignTable: users, ForeignColumnNames: []string{"email"}},
+	})
+
+	user := masker.Values(Record{Table: users, Columns: []string{"email"}, Values: []interface{}{"jane@corp.com"}})
+	reminder := masker.Values(Record{Table: reminders, Columns: []string{"invite_email"}, Values: []interface{}{"jane@corp.com"}})
+	if user[0] == "jane@corp.com" || user[0] != reminder[0] {
+		t.Errorf("referencing column masked to %v, want %v", reminder[0], user[0])
+	}
+}
+
+func TestNewMaskerInvalid(t *testing.T) {
+	tests := map[string]config.MaskRule{
+		"email":       {Strategy: MaskHash},
+		"users.email": {Strategy: "scramble"},
+	}
+
+	for column, rule := range tests {
+		_, err := NewMasker(config.ExportConfig{Masking: map[string]config.MaskRule{column: rule}})
+		if err == nil {
+			t.Errorf("NewMasker(%s: %s) should fail", column, rule.Strategy)
+		}
+	}
+}
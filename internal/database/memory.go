@@ -0,0 +1,239 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// MemorySource is a SchemaSource and RowSource holding synthetic tables in
+// memory, so that exports can run without a database. It matches rows by
+// key values and cannot evaluate SQL conditions.
+type MemorySource struct {
+	schema      string
+	tables      map[TableName]*memoryTable
+	foreignKeys []ForeignKey
+}
+
+// memoryTable is a table of a MemorySource
+type memoryTable struct {
+	columns    []Column
+	primaryKey []string
+	rows       [][]interface{}
+}
+
+// NewMemorySource creates an empty source whose tables live in schema
+func NewMemorySource(schema string) *MemorySource {
+	return &MemorySource{schema: schema, tables: make(map[TableName]*memoryTable)}
+}
+
+// Table returns the name of a table of the source's schema
+func (m *MemorySource) Table(name string) TableName {
+	return TableName{Schema: m.schema, Name: name}
+}
+
+// AddTable adds a table with untyped columns and a primary key
+func (m *MemorySource) AddTable(name string, columns []string, primaryKey ...string) {
+	table := &memoryTable{primaryKey: primaryKey}
+	for _, column := range columns {
+		table.columns = append(table.columns, Column{Name: column})
+	}
+	m.tables[m.Table(name)] = table
+}
+
+// AddForeignKey declares a foreign key between two tables of the source
+func (m *MemorySource) AddForeignKey(fk ForeignKey) {
+	m.foreignKeys = append(m.foreignKeys, fk)
+}
+
+// Insert adds a row to a table, with one value per column. Integers are
+// stored as int64, the type database drivers scan them as.
+func (m *MemorySource) Insert(name string, values ...interface{}) error {
+	table, err := m.table(m.Table(name))
+	if err != nil {
+		return err
+	}
+	if len(values) != len(table.columns) {
+		return fmt.Errorf("table %s has %d columns, got %d values", name, len(table.columns), len(values))
+	}
+
+	row := make([]interface{}, len(values))
+	for i, value := range values {
+		if n, ok := value.(int); ok {
+			value = int64(n)
+		}
+		row[i] = value
+	}
+	table.rows = append(table.rows, row)
+	return nil
+}
+
+func (m *MemorySource) DefaultSchema() (string, error) {
+	return m.schema, nil
+}
+
+func (m *MemorySource) TableColumns(name TableName) ([]Column, error) {
+	table, err := m.table(name)
+	if err != nil {
+		return nil, err
+	}
+	return table.columns, nil
+}
+
+func (m *MemorySource) PrimaryKeyColumns(name TableName) ([]string, error) {
+	table, err := m.table(name)
+	if err != nil {
+		return nil, err
+	}
+	return table.primaryKey, nil
+}
+
+func (m *MemorySource) ForeignKeys(name TableName) ([]ForeignKey, error) {
+	var fks []ForeignKey
+	for _, fk := range m.foreignKeys {
+		if fk.Table == name {
+			fks = append(fks, fk)
+		}
+	}
+	return fks, nil
+}
+
+func (m *MemorySource) ReferencingKeys(name TableName) ([]ForeignKey, error) {
+	var fks []ForeignKey
+	for _, fk := range m.foreignKeys {
+		if fk.ForeignTable == name {
+			fks = append(fks, fk)
+		}
+	}
+	return fks, nil
+}
+
+// EstimateRows returns the exact number of rows, since they are at hand
+func (m *MemorySource) EstimateRows(name TableName) (int64, error) {
+	table, err := m.table(name)
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(table.rows)), nil
+}
+
+// Rows calls fn with the rows a query selects, copied so that callers may
+// keep them
+func (m *MemorySource) Rows(ctx context.Context, q RowQuery, fn func(values []interface{}) error) error {
+	rows, err := m.selectRows(q)
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MemorySource) CountRows(ctx context.Context, q RowQuery) (int64, error) {
+	rows, err := m.selectRows(q)
+	return int64(len(rows)), err
+}
+
+// selectRows returns the query's columns of the rows it selects, in order
+func (m *MemorySource) selectRows(q RowQuery) ([][]interface{}, error) {
+	if q.Where != "" && len(q.Match) == 0 {
+		return nil, fmt.Errorf("memory source cannot evaluate the condition %q", q.Where)
+	}
+	table, err := m.table(q.Table)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := make(map[string]bool, len(q.Tuples))
+	for _, tuple := range q.Tuples {
+		wanted[keyID(tuple)] = true
+	}
+
+	var rows []Record
+	for _, values := range table.rows {
+		record := Record{Table: q.Table, Columns: table.columnNames(), Values: values}
+		if len(q.Match) > 0 {
+			key, ok := record.columnValues(q.Match)
+			if !ok || !wanted[keyID(key)] {
+				continue
+			}
+		}
+		rows = append(rows, record)
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		return lessValues(rows[i], rows[j], q.OrderBy)
+	})
+	if q.Limit > 0 && len(rows) > q.Limit {
+		rows = rows[:q.Limit]
+	}
+
+	indexes := columnIndexes(Record{Columns: table.columnNames()}, q.Columns)
+	if len(indexes) != len(q.Columns) {
+		return nil, fmt.Errorf("table %s does not have all of the columns %v", q.Table, q.Columns)
+	}
+
+	selected := make([][]interface{}, len(rows))
+	for i, record := range rows {
+		selected[i] = make([]interface{}, len(indexes))
+		for j, k := range indexes {
+			selected[i][j] = record.Values[k]
+		}
+	}
+	return selected, nil
+}
+
+// table returns a table of the source
+func (m *MemorySource) table(name TableName) (*memoryTable, error) {
+	table, ok := m.tables[name]
+	if !ok {
+		return nil, fmt.Errorf("table %s does not exist", name)
+	}
+	return table, nil
+}
+
+// columnNames returns the names of the table's columns
+func (t *memoryTable) columnNames() []string {
+	names := make([]string, len(t.columns))
+	for i, column := range t.columns {
+		names[i] = column.Name
+	}
+	return names
+}
+
+// lessValues orders two records by columns, comparing integers by value and
+// anything else by its text. NULLs sort first.
+func lessValues(a, b Record, columns []string) bool {
+	for _, column := range columns {
+		x, xOK := a.columnValues([]string{column})
+		y, yOK := b.columnValues([]string{column})
+		if !xOK || !yOK {
+			if xOK != yOK {
+				return yOK
+			}
+			continue
+		}
+
+		m, errM := integerValue(x[0])
+		n, errN := integerValue(y[0])
+		if errM == nil && errN == nil {
+			if m != n {
+				return m < n
+			}
+			continue
+		}
+
+		s, t := formatKeyValues(x), formatKeyValues(y)
+		if s != t {
+			return s < t
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+// memoryFixture builds organizations whose members have a composite key,
+// and posts referencing their author and, optionally, an editor
+func memoryFixture(t *testing.T) *MemorySource {
+	t.Helper()
+
+	m := NewMemorySource("app")
+	m.AddTable("orgs", []string{"id", "name"}, "id")
+	m.AddTable("members", []string{"org_id", "id", "email"}, "org_id", "id")
+	m.AddTable("posts", []string{"id", "org_id", "author_id", "editor_id"}, "id")
+	m.AddForeignKey(ForeignKey{ConstraintName: "members_org", Table: m.Table("members"), ColumnNames: []string{"org_id"},
+		ForeignTable: m.Table("orgs"), ForeignColumnNames: []string{"id"}})
+	m.AddForeignKey(ForeignKey{ConstraintName: "posts_author", Table: m.Table("posts"), ColumnNames: []string{"org_id", "author_id"},
+		ForeignTable: m.Table("members"), ForeignColumnNames: []string{"org_id", "id"}})
+	m.AddForeignKey(ForeignKey{ConstraintName: "posts_editor", Table: m.Table("posts"), ColumnNames: []string{"org_id", "editor_id"},
+		ForeignTable: m.Table("members"), ForeignColumnNames: []string{"org_id", "id"}})
+
+	rows := []struct {
+		table  string
+		values []interface{}
+	}{
+		{"orgs", []interface{}{1, "Acme"}},
+		{"orgs", []interface{}{2, "Globex"}},
+		{"members", []interface{}{1, 1, "jane@acme.com"}},
+		{"members", []interface{}{2, 1, "hank@globex.com"}},
+		{"members", []interface{}{1, 2, "joe@acme.com"}},
+		{"posts", []interface{}{10, 1, 2, nil}},
+		{"posts", []interface{}{11, 2, 1, nil}},
+		{"posts", []interface{}{12, 1, 2, 1}},
+	}
+	for _, row := range rows {
+		if err := m.Insert(row.table, row.values...); err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+	return m
+}
+
+// exportMemory exports from a memory source
+func exportMemory(t *testing.T, m *MemorySource, roots Roots, opts ExportOptions) (*Exporter, []Record) {
+	t.Helper()
+
+	exporter := NewSourceExporter(m, m)
+	records, err := exporter.Export(context.Background(), roots, opts)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	return exporter, records
+}
+
+// recordTables returns the table of each record, in order
+func recordTables(records []Record) []string {
+	tables := make([]string, len(records))
+	for i, record := range records {
+		tables[i] = record.Table.Name
+	}
+	return tables
+}
+
+func TestMemoryExportCompositeKeys(t *testing.T) {
+	m := memoryFixture(t)
+	_, records := exportMemory(t, m, Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{10}}}}, ExportOptions{})
+
+	// The author is found by both key columns, and the NULL editor is not followed
+	if tables := recordTables(records); !reflect.DeepEqual(tables, []string{"orgs", "members", "posts"}) {
+		t.Fatalf("exported tables = %v, want orgs, members, posts", tables)
+	}
+	if expected := [][]interface{}{{int64(1), int64(2), "joe@acme.com"}}; !reflect.DeepEqual(rowsOf(records, "members"), expected) {
+		t.Errorf("members rows = %v, want %v", rowsOf(records, "members"), expected)
+	}
+}
+
+func TestMemoryExportMissingParentWarning(t *testing.T) {
+	m := memoryFixture(t)
+	if err := m.Insert("posts", 13, 1, 2, 9); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	// The missing editor is reported on the progress writer, not stdout
+	var progress bytes.Buffer
+	exporter := NewSourceExporter(m, m)
+	exporter.SetProgress(&progress)
+	if _, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{13}}}}, ExportOptions{}); err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(progress.String(), "Warning: record not found: app.members") {
+		t.Errorf("progress = %q, want a warning about the missing editor", progress.String())
+	}
+}
+
+func TestMemoryExportRootLimit(t *testing.T) {
+	m := memoryFixture(t)
+	_, records := exportMemory(t, m, Roots{Table: TableName{Name: "members"}, Limit: 2}, ExportOptions{})
+
+	// Roots are the first rows by primary key: (1, 1) and (1, 2)
+	expected := [][]interface{}{{int64(1), int64(1), "jane@acme.com"}, {int64(1), int64(2), "joe@acme.com"}}
+	if rows := rowsOf(records, "members"); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("members rows = %v, want %v", rows, expected)
+	}
+}
+
+func TestMemoryExportRejectsWhere(t *testing.T) {
+	m := memoryFixture(t)
+	exporter := NewSourceExporter(m, m)
+	if _, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Where: "id > 10"}, ExportOptions{}); err == nil {
+		t.Error("Export with a WHERE condition succeeded, want an error")
+	}
+}
+
+func TestMemoryExportCycles(t *testing.T) {
+	tests := []struct {
+		name       string
+		deferrable bool
+		fixups     int
+	}{
+		{name: "immediate constraints", fixups: 1},
+		{name: "deferrable constraints", deferrable: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMemorySource("app")
+			m.AddTable("teams", []string{"id", "lead_id"}, "id")
+			m.AddTable("people", []string{"id", "team_id"}, "id")
+			m.AddForeignKey(ForeignKey{Table: m.Table("teams"), ColumnNames: []string{"lead_id"},
+				ForeignTable: m.Table("people"), ForeignColumnNames: []string{"id"}, Deferrable: tt.deferrable})
+			m.AddForeignKey(ForeignKey{Table: m.Table("people"), ColumnNames: []string{"team_id"},
+				ForeignTable: m.Table("teams"), ForeignColumnNames: []string{"id"}, Deferrable: tt.deferrable})
+			if err := m.Insert("teams", 1, 5); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.Insert("people", 5, 1); err != nil {
+				t.Fatal(err)
+			}
+
+			exporter, records := exportMemory(t, m, Roots{Table: TableName{Name: "teams"}, Keys: []Key{{Values: []interface{}{1}}}}, ExportOptions{})
+			if len(records) != 2 {
+				t.Fatalf("exported %d records, want 2", len(records))
+			}
+
+			steps := exporter.LoadSteps()
+			if steps.DeferConstraints != tt.deferrable {
+				t.Errorf("DeferConstraints = %v, want %v", steps.DeferConstraints, tt.deferrable)
+			}
+			if len(steps.Fixups) != tt.fixups {
+				t.Errorf("got %d fixups, want %d: %v", len(steps.Fixups), tt.fixups, steps.Fixups)
+			}
+		})
+	}
+}
+
+func TestMemoryExportMasking(t *testing.T) {
+	m := memoryFixture(t)
+	masker, err := NewMasker(config.ExportConfig{Masking: map[string]config.MaskRule{"members.id": {Strategy: MaskHash}}})
+	if err != nil {
+		t.Fatalf("NewMasker failed: %v", err)
+	}
+
+	exporter := NewSourceExporter(m, m)
+	collector := &recordCollector{}
+	roots := Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{10}}}}
+	if err := exporter.ExportTo(context.Background(), roots, ExportOptions{}, exporter.FollowMasking(masker, collector)); err != nil {
+		t.Fatalf("ExportTo failed: %v", err)
+	}
+
+	// The post's author_id is masked like the member's id it references
+	member := masker.Values(collector.records[1])
+	post := masker.Values(collector.records[2])
+	if member[1] == int64(2) || post[2] != member[1] {
+		t.Errorf("posts.author_id masked to %v, want %v", post[2], member[1])
+	}
+}
+
+func TestMemoryRemapIDs(t *testing.T) {
+	m := memoryFixture(t)
+	exporter, records := exportMemory(t, m, Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{12}}}}, ExportOptions{})
+
+	if _, err := exporter.RemapIDs(records, RemapOptions{Strategy: RemapOffset, Offset: 100}); err != nil {
+		t.Fatalf("RemapIDs failed: %v", err)
+	}
+
+	// members.org_id follows orgs, and both post references follow members
+	expected := [][]interface{}{{int64(112), int64(101), int64(102), int64(101)}}
+	if rows := rowsOf(records, "posts"); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("posts rows = %v, want %v", rows, expected)
+	}
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// migrationDialect is implemented by dialects that can change column types
+// and foreign keys of existing tables
+type migrationDialect interface {
+	AlterColumnTypeStatement(table TableName, column Column) string
+	AddForeignKeyStatement(fk ForeignKey) string
+	DropForeignKeyStatement(fk ForeignKey) string
+}
+
+// migration collects the statements of WriteMigration by phase, so that
+// foreign keys are dropped before the tables and columns they use, and
+// added after those exist
+type migration struct {
+	dialect Dialect
+	drops   []string // Foreign keys, indexes and constraints going away
+	creates []string // New tables, with what they need first
+	alters  []string // Column changes
+	adds    []string // New foreign keys, indexes and constraints
+	removes []string // Dropped tables
+	seen    map[string]bool
+}
+
+// WriteMigration writes SQL that turns the diff's base schema into its
+// target's. Tables are named without their schema, so that the statements
+// apply to the default schema of whichever database runs them. Changes the
+// dialect cannot make in place are written as comments to handle by hand.
+func WriteMigration(w io.Writer, dbType string, diff *SchemaDiff) error {
+	dialect, err := DialectFor(dbType)
+	if err != nil {
+		return err
+	}
+
+	m := &migration{dialect: dialect, seen: make(map[string]bool)}
+	var after []string
+	for _, t := range diff.Added {
+		m.creates = appendNew(m.creates, m.seen, t.DDL.Before)
+		m.creates = append(m.creates, t.DDL.Create)
+		after = appendNew(after, m.seen, t.DDL.After)
+	}
+	for _, t := range diff.Changed {
+		m.changeTable(t)
+	}
+	m.adds = append(m.adds, after...)
+	for _, t := range diff.Dropped {
+		m.removes = append(m.removes, fmt.Sprintf("DROP TABLE IF EXISTS %s;\n", m.table(t.Table)))
+	}
+
+	fmt.Fprintln(w, "-- Generated by agentenv db diff. Column defaults, NOT NULL and")
+	fmt.Fprintln(w, "-- ON DELETE actions are not compared; review before applying.")
+	for _, statements := range [][]string{m.drops, m.creates, m.alters, m.adds, m.removes} {
+		for _, statement := range statements {
+			fmt.Fprintf(w, "\n%s", statement)
+		}
+	}
+	return nil
+}
+
+// changeTable adds the statements that change one table
+func (m *migration) changeTable(t TableDiff) {
+	table := m.table(t.Table)
+	alter, canAlter := m.dialect.migrations, m.dialect.migrations != nil
+
+	for _, fk := range t.DroppedForeignKeys {
+		if canAlter {
+			m.drops = append(m.drops, alter.DropForeignKeyStatement(unqualifiedForeignKey(fk)))
+		} else {
+			m.drops = append(m.drops, fmt.Sprintf("-- Rebuild %s to drop its foreign key %s\n", t.Table.Name, describeForeignKey(fk)))
+		}
+	}
+	for _, index := range t.DroppedIndexes {
+		m.drops = append(m.drops, index.Drop)
+	}
+	for _, c := range t.DroppedConstraints {
+		m.drops = append(m.drops, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s;\n", table, m.dialect.queries.QuoteIdentifier(c.Name)))
+	}
+
+	for _, column := range t.AddedColumns {
+		m.alters = append(m.alters, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;\n", table, m.dialect.queries.QuoteIdentifier(column.Name), column.Type))
+	}
+	for _, column := range t.DroppedColumns {
+		m.alters = append(m.alters, fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s;\n", table, m.dialect.queries.QuoteIdentifier(column.Name)))
+	}
+	for _, change := range t.ChangedColumns {
+		if canAlter {
+			m.alters = append(m.alters, alter.AlterColumnTypeStatement(TableName{Name: t.Table.Name}, Column{Name: change.Name, Type: change.NewType}))
+		} else {
+			m.alters = append(m.alters, fmt.Sprintf("-- Rebuild %s to change the type of %s from %s to %s\n", t.Table.Name, change.Name, change.OldType, change.NewType))
+		}
+	}
+	if len(t.OldPrimaryKey) > 0 || len(t.NewPrimaryKey) > 0 {
+		m.alters = append(m.alters, fmt.Sprintf("-- Change the primary key of %s from (%s) to (%s)\n",
+			t.Table.Name, strings.Join(t.OldPrimaryKey, ", "), strings.Join(t.NewPrimaryKey, ", ")))
+	}
+
+	for _, c := range t.AddedConstraints {
+		m.adds = append(m.adds, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s;\n", table, m.dialect.queries.QuoteIdentifier(c.Name), c.Definition))
+	}
+	for _, index := range t.AddedIndexes {
+		m.adds = append(m.adds, index.Create)
+	}
+	for _, fk := range t.AddedForeignKeys {
+		if canAlter {
+			m.adds = append(m.adds, alter.AddForeignKeyStatement(unqualifiedForeignKey(fk)))
+		} else {
+			m.adds = append(m.adds, fmt.Sprintf("-- Rebuild %s to add its foreign key %s\n", t.Table.Name, describeForeignKey(fk)))
+		}
+	}
+}
+
+// table quotes a table's name without its schema
+func (m *migration) table(t TableName) string {
+	return m.dialect.queries.QuoteIdentifier(t.Name)
+}
+
+// unqualifiedForeignKey drops the schemas of a foreign key's tables
+func unqualifiedForeignKey(fk ForeignKey) ForeignKey {
+	fk.Table = TableName{Name: fk.Table.Name}
+	fk.ForeignTable = TableName{Name: fk.ForeignTable.Name}
+	return fk
+}
+
+// foreignKeyDefinition returns the constraint clause of a foreign key for
+// ALTER TABLE ... ADD
+func foreignKeyDefinition(d identifierQuoter, fk ForeignKey) string {
+	definition := fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)",
+		d.QuoteIdentifier(fk.ConstraintName), strings.Join(quoteColumns(d, fk.ColumnNames), ", "),
+		quoteTable(d, fk.ForeignTable), strings.Join(quoteColumns(d, fk.ForeignColumnNames), ", "))
+	if fk.Deferrable {
+		definition += " DEFERRABLE"
+	}
+	return definition
+}
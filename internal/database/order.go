@@ -0,0 +1,214 @@
+package database
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+)
+
+// Records are written as soon as every record they reference is written:
+// a topological sort run while the traversal finds records. A record
+// referencing rows that are not written yet waits in memory, keyed by the
+// rows it waits for. Once the traversal is done, only reference cycles
+// remain; the earliest record of a cycle is written anyway, and its
+// references to records not yet written are deferred or fixed up.
+
+// pendingRecord is a visited record waiting for the rows it references
+type pendingRecord struct {
+	record Record
+	refs   []pendingRef // References to rows not written yet
+}
+
+// pendingRef is a reference from a pending record to a row not written yet
+type pendingRef struct {
+	key string // refKey of the referenced row
+	fk  ForeignKey
+}
+
+// refKey identifies a referenced row by its table and the values of the
+// referenced columns
+func refKey(table TableName, columns []string, values []interface{}) string {
+	return table.String() + "(" + strings.Join(columns, ",") + ")=" + keyID(values)
+}
+
+// addPending holds a visited record until the rows it references are
+// written, and returns its position in the order records were found
+func (e *Exporter) addPending(record Record) int {
+	id := e.found
+	e.found++
+	e.pending[id] = &pendingRecord{record: record}
+	return id
+}
+
+// await makes a pending record wait for the row of table whose columns
+// equal values, unless that row was written already
+func (e *Exporter) await(id int, fk ForeignKey, values []interface{}) {
+	key := refKey(fk.ForeignTable, fk.ForeignColumnNames, values)
+	if e.written[key] {
+		return
+	}
+
+	p := e.pending[id]
+	p.refs = append(p.refs, pendingRef{key: key, fk: fk})
+	e.waiters[key] = append(e.waiters[key], id)
+
+	for _, columns := range e.waitColumns[fk.ForeignTable] {
+		if sameColumns(columns, fk.ForeignColumnNames) {
+			return
+		}
+	}
+	e.waitColumns[fk.ForeignTable] = append(e.waitColumns[fk.ForeignTable], fk.ForeignColumnNames)
+}
+
+// release queues a pending record to be written once it waits for nothing
+func (e *Exporter) release(id int) {
+	if len(e.pending[id].refs) == 0 {
+		heap.Push(&e.ready, id)
+	}
+}
+
+// resolve stops records waiting for a row, because it was written or
+// does not exist
+func (e *Exporter) resolve(key string) {
+	for _, id := range e.waiters[key] {
+		p, ok := e.pending[id]
+		if !ok || len(p.refs) == 0 {
+			continue
+		}
+
+		refs := p.refs[:0]
+		for _, ref := range p.refs {
+			if ref.key != key {
+				refs = append(refs, ref)
+			}
+		}
+		if len(refs) < len(p.refs) {
+			p.refs = refs
+			e.release(id)
+		}
+	}
+	delete(e.waiters, key)
+}
+
+// provide marks the keys of a written record as written, including keys of
+// columns that records wait for, and releases the records waiting for them
+func (e *Exporter) provide(record *Record) error {
+	pkColumns, err := e.schema.PrimaryKey(record.Table)
+	if err != nil {
+		return err
+	}
+
+	columnLists := append([][]string{pkColumns}, e.waitColumns[record.Table]...)
+	for _, columns := range columnLists {
+		values, ok := record.columnValues(columns)
+		if !ok {
+			continue
+		}
+		key := refKey(record.Table, columns, values)
+		e.written[key] = true
+		e.resolve(key)
+	}
+	return nil
+}
+
+// writeReady writes every record that waits for nothing, earliest found
+// first, along with the records they release
+func (e *Exporter) writeReady() error {
+	for e.ready.Len() > 0 {
+		id := heap.Pop(&e.ready).(int)
+		p := e.pending[id]
+		delete(e.pending, id)
+
+		if err := e.out.WriteRecord(p.record); err != nil {
+			return err
+		}
+		if e.rows[p.record.Table] == 0 {
+			e.tables = append(e.tables, p.record.Table)
+		}
+		e.rows[p.record.Table]++
+
+		if err := e.provide(&p.record); err != nil {
+			return err
+		}
+	}
+
+	if e.opts.MaxBufferedRows > 0 && len(e.pending) > e.opts.MaxBufferedRows {
+		return fmt.Errorf("more than %d rows are waiting for the rows they reference", e.opts.MaxBufferedRows)
+	}
+	return nil
+}
+
+// writeCycles writes the records left waiting once the traversal is done,
+// which wait for each other in reference cycles
+func (e *Exporter) writeCycles() error {
+	for len(e.pending) > 0 {
+		earliest := -1
+		for id := range e.pending {
+			if earliest < 0 || id < earliest {
+				earliest = id
+			}
+		}
+
+		e.breakCycle(e.pending[earliest])
+		e.release(earliest)
+		if err := e.writeReady(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// breakCycle records how to insert a record before the records it still
+// waits for: deferrable constraints are checked at commit, and other
+// foreign keys are inserted as NULL and set afterwards
+func (e *Exporter) breakCycle(p *pendingRecord) {
+	record := p.record
+	pkColumns, _ := e.schema.PrimaryKey(record.Table)
+
+	for _, ref := range p.refs {
+		if ref.fk.Deferrable {
+			e.steps.DeferConstraints = true
+			continue
+		}
+		if _, ok := record.columnValues(pkColumns); !ok {
+			e.warn("cannot break reference cycle at %s without a primary key column", record.Table)
+			continue
+		}
+		e.steps.Fixups = append(e.steps.Fixups, Fixup{Record: record, KeyColumns: pkColumns, Columns: ref.fk.ColumnNames})
+	}
+	p.refs = nil
+}
+
+// loadSequences finds the sequences owned by the exported tables, if the
+// dialect has any
+func (e *Exporter) loadSequences() error {
+	sequences, ok := e.catalog.(sequenceSource)
+	if !ok {
+		return nil
+	}
+
+	for _, table := range e.tables {
+		owned, err := sequences.OwnedSequences(table)
+		if err != nil {
+			return fmt.Errorf("failed to get sequences for table %s: %w", table, err)
+		}
+		e.steps.Sequences = append(e.steps.Sequences, owned...)
+	}
+
+	return nil
+}
+
+// indexHeap is a min-heap of record positions, so that ready records are
+// written in the order they were found
+type indexHeap []int
+
+func (h indexHeap) Len() int            { return len(h) }
+func (h indexHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h indexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *indexHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *indexHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
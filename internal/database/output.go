@@ -0,0 +1,429 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Output formats for agentenv export --format
+const (
+	FormatSQLInsert = "sql-insert"
+	FormatSQLCopy   = "sql-copy"
+	FormatJSON      = "json"
+	FormatCSV       = "csv"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{FormatSQLInsert, FormatSQLCopy, FormatJSON, FormatCSV}
+
+// RecordWriter writes exported records, in dependency order, in one output
+// format. Close finishes the output and must be called once all records are
+// written; Abort ends an output that failed part way instead.
+type RecordWriter interface {
+	WriteRecord(record Record) error
+	Close() error
+	Abort() error
+}
+
+// OutputOptions describes where and how exported records are written
+type OutputOptions struct {
+	Format  string
+	Dialect Dialect    // Dialect of the SQL formats
+	Masker  *Masker    // Masking rules applied to every value (optional)
+	Writer  io.Writer  // Destination of the sql-insert, sql-copy and json formats
+	Dir     string     // Destination directory of the csv format
+	Steps   *LoadSteps // Cycle breaking and sequence resets of the SQL formats (optional)
+}
+
+// insertDialect writes rows as INSERT statements of SQL literals
+type insertDialect interface {
+	// Literal formats a scanned value as an SQL literal for a column of the
+	// given type, which may be empty if unknown
+	Literal(value interface{}, columnType string) string
+
+	// InsertStatement returns an INSERT that skips rows which already exist.
+	// Values are SQL literals; the table and columns are quoted by the dialect.
+	InsertStatement(table TableName, columns, values []string) string
+}
+
+// NewRecordWriter returns the writer for a format
+func NewRecordWriter(opts OutputOptions) (RecordWriter, error) {
+	switch opts.Format {
+	case FormatSQLInsert, "":
+		return newInsertWriter(opts), nil
+	case FormatSQLCopy:
+		if _, ok := opts.Dialect.queries.(postgresDialect); !ok {
+			return nil, fmt.Errorf("the %s format requires PostgreSQL", FormatSQLCopy)
+		}
+		return newCopyWriter(opts), nil
+	case FormatJSON:
+		return &jsonWriter{opts: opts}, nil
+	case FormatCSV:
+		if opts.Dir == "" {
+			return nil, fmt.Errorf("the %s format requires an output directory", FormatCSV)
+		}
+		if err := os.MkdirAll(opts.Dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create output directory: %w", err)
+		}
+		return &csvWriter{opts: opts, files: make(map[TableName]*csvFile)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q (expected one of: %s)", opts.Format, strings.Join(Formats, ", "))
+	}
+}
+
+// WriteRecords writes all records and closes the writer, or aborts it if a
+// record cannot be written
+func WriteRecords(w RecordWriter, records []Record) error {
+	for _, record := range records {
+		if err := w.WriteRecord(record); err != nil {
+			w.Abort()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// writeSQLHeader starts an SQL export with a comment and a transaction,
+// creating the tables and deferring constraint checks to commit if the
+// steps require it
+func writeSQLHeader(opts OutputOptions) {
+	fmt.Fprintf(opts.Writer, "-- Database export generated by agentenv\n")
+	fmt.Fprintf(opts.Writer, "-- Generated at: %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(opts.Writer, "BEGIN;\n\n")
+	if opts.Steps != nil {
+		for _, statement := range opts.Steps.Schema {
+			fmt.Fprintf(opts.Writer, "%s\n", statement)
+		}
+	}
+	if opts.Steps != nil && opts.Steps.DeferConstraints {
+		fmt.Fprintf(opts.Writer, "SET CONSTRAINTS ALL DEFERRED;\n\n")
+	}
+}
+
+// insertWriter writes one INSERT per record that skips rows which already
+// exist. Records are written as they are exported, so constraint checks are
+// deferred from the first record that needs it.
+type insertWriter struct {
+	opts     OutputOptions
+	deferred bool
+}
+
+func newInsertWriter(opts OutputOptions) *insertWriter {
+	writeSQLHeader(opts)
+	return &insertWriter{opts: opts, deferred: opts.Steps != nil && opts.Steps.DeferConstraints}
+}
+
+// WriteRecord writes the record's INSERT statement
+func (w *insertWriter) WriteRecord(record Record) error {
+	if !w.deferred && w.opts.Steps != nil && w.opts.Steps.DeferConstraints {
+		w.deferred = true
+		fmt.Fprintf(w.opts.Writer, "SET CONSTRAINTS ALL DEFERRED;\n")
+	}
+
+	// Build value list with proper escaping
+	values := make([]string, len(record.Values))
+	for i, val := range w.opts.Steps.insertValues(record, w.opts.Masker) {
+		values[i] = w.opts.Dialect.inserts.Literal(val, record.columnType(i))
+	}
+
+	_, err := fmt.Fprintf(w.opts.Writer, "%s\n", w.opts.Dialect.inserts.InsertStatement(record.Table, record.Columns, values))
+	return err
+}
+
+// Close finishes loading the records and commits the transaction
+func (w *insertWriter) Close() error {
+	writeSQLSteps(w.opts)
+	_, err := fmt.Fprintf(w.opts.Writer, "COMMIT;\n")
+	return err
+}
+
+// Abort ends the transaction without loading anything
+func (w *insertWriter) Abort() error {
+	_, err := fmt.Fprintf(w.opts.Writer, "ROLLBACK;\n")
+	return err
+}
+
+// copyWriter writes one COPY ... FROM stdin block per table. Rows are
+// collected until Close, and tables are written in order of their first
+// record, which keeps parents ahead of their children unless tables
+// reference each other in a cycle.
+type copyWriter struct {
+	opts    OutputOptions
+	tables  []TableName
+	columns map[TableName][]string
+	rows    map[TableName][]string
+}
+
+func newCopyWriter(opts OutputOptions) *copyWriter {
+	return &copyWriter{
+		opts:    opts,
+		columns: make(map[TableName][]string),
+		rows:    make(map[TableName][]string),
+	}
+}
+
+// WriteRecord adds the record to its table's COPY block
+func (w *copyWriter) WriteRecord(record Record) error {
+	if _, ok := w.columns[record.Table]; !ok {
+		w.tables = append(w.tables, record.Table)
+		w.columns[record.Table] = record.Columns
+	}
+
+	fields := make([]string, len(record.Values))
+	for i, val := range w.opts.Steps.insertValues(record, w.opts.Masker) {
+		fields[i] = copyValue(val, record.columnType(i))
+	}
+	w.rows[record.Table] = append(w.rows[record.Table], strings.Join(fields, "\t"))
+	return nil
+}
+
+// Close writes every table's COPY block inside one transaction
+func (w *copyWriter) Close() error {
+	writeSQLHeader(w.opts)
+
+	for _, table := range w.tables {
+		fmt.Fprintf(w.opts.Writer, "COPY %s (%s) FROM stdin;\n",
+			quoteTable(w.opts.Dialect.queries, table), strings.Join(quoteColumns(w.opts.Dialect.queries, w.columns[table]), ", "))
+		for _, row := range w.rows[table] {
+			fmt.Fprintf(w.opts.Writer, "%s\n", row)
+		}
+		fmt.Fprintf(w.opts.Writer, "\\.\n\n")
+	}
+
+	writeSQLSteps(w.opts)
+	_, err := fmt.Fprintf(w.opts.Writer, "COMMIT;\n")
+	return err
+}
+
+// Abort discards the collected rows without writing anything
+func (w *copyWriter) Abort() error {
+	return nil
+}
+
+// copyValue formats a value for COPY's text format, where \N is NULL, in the
+// same input syntax as the column type's literals
+func copyValue(val interface{}, columnType string) string {
+	text := ""
+	switch v := val.(type) {
+	case nil:
+		return `\N`
+	case []byte:
+		text = string(v)
+		if columnType == "bytea" {
+			text = `\x` + hex.EncodeToString(v)
+		}
+	case time.Time:
+		text = postgresTime(v, columnType)
+	default:
+		text = textValue(v)
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+	return replacer.Replace(text)
+}
+
+// textValue formats a non-NULL value as plain text for COPY and CSV
+func textValue(val interface{}) string {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+// jsonRecord is one element of the json format's array
+type jsonRecord struct {
+	Table   string        `json:"table"`
+	Columns []string      `json:"columns"`
+	Values  []interface{} `json:"values"`
+}
+
+// jsonWriter streams records as a JSON array in dependency order. Numbers,
+// booleans and NULL keep their JSON types; text values are strings, binary
+// values base64 strings, and timestamps RFC 3339 strings.
+type jsonWriter struct {
+	opts    OutputOptions
+	written int
+}
+
+// WriteRecord writes the record as the next array element
+func (w *jsonWriter) WriteRecord(record Record) error {
+	values := w.opts.Masker.Values(record)
+	element := jsonRecord{Table: record.Table.String(), Columns: record.Columns, Values: make([]interface{}, len(values))}
+	for i, val := range values {
+		// Drivers return text of some types as bytes too, so binary
+		// columns are told apart by their type
+		if b, ok := val.([]byte); ok {
+			val = string(b)
+			if isBytesType(record.columnType(i)) {
+				val = base64.StdEncoding.EncodeToString(b)
+			}
+		}
+		element.Values[i] = val
+	}
+
+	data, err := json.Marshal(element)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s record: %w", record.Table, err)
+	}
+
+	separator := ",\n  "
+	if w.written == 0 {
+		separator = "[\n  "
+	}
+	w.written++
+
+	_, err = fmt.Fprintf(w.opts.Writer, "%s%s", separator, data)
+	return err
+}
+
+// Abort leaves the array unfinished, so that it does not parse
+func (w *jsonWriter) Abort() error {
+	return nil
+}
+
+// Close ends the array
+func (w *jsonWriter) Close() error {
+	if w.written == 0 {
+		_, err := fmt.Fprintf(w.opts.Writer, "[]\n")
+		return err
+	}
+	_, err := fmt.Fprintf(w.opts.Writer, "\n]\n")
+	return err
+}
+
+// csvNull is the field of a NULL value in the csv format, which keeps it
+// apart from an empty string
+const csvNull = `\N`
+
+// csvManifest describes the files of the csv format, in dependency order,
+// and how NULL and binary values are written in them
+type csvManifest struct {
+	GeneratedAt string             `json:"generated_at"`
+	Null        string             `json:"null"`
+	Binary      string             `json:"binary"`
+	Tables      []csvManifestTable `json:"tables"`
+}
+
+// csvManifestTable describes one table's file
+type csvManifestTable struct {
+	Table   string   `json:"table"`
+	File    string   `json:"file"`
+	Columns []string `json:"columns"`
+	Rows    int      `json:"rows"`
+}
+
+// csvFile is an open table file of the csv format
+type csvFile struct {
+	file   *os.File
+	writer *csv.Writer
+	entry  csvManifestTable
+}
+
+// csvWriter writes a directory with one CSV file per table, each with a
+// header row, plus manifest.json listing the tables in dependency order.
+// NULL is written as \N and binary values as base64.
+type csvWriter struct {
+	opts   OutputOptions
+	tables []TableName
+	files  map[TableName]*csvFile
+}
+
+// WriteRecord appends the record to its table's file
+func (w *csvWriter) WriteRecord(record Record) error {
+	f, err := w.tableFile(record)
+	if err != nil {
+		return err
+	}
+
+	fields := make([]string, len(record.Values))
+	for i, val := range w.opts.Masker.Values(record) {
+		b, isBytes := val.([]byte)
+		switch {
+		case val == nil:
+			fields[i] = csvNull
+		case isBytes && isBytesType(record.columnType(i)):
+			fields[i] = base64.StdEncoding.EncodeToString(b)
+		default:
+			fields[i] = textValue(val)
+		}
+	}
+
+	f.entry.Rows++
+	return f.writer.Write(fields)
+}
+
+// tableFile returns the file for a record's table, creating it with a
+// header row on the table's first record
+func (w *csvWriter) tableFile(record Record) (*csvFile, error) {
+	if f, ok := w.files[record.Table]; ok {
+		return f, nil
+	}
+
+	name := record.Table.String() + ".csv"
+	file, err := os.Create(filepath.Join(w.opts.Dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", name, err)
+	}
+
+	f := &csvFile{
+		file:   file,
+		writer: csv.NewWriter(file),
+		entry:  csvManifestTable{Table: record.Table.String(), File: name, Columns: record.Columns},
+	}
+	if err := f.writer.Write(record.Columns); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	w.tables = append(w.tables, record.Table)
+	w.files[record.Table] = f
+	return f, nil
+}
+
+// Close flushes every table file and writes the manifest
+func (w *csvWriter) Close() error {
+	manifest := csvManifest{GeneratedAt: time.Now().Format(time.RFC3339), Null: csvNull, Binary: "base64", Tables: []csvManifestTable{}}
+
+	var firstErr error
+	for _, table := range w.tables {
+		f := w.files[table]
+		f.writer.Flush()
+		if err := f.writer.Error(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("failed to write %s: %w", f.entry.File, err)
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		manifest.Tables = append(manifest.Tables, f.entry)
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.opts.Dir, "manifest.json"), append(data, '\n'), 0644)
+}
+
+// Abort closes the table files without writing the manifest, so that the
+// partial output is not mistaken for a complete one
+func (w *csvWriter) Abort() error {
+	for _, table := range w.tables {
+		w.files[table].file.Close()
+	}
+	return nil
+}
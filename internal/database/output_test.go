@@ -0,0 +1,156 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// outputRecords is a users row, one of its posts and another user
+var outputRecords = []Record{
+	{
+		Table:   TableName{Schema: "public", Name: "users"},
+		Columns: []string{"id", "email"},
+		Values:  []interface{}{int64(1), "jane@example.com"},
+	},
+	{
+		Table:   TableName{Schema: "public", Name: "posts"},
+		Columns: []string{"id", "user_id", "body"},
+		Values:  []interface{}{int64(100), int64(1), []byte("line one\n\tline two")},
+	},
+	{
+		Table:   TableName{Schema: "public", Name: "users"},
+		Columns: []string{"id", "email"},
+		Values:  []interface{}{int64(2), nil},
+	},
+}
+
+func TestCopyWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewRecordWriter(OutputOptions{Format: FormatSQLCopy, Dialect: postgresDialect{}.dialect(), Writer: &buf})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	if err := WriteRecords(w, outputRecords); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+
+	expected := `COPY "public"."users" ("id", "email") FROM stdin;
+1	jane@example.com
+2	\N
+\.
+
+COPY "public"."posts" ("id", "user_id", "body") FROM stdin;
+100	1	line one\n\tline two
+\.
+`
+	if !strings.Contains(buf.String(), expected) {
+		t.Errorf("COPY output missing:\n%s\nGot:\n%s", expected, buf.String())
+	}
+
+	if _, err := NewRecordWriter(OutputOptions{Format: FormatSQLCopy, Dialect: mysqlDialect{}.dialect(), Writer: &buf}); err == nil {
+		t.Error("sql-copy should require PostgreSQL")
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewRecordWriter(OutputOptions{Format: FormatJSON, Writer: &buf})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	if err := WriteRecords(w, outputRecords); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+
+	var decoded []jsonRecord
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if len(decoded) != 3 || decoded[1].Table != "public.posts" {
+		t.Fatalf("decoded = %v, want 3 records in export order", decoded)
+	}
+	if decoded[1].Values[0] != float64(100) || decoded[1].Values[2] != "line one\n\tline two" || decoded[2].Values[1] != nil {
+		t.Errorf("values = %v and %v, want typed values", decoded[1].Values, decoded[2].Values)
+	}
+
+	// Binary columns are base64, whatever their bytes
+	buf.Reset()
+	w, err = NewRecordWriter(OutputOptions{Format: FormatJSON, Writer: &buf})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	avatar := Record{
+		Table:   TableName{Schema: "public", Name: "avatars"},
+		Columns: []string{"id", "image"},
+		Types:   []string{"integer", "bytea"},
+		Values:  []interface{}{int64(1), []byte{0xff, 0xd8, 0x00}},
+	}
+	if err := WriteRecords(w, []Record{avatar}); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	if decoded[0].Values[1] != "/9gA" {
+		t.Errorf("bytea value = %v, want base64 /9gA", decoded[0].Values[1])
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "export")
+	w, err := NewRecordWriter(OutputOptions{Format: FormatCSV, Dir: dir})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	if err := WriteRecords(w, outputRecords); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+
+	users, err := os.ReadFile(filepath.Join(dir, "public.users.csv"))
+	if err != nil {
+		t.Fatalf("failed to read users file: %v", err)
+	}
+	if string(users) != "id,email\n1,jane@example.com\n2,\\N\n" {
+		t.Errorf("users.csv = %q", users)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatalf("failed to read manifest: %v", err)
+	}
+	var manifest csvManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("invalid manifest: %v", err)
+	}
+	if len(manifest.Tables) != 2 || manifest.Tables[0].Table != "public.users" || manifest.Tables[0].Rows != 2 ||
+		manifest.Tables[1].File != "public.posts.csv" || manifest.Null != `\N` || manifest.Binary != "base64" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	// Binary columns are base64 and empty strings stay empty
+	dir = filepath.Join(t.TempDir(), "binary")
+	w, err = NewRecordWriter(OutputOptions{Format: FormatCSV, Dir: dir})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	avatar := Record{
+		Table:   TableName{Schema: "public", Name: "avatars"},
+		Columns: []string{"id", "image", "caption"},
+		Types:   []string{"integer", "blob", "text"},
+		Values:  []interface{}{int64(1), []byte{0xff, 0xd8, 0x00}, ""},
+	}
+	if err := WriteRecords(w, []Record{avatar}); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+	avatars, err := os.ReadFile(filepath.Join(dir, "public.avatars.csv"))
+	if err != nil {
+		t.Fatalf("failed to read avatars file: %v", err)
+	}
+	if string(avatars) != "id,image,caption\n1,/9gA,\n" {
+		t.Errorf("avatars.csv = %q", avatars)
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Plan is what an export would touch, worked out from the catalog without
+// fetching rows: the tables the foreign key graph leads to under the export
+// options, and the foreign keys followed to reach them
+type Plan struct {
+	Tables  []PlanTable
+	Edges   []PlanEdge
+	Skipped []ForeignKey // Foreign keys not followed because of limits or filters
+}
+
+// PlanTable is a table an export would reach
+type PlanTable struct {
+	Table  TableName
+	Reason string // Why the table is part of the export, as in the export summary
+	Depth  int    // Foreign key hops from the root table
+	Rows   int64  // Root rows for the root table, the table's estimated size otherwise
+}
+
+// PlanEdge is a foreign key an export would follow
+type PlanEdge struct {
+	ForeignKey
+	Child bool // Followed from the referenced table to the referencing one
+}
+
+// planStep is a table reached by the plan's walk, with the hop counts of
+// the export's traversal
+type planStep struct {
+	table TableName
+	h     hop
+}
+
+// Plan walks the foreign key graph from the roots' table the way Export
+// walks it from their rows, following parents and, with IncludeChildren,
+// children within the depth limits and table filters. Every row of a table
+// could be reached, so the table's estimated size stands in for its rows.
+func (e *Exporter) Plan(ctx context.Context, roots Roots, opts ExportOptions) (*Plan, error) {
+	e.reset(opts, nil)
+
+	table := roots.Table
+	if table.Schema == "" {
+		schema, err := e.catalog.DefaultSchema()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get default schema: %w", err)
+		}
+		table.Schema = schema
+	}
+
+	rootRows, err := e.countRoots(ctx, table, roots)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count root rows of %s: %w", table, err)
+	}
+
+	plan := &Plan{}
+	root := planStep{table: table, h: hop{children: opts.IncludeChildren, reason: "root"}}
+	if err := e.walkPlan(plan, root); err != nil {
+		return nil, err
+	}
+	if err := e.estimatePlanRows(plan, rootRows); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+// walkPlan adds the tables reachable from the root step to the plan in
+// breadth-first order, the root first
+func (e *Exporter) walkPlan(plan *Plan, root planStep) error {
+	index := make(map[TableName]int)
+	expanded := make(map[string]bool)
+	followed := make(map[string]bool)
+	queue := []planStep{root}
+
+	for len(queue) > 0 {
+		step := queue[0]
+		queue = queue[1:]
+
+		// A table reached again with children followed is walked again
+		key := fmt.Sprintf("%s|%t", step.table, step.h.children)
+		if expanded[key] {
+			continue
+		}
+		expanded[key] = true
+
+		if _, ok := index[step.table]; !ok {
+			index[step.table] = len(plan.Tables)
+			plan.Tables = append(plan.Tables, PlanTable{
+				Table:  step.table,
+				Reason: step.h.reason,
+				Depth:  step.h.parentDepth + step.h.childDepth,
+			})
+		}
+
+		next, err := e.planHops(plan, followed, step)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, next...)
+	}
+	return nil
+}
+
+// estimatePlanRows fills in the rows of the plan's tables: the counted root
+// rows for the root table, the catalog's estimate for the rest
+func (e *Exporter) estimatePlanRows(plan *Plan, rootRows int64) error {
+	for i := range plan.Tables {
+		if i == 0 {
+			plan.Tables[i].Rows = rootRows
+			continue
+		}
+		rows, err := e.catalog.EstimateRows(plan.Tables[i].Table)
+		if err != nil {
+			return fmt.Errorf("failed to estimate rows of %s: %w", plan.Tables[i].Table, err)
+		}
+		plan.Tables[i].Rows = rows
+	}
+	return nil
+}
+
+// planHops returns the tables a step leads to, recording the foreign keys
+// followed and skipped
+func (e *Exporter) planHops(plan *Plan, followed map[string]bool, step planStep) ([]planStep, error) {
+	var next []planStep
+	h := step.h
+
+	foreignKeys, err := e.schema.ForeignKeys(step.table)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range foreignKeys {
+		if !e.followsTable(fk.ForeignTable) || !e.withinDepth(h.parentDepth+1, h.childDepth) {
+			plan.Skipped = appendEdge(plan.Skipped, followed, fk, "skipped")
+			continue
+		}
+		plan.Edges = appendPlanEdge(plan.Edges, followed, PlanEdge{ForeignKey: fk})
+		next = append(next, planStep{table: fk.ForeignTable, h: hop{
+			parentDepth: h.parentDepth + 1,
+			childDepth:  h.childDepth,
+			reason: fmt.Sprintf("parent of %s via %s(%s)",
+				step.table, step.table, strings.Join(fk.ColumnNames, ", ")),
+		}})
+	}
+
+	if !h.children || !e.withinDepth(h.parentDepth, h.childDepth+1) {
+		return next, nil
+	}
+
+	referencingKeys, err := e.schema.ReferencingKeys(step.table)
+	if err != nil {
+		return nil, err
+	}
+	for _, fk := range referencingKeys {
+		if !e.followsTable(fk.Table) {
+			continue
+		}
+		plan.Edges = appendPlanEdge(plan.Edges, followed, PlanEdge{ForeignKey: fk, Child: true})
+		next = append(next, planStep{table: fk.Table, h: hop{
+			parentDepth: h.parentDepth,
+			childDepth:  h.childDepth + 1,
+			children:    true,
+			reason: fmt.Sprintf("child of %s via %s(%s)",
+				step.table, fk.Table, strings.Join(fk.ColumnNames, ", ")),
+		}})
+	}
+
+	return next, nil
+}
+
+// appendPlanEdge appends a followed foreign key once
+func appendPlanEdge(edges []PlanEdge, seen map[string]bool, edge PlanEdge) []PlanEdge {
+	key := foreignKeyID(edge.ForeignKey)
+	if seen[key] {
+		return edges
+	}
+	seen[key] = true
+	return append(edges, edge)
+}
+
+// appendEdge appends a foreign key once per kind of list
+func appendEdge(fks []ForeignKey, seen map[string]bool, fk ForeignKey, kind string) []ForeignKey {
+	key := kind + "|" + foreignKeyID(fk)
+	if seen[key] {
+		return fks
+	}
+	seen[key] = true
+	return append(fks, fk)
+}
+
+// foreignKeyID identifies a foreign key constraint
+func foreignKeyID(fk ForeignKey) string {
+	return fk.Table.String() + "." + fk.ConstraintName
+}
+
+// countRoots counts the root rows: the explicit keys plus the rows the
+// WHERE condition and LIMIT select
+func (e *Exporter) countRoots(ctx context.Context, table TableName, roots Roots) (int64, error) {
+	count := int64(len(roots.Keys))
+	if roots.Where == "" && roots.Limit == 0 {
+		return count, nil
+	}
+
+	selected, err := e.source.CountRows(ctx, RowQuery{Table: table, Where: roots.Where, Limit: roots.Limit})
+	if err != nil {
+		return 0, err
+	}
+	return count + selected, nil
+}
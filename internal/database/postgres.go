@@ -0,0 +1,522 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// postgresDialect reads metadata from pg_catalog and information_schema
+type postgresDialect struct{}
+
+// dialect returns every part of the dialect, sequences and constraints
+// included
+func (d postgresDialect) dialect() Dialect {
+	return Dialect{conn: d, catalog: d, keys: d, queries: d, inserts: d, ddl: d, estimates: d,
+		sequences: d, constraints: d, migrations: d}
+}
+
+// Open connects using a postgres:// or postgresql:// URL
+func (postgresDialect) Open(url string) (*sql.DB, error) {
+	return sql.Open("postgres", url)
+}
+
+// DefaultSchema returns the first schema on the search_path
+func (postgresDialect) DefaultSchema(db *sql.DB) (string, error) {
+	var schema string
+	err := db.QueryRow("SELECT current_schema()").Scan(&schema)
+	return schema, err
+}
+
+// Tables returns the ordinary and partitioned tables of a schema
+func (postgresDialect) Tables(db *sql.DB, schema string) ([]TableName, error) {
+	query := `
+		SELECT cl.relname
+		FROM pg_class cl
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE n.nspname = $1
+			AND cl.relkind IN ('r', 'p')
+		ORDER BY cl.relname
+	`
+
+	return queryTables(db, schema, query, schema)
+}
+
+// TableColumns returns all columns of a table, typed as format_type()
+// spells them, e.g. "numeric(12,4)" or "timestamp with time zone"
+func (postgresDialect) TableColumns(db *sql.DB, table TableName) ([]Column, error) {
+	query := `
+		SELECT a.attname, format_type(a.atttypid, a.atttypmod), a.attgenerated <> ''
+		FROM pg_attribute a
+		JOIN pg_class cl ON cl.oid = a.attrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		WHERE n.nspname = $1
+			AND cl.relname = $2
+			AND a.attnum > 0
+			AND NOT a.attisdropped
+		ORDER BY a.attnum
+	`
+
+	return queryColumns(db, query, table.Schema, table.Name)
+}
+
+// PrimaryKeyColumns returns the primary key column names for a table
+func (postgresDialect) PrimaryKeyColumns(db *sql.DB, table TableName) ([]string, error) {
+	query := `
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_class cl ON cl.oid = i.indrelid
+		JOIN pg_namespace n ON n.oid = cl.relnamespace
+		CROSS JOIN LATERAL unnest(i.indkey) WITH ORDINALITY AS k(attnum, position)
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = k.attnum
+		WHERE n.nspname = $1
+			AND cl.relname = $2
+			AND i.indisprimary
+		ORDER BY k.position
+	`
+
+	pkColumns, err := queryStrings(db, query, table.Schema, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkColumns) == 0 {
+		return nil, fmt.Errorf("table %s has %w", table, ErrNoPrimaryKey)
+	}
+
+	return pkColumns, nil
+}
+
+// postgresForeignKeysQuery lists foreign key column pairs. conkey and confkey
+// are unnested together so composite keys keep their column pairing.
+const postgresForeignKeysQuery = `
+	SELECT
+		c.conname,
+		n.nspname,
+		cl.relname,
+		a.attname,
+		fn.nspname AS foreign_schema_name,
+		fcl.relname AS foreign_table_name,
+		fa.attname AS foreign_column_name,
+		c.condeferrable
+	FROM pg_constraint c
+	JOIN pg_class cl ON cl.oid = c.conrelid
+	JOIN pg_namespace n ON n.oid = cl.relnamespace
+	JOIN pg_class fcl ON fcl.oid = c.confrelid
+	JOIN pg_namespace fn ON fn.oid = fcl.relnamespace
+	CROSS JOIN LATERAL unnest(c.conkey, c.confkey) WITH ORDINALITY AS k(attnum, fattnum, position)
+	JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum
+	JOIN pg_attribute fa ON fa.attrelid = c.confrelid AND fa.attnum = k.fattnum
+	WHERE c.contype = 'f'
+`
+
+// ForeignKeys returns all foreign key relationships for a table
+func (postgresDialect) ForeignKeys(db *sql.DB, table TableName) ([]ForeignKey, error) {
+	query := postgresForeignKeysQuery + `
+		AND n.nspname = $1
+		AND cl.relname = $2
+		ORDER BY c.conname, k.position
+	`
+
+	return queryForeignKeys(db, query, table.Schema, table.Name)
+}
+
+// ReferencingKeys returns the foreign keys, in any schema, that reference a table
+func (postgresDialect) ReferencingKeys(db *sql.DB, table TableName) ([]ForeignKey, error) {
+	query := postgresForeignKeysQuery + `
+		AND fn.nspname = $1
+		AND fcl.relname = $2
+		ORDER BY n.nspname, cl.relname, c.conname, k.position
+	`
+
+	return queryForeignKeys(db, query, table.Schema, table.Name)
+}
+
+// Indexes returns the indexes of a table that no constraint owns, created
+// as pg_get_indexdef() spells them
+func (d postgresDialect) Indexes(db *sql.DB, table TableName) ([]Index, error) {
+	query := `
+		SELECT ic.relname, pg_get_indexdef(i.indexrelid)
+		FROM pg_index i
+		JOIN pg_class ic ON ic.oid = i.indexrelid
+		WHERE i.indrelid = $1::regclass
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conindid = i.indexrelid)
+		ORDER BY ic.relname
+	`
+
+	rows, err := db.Query(query, quoteTable(d, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []Index
+	for rows.Next() {
+		var index Index
+		if err := rows.Scan(&index.Name, &index.Create); err != nil {
+			return nil, err
+		}
+		index.Create += ";\n"
+		index.Drop = fmt.Sprintf("DROP INDEX IF EXISTS %s;\n", quoteTable(d, TableName{Schema: table.Schema, Name: index.Name}))
+		indexes = append(indexes, index)
+	}
+
+	return indexes, rows.Err()
+}
+
+// postgresSequencesQuery lists the sequences that depend on a table's
+// columns: 'a' for serial columns, 'i' for identity columns
+const postgresSequencesQuery = `
+	SELECT sn.nspname, s.relname, a.attname
+	FROM pg_depend d
+	JOIN pg_class s ON s.oid = d.objid AND s.relkind = 'S'
+	JOIN pg_namespace sn ON sn.oid = s.relnamespace
+	JOIN pg_class cl ON cl.oid = d.refobjid
+	JOIN pg_namespace n ON n.oid = cl.relnamespace
+	JOIN pg_attribute a ON a.attrelid = cl.oid AND a.attnum = d.refobjsubid
+	WHERE d.classid = 'pg_class'::regclass
+		AND d.refclassid = 'pg_class'::regclass
+		AND n.nspname = $1
+		AND cl.relname = $2
+`
+
+// OwnedSequences returns the sequences behind a table's serial and identity columns
+func (postgresDialect) OwnedSequences(db *sql.DB, table TableName) ([]Sequence, error) {
+	return querySequences(db, postgresSequencesQuery+"AND d.deptype IN ('a', 'i') ORDER BY a.attnum", table)
+}
+
+// querySequences runs a sequence query for a table
+func querySequences(db *sql.DB, query string, table TableName) ([]Sequence, error) {
+	rows, err := db.Query(query, table.Schema, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sequences []Sequence
+	for rows.Next() {
+		seq := Sequence{Table: table}
+		if err := rows.Scan(&seq.Name.Schema, &seq.Name.Name, &seq.Column); err != nil {
+			return nil, err
+		}
+		sequences = append(sequences, seq)
+	}
+
+	return sequences, rows.Err()
+}
+
+// SetSequenceStatement advances a sequence past the largest value in its
+// column, without moving it backwards
+func (d postgresDialect) SetSequenceStatement(seq Sequence) string {
+	sequence := quoteTable(d, seq.Name)
+	return fmt.Sprintf("SELECT setval(%s, GREATEST((SELECT MAX(%s) FROM %s), (SELECT last_value FROM %s)));\n",
+		postgresString(sequence), d.QuoteIdentifier(seq.Column), quoteTable(d, seq.Table), sequence)
+}
+
+// QuoteIdentifier wraps a name in double quotes
+func (postgresDialect) QuoteIdentifier(name string) string {
+	return quoteWith(`"`, name)
+}
+
+// Placeholder returns $n
+func (postgresDialect) Placeholder(n int) string {
+	return fmt.Sprintf("$%d", n)
+}
+
+// MatchAny matches a single column with = ANY($1) and one array argument,
+// so the query text is the same for any number of values. Composite keys
+// use an IN list.
+func (d postgresDialect) MatchAny(columns []string, tuples [][]interface{}) (string, []interface{}) {
+	if len(columns) != 1 {
+		return matchAnyList(d, columns, tuples)
+	}
+
+	values := make([]interface{}, len(tuples))
+	for i, tuple := range tuples {
+		values[i] = tuple[0]
+		// Text-like types such as uuid and numeric are scanned as bytes
+		if b, ok := values[i].([]byte); ok {
+			values[i] = string(b)
+		}
+	}
+	return fmt.Sprintf("%s = ANY($1)", d.QuoteIdentifier(columns[0])), []interface{}{pq.Array(values)}
+}
+
+// Literal formats a value according to the column's Postgres type
+func (postgresDialect) Literal(value interface{}, columnType string) string {
+	return postgresLiteral(value, columnType)
+}
+
+// InsertStatement uses ON CONFLICT DO NOTHING to skip duplicates.
+// OVERRIDING SYSTEM VALUE lets it keep the values of GENERATED ALWAYS
+// identity columns, and has no effect on other tables.
+func (d postgresDialect) InsertStatement(table TableName, columns, values []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s)\nOVERRIDING SYSTEM VALUE\nVALUES (%s)\nON CONFLICT DO NOTHING;\n",
+		quoteTable(d, table), strings.Join(quoteColumns(d, columns), ", "), strings.Join(values, ", "))
+}
+
+// EstimateRows reads the row estimate the planner keeps in pg_class, which
+// is -1 for tables never analyzed
+func (d postgresDialect) EstimateRows(db *sql.DB, table TableName) (int64, error) {
+	var rows int64
+	err := db.QueryRow("SELECT GREATEST(reltuples, 0)::bigint FROM pg_class WHERE oid = $1::regclass", quoteTable(d, table)).Scan(&rows)
+	return rows, err
+}
+
+// CreateTable builds a table's DDL from the catalog: its schema, enum types
+// and serial sequences first, then the table with its columns, defaults and
+// constraints. Deferred foreign keys are added once every table exists, and
+// only if the table they reference exists by then.
+func (d postgresDialect) CreateTable(db *sql.DB, table TableName, deferred []ForeignKey) (TableDDL, error) {
+	var ddl TableDDL
+	regclass := quoteTable(d, table)
+
+	if table.Schema != "public" {
+		ddl.Before = append(ddl.Before, fmt.Sprintf("CREATE SCHEMA IF NOT EXISTS %s;\n", d.QuoteIdentifier(table.Schema)))
+	}
+
+	enums, err := d.enumTypes(db, regclass)
+	if err != nil {
+		return ddl, fmt.Errorf("failed to get enum types: %w", err)
+	}
+	ddl.Before = append(ddl.Before, enums...)
+
+	serials, err := querySequences(db, postgresSequencesQuery+"AND d.deptype = 'a' ORDER BY a.attnum", table)
+	if err != nil {
+		return ddl, fmt.Errorf("failed to get sequences: %w", err)
+	}
+	for _, seq := range serials {
+		ddl.Before = append(ddl.Before, fmt.Sprintf("CREATE SEQUENCE IF NOT EXISTS %s;\n", quoteTable(d, seq.Name)))
+		ddl.After = append(ddl.After, fmt.Sprintf("ALTER SEQUENCE %s OWNED BY %s.%s;\n",
+			quoteTable(d, seq.Name), regclass, d.QuoteIdentifier(seq.Column)))
+	}
+
+	definitions, err := d.columnDefinitions(db, regclass)
+	if err != nil {
+		return ddl, fmt.Errorf("failed to get columns: %w", err)
+	}
+
+	constraints, err := d.constraints(db, regclass)
+	if err != nil {
+		return ddl, fmt.Errorf("failed to get constraints: %w", err)
+	}
+	for _, c := range constraints {
+		if fk, ok := findForeignKey(deferred, c.name); ok {
+			ddl.After = append(ddl.After, d.addForeignKey(regclass, quoteTable(d, fk.ForeignTable), c))
+			continue
+		}
+		definitions = append(definitions, fmt.Sprintf("CONSTRAINT %s %s", d.QuoteIdentifier(c.name), c.definition))
+	}
+
+	ddl.Create = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n    %s\n);\n", regclass, strings.Join(definitions, ",\n    "))
+	return ddl, nil
+}
+
+// enumTypes returns statements creating the enum types of a table's
+// columns, including the element types of array columns
+func (d postgresDialect) enumTypes(db *sql.DB, regclass string) ([]string, error) {
+	query := `
+		SELECT n.nspname, t.typname, e.enumlabel
+		FROM pg_type t
+		JOIN pg_namespace n ON n.oid = t.typnamespace
+		JOIN pg_enum e ON e.enumtypid = t.oid
+		WHERE t.oid IN (
+			SELECT et.oid
+			FROM pg_attribute a
+			JOIN pg_type at ON at.oid = a.atttypid
+			JOIN pg_type et ON et.oid IN (at.oid, at.typelem)
+			WHERE a.attrelid = $1::regclass
+				AND a.attnum > 0
+				AND NOT a.attisdropped
+				AND et.typtype = 'e'
+		)
+		ORDER BY n.nspname, t.typname, e.enumsortorder
+	`
+
+	rows, err := db.Query(query, regclass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []TableName
+	labels := make(map[TableName][]string)
+	for rows.Next() {
+		var name TableName
+		var label string
+		if err := rows.Scan(&name.Schema, &name.Name, &label); err != nil {
+			return nil, err
+		}
+		if labels[name] == nil {
+			types = append(types, name)
+		}
+		labels[name] = append(labels[name], postgresString(label))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// CREATE TYPE has no IF NOT EXISTS
+	statements := make([]string, len(types))
+	for i, name := range types {
+		statements[i] = fmt.Sprintf("DO $$ BEGIN\n    CREATE TYPE %s AS ENUM (%s);\nEXCEPTION WHEN duplicate_object THEN NULL;\nEND $$;\n",
+			quoteTable(d, name), strings.Join(labels[name], ", "))
+	}
+	return statements, nil
+}
+
+// columnDefinitions returns the definitions of a table's columns with their
+// types, defaults, identity or generation expressions and NOT NULL
+func (d postgresDialect) columnDefinitions(db *sql.DB, regclass string) ([]string, error) {
+	query := `
+		SELECT
+			a.attname,
+			format_type(a.atttypid, a.atttypmod),
+			a.attnotnull,
+			COALESCE(pg_get_expr(ad.adbin, ad.adrelid), ''),
+			a.attidentity::text,
+			a.attgenerated::text
+		FROM pg_attribute a
+		LEFT JOIN pg_attrdef ad ON ad.adrelid = a.attrelid AND ad.adnum = a.attnum
+		WHERE a.attrelid = $1::regclass
+			AND a.attnum > 0
+			AND NOT a.attisdropped
+		ORDER BY a.attnum
+	`
+
+	rows, err := db.Query(query, regclass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var definitions []string
+	for rows.Next() {
+		var name, columnType, expr, identity, generated string
+		var notNull bool
+		if err := rows.Scan(&name, &columnType, &notNull, &expr, &identity, &generated); err != nil {
+			return nil, err
+		}
+
+		definition := d.QuoteIdentifier(name) + " " + columnType
+		switch {
+		case generated == "s":
+			definition += " GENERATED ALWAYS AS (" + expr + ") STORED"
+		case identity == "a":
+			definition += " GENERATED ALWAYS AS IDENTITY"
+		case identity == "d":
+			definition += " GENERATED BY DEFAULT AS IDENTITY"
+		case expr != "":
+			definition += " DEFAULT " + expr
+		}
+		if notNull {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, definition)
+	}
+
+	return definitions, rows.Err()
+}
+
+// postgresConstraint is a table constraint as pg_get_constraintdef writes it
+type postgresConstraint struct {
+	name       string
+	definition string
+}
+
+// constraints returns a table's primary key, unique, check, exclusion and
+// foreign key constraints, in that order
+func (d postgresDialect) constraints(db *sql.DB, regclass string) ([]postgresConstraint, error) {
+	query := `
+		SELECT conname, pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE conrelid = $1::regclass
+			AND contype IN ('p', 'u', 'c', 'x', 'f')
+		ORDER BY position(contype::text IN 'pucxf'), conname
+	`
+
+	rows, err := db.Query(query, regclass)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var constraints []postgresConstraint
+	for rows.Next() {
+		var c postgresConstraint
+		if err := rows.Scan(&c.name, &c.definition); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+
+	return constraints, rows.Err()
+}
+
+// Constraints returns a table's unique, check and exclusion constraints
+func (d postgresDialect) Constraints(db *sql.DB, table TableName) ([]Constraint, error) {
+	query := `
+		SELECT conname, pg_get_constraintdef(oid)
+		FROM pg_constraint
+		WHERE conrelid = $1::regclass
+			AND contype IN ('u', 'c', 'x')
+		ORDER BY conname
+	`
+
+	rows, err := db.Query(query, quoteTable(d, table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var constraints []Constraint
+	for rows.Next() {
+		var c Constraint
+		if err := rows.Scan(&c.Name, &c.Definition); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+
+	return constraints, rows.Err()
+}
+
+// AlterColumnTypeStatement changes a column's type, converting its values
+// the way an assignment would
+func (d postgresDialect) AlterColumnTypeStatement(table TableName, column Column) string {
+	return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s;\n", quoteTable(d, table), d.QuoteIdentifier(column.Name), column.Type)
+}
+
+// AddForeignKeyStatement adds a foreign key constraint
+func (d postgresDialect) AddForeignKeyStatement(fk ForeignKey) string {
+	return fmt.Sprintf("ALTER TABLE %s ADD %s;\n", quoteTable(d, fk.Table), foreignKeyDefinition(d, fk))
+}
+
+// DropForeignKeyStatement drops a foreign key constraint
+func (d postgresDialect) DropForeignKeyStatement(fk ForeignKey) string {
+	return fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s;\n", quoteTable(d, fk.Table), d.QuoteIdentifier(fk.ConstraintName))
+}
+
+// addForeignKey returns a statement adding a foreign key constraint unless
+// it exists already or the table it references does not exist
+func (d postgresDialect) addForeignKey(regclass, foreignRegclass string, c postgresConstraint) string {
+	return fmt.Sprintf(`DO $$ BEGIN
+    IF to_regclass(%s) IS NOT NULL AND NOT EXISTS (
+        SELECT 1 FROM pg_constraint WHERE conrelid = %s::regclass AND conname = %s
+    ) THEN
+        ALTER TABLE %s ADD CONSTRAINT %s %s;
+    END IF;
+END $$;
+`, postgresString(foreignRegclass), postgresString(regclass), postgresString(c.name), regclass, d.QuoteIdentifier(c.name), c.definition)
+}
+
+// findForeignKey looks up a foreign key by constraint name
+func findForeignKey(fks []ForeignKey, name string) (ForeignKey, bool) {
+	for _, fk := range fks {
+		if fk.ConstraintName == name {
+			return fk, true
+		}
+	}
+	return ForeignKey{}, false
+}
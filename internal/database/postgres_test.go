@@ -0,0 +1,280 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// literalCases are values as lib/pq scans them, with their column types
+var literalCases = []struct {
+	columnType string
+	value      interface{}
+}{
+	{"integer", int64(42)},
+	{"bigint", int64(-9007199254740993)},
+	{"boolean", true},
+	{"double precision", 0.1},
+	{"real", math.Inf(-1)},
+	{"numeric(12,4)", []byte("12345678.1234")},
+	{"numeric", []byte("NaN")},
+	{"text", "it's a \\ backslash"},
+	{"character varying(255)", "plain"},
+	{"bytea", []byte{0xde, 0xad, 0xbe, 0xef, '\\', '\''}},
+	{"jsonb", []byte(`{"a": [1, "x'y"]}`)},
+	{"integer[]", []byte("{1,2,NULL}")},
+	{"text[]", []byte(`{"a b","c\"d"}`)},
+	{"uuid", []byte("a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11")},
+	{"interval", []byte("1 day 02:03:04.5")},
+	{"inet", []byte("192.168.0.1/24")},
+	{"mood", []byte("happy")},
+	{"timestamp with time zone", time.Date(2024, 3, 1, 12, 30, 45, 123456000, time.FixedZone("", 2*3600))},
+	{"timestamp(3) without time zone", time.Date(2024, 3, 1, 12, 30, 45, 120000000, time.UTC)},
+	{"date", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	{"time without time zone", time.Date(0, 1, 1, 8, 15, 0, 1000, time.UTC)},
+	{"text", nil},
+}
+
+func TestPostgresLiteralsGolden(t *testing.T) {
+	var buf bytes.Buffer
+	for _, c := range literalCases {
+		fmt.Fprintf(&buf, "%s: %s\n", c.columnType, postgresDialect{}.Literal(c.value, c.columnType))
+	}
+
+	golden := filepath.Join("testdata", "postgres_literals.golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0644); err != nil {
+			t.Fatalf("failed to update golden file: %v", err)
+		}
+	}
+
+	expected, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("literals differ from %s (run with -update to accept):\n%s", golden, buf.String())
+	}
+}
+
+// roundTripTable has a column of every type the literal formatting handles
+const roundTripTable = `
+	CREATE TYPE mood AS ENUM ('sad', 'happy');
+	CREATE TABLE everything (
+		id serial PRIMARY KEY,
+		b bytea,
+		ints integer[],
+		texts text[],
+		j jsonb,
+		n numeric(12,4),
+		u uuid,
+		iv interval,
+		ts timestamptz,
+		tsn timestamp,
+		d date,
+		t time,
+		addr inet,
+		m mood,
+		f float8,
+		r real,
+		s text,
+		flag boolean
+	);
+	INSERT INTO everything (b, ints, texts, j, n, u, iv, ts, tsn, d, t, addr, m, f, r, s, flag) VALUES
+		('\xdeadbeef5c27', '{1,2,NULL}', '{"a b","c\"d",NULL}', '{"a": [1, "x''y"]}', 12345678.1234,
+		 'a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11', '1 day 02:03:04.5', '2024-03-01 12:30:45.123456+02',
+		 '2024-03-01 12:30:45.123456', '2024-03-01', '08:15:00.000001', '192.168.0.1/24', 'happy',
+		 0.1, 'Infinity', 'it''s a \ backslash', true),
+		(NULL, '{}', NULL, 'null', 'NaN', NULL, '-1 mons', '-infinity', NULL, NULL, NULL, '::1', NULL,
+		 'NaN', -0.5, E'tab\there', false);
+`
+
+// TestPostgresRoundTrip exports rows of every supported type, re-imports the
+// SQL, and checks that the rows are identical. It needs a scratch database,
+// given as AGENTENV_TEST_POSTGRES_URL, in which it creates its own schema.
+func TestPostgresRoundTrip(t *testing.T) {
+	url := os.Getenv("AGENTENV_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("AGENTENV_TEST_POSTGRES_URL not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	schema := fmt.Sprintf("agentenv_roundtrip_%d", os.Getpid())
+	setup := fmt.Sprintf("SET standard_conforming_strings = on; CREATE SCHEMA %s; SET search_path = %s;", schema, schema)
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(context.Background(), setup+roundTripTable); err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+	defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+	before := snapshotRows(t, db, schema)
+
+	exporter, err := NewExporter(context.Background(), "postgresql", url)
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Schema: schema, Name: "everything"}, Where: "true"}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	// Re-import with the setting the old escaping got wrong
+	for _, conforming := range []string{"on", "off"} {
+		var script bytes.Buffer
+		if err := GenerateSQL(records, postgresDialect{}.dialect(), nil, &script); err != nil {
+			t.Fatalf("GenerateSQL failed: %v", err)
+		}
+
+		reset := fmt.Sprintf("DELETE FROM %s.everything; SET standard_conforming_strings = %s;", schema, conforming)
+		if _, err := conn.ExecContext(context.Background(), reset+script.String()); err != nil {
+			t.Fatalf("re-import with standard_conforming_strings=%s failed: %v\n%s", conforming, err, script.String())
+		}
+
+		if after := snapshotRows(t, db, schema); !reflect.DeepEqual(before, after) {
+			t.Errorf("rows after round trip with standard_conforming_strings=%s:\n%v\nwant:\n%v", conforming, after, before)
+		}
+	}
+}
+
+// snapshotRows returns the text form of every fixture row
+func snapshotRows(t *testing.T, db *sql.DB, schema string) []string {
+	t.Helper()
+
+	rows, err := queryStrings(db, fmt.Sprintf("SELECT e::text FROM %s.everything e ORDER BY id", schema))
+	if err != nil {
+		t.Fatalf("failed to read rows: %v", err)
+	}
+	return rows
+}
+
+func TestPostgresSetSequenceStatement(t *testing.T) {
+	seq := Sequence{
+		Name:   TableName{Schema: "public", Name: "users_id_seq"},
+		Table:  TableName{Schema: "public", Name: "users"},
+		Column: "id",
+	}
+
+	expected := `SELECT setval('"public"."users_id_seq"', GREATEST((SELECT MAX("id") FROM "public"."users"), (SELECT last_value FROM "public"."users_id_seq")));` + "\n"
+	if got := (postgresDialect{}).SetSequenceStatement(seq); got != expected {
+		t.Errorf("SetSequenceStatement() = %q, want %q", got, expected)
+	}
+}
+
+// TestPostgresExportWithSchema exports tables with an enum, a serial, an
+// identity and a generated column, a check and a foreign key cycle, then
+// drops them and loads the export, DDL included, twice. It needs the same
+// scratch database as TestPostgresRoundTrip.
+func TestPostgresExportWithSchema(t *testing.T) {
+	url := os.Getenv("AGENTENV_TEST_POSTGRES_URL")
+	if url == "" {
+		t.Skip("AGENTENV_TEST_POSTGRES_URL not set")
+	}
+
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	defer db.Close()
+
+	schema := fmt.Sprintf("agentenv_schema_%d", os.Getpid())
+	setup := fmt.Sprintf(`
+		CREATE SCHEMA %[1]s;
+		CREATE TYPE %[1]s.mood AS ENUM ('happy', 'it''s complicated');
+		CREATE TABLE %[1]s.teams (
+			id serial PRIMARY KEY,
+			name text NOT NULL CHECK (name <> ''),
+			name_length integer GENERATED ALWAYS AS (length(name)) STORED,
+			captain_id integer
+		);
+		CREATE TABLE %[1]s.players (
+			id integer GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+			team_id integer REFERENCES %[1]s.teams,
+			moods %[1]s.mood[] DEFAULT '{}'
+		);
+		ALTER TABLE %[1]s.teams ADD CONSTRAINT teams_captain_fk FOREIGN KEY (captain_id) REFERENCES %[1]s.players DEFERRABLE;
+		INSERT INTO %[1]s.teams (name) VALUES ('reds');
+		INSERT INTO %[1]s.players (team_id, moods) VALUES (1, '{happy}');
+		UPDATE %[1]s.teams SET captain_id = 1;
+	`, schema)
+	if _, err := db.Exec(setup); err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+	defer db.Exec("DROP SCHEMA " + schema + " CASCADE")
+
+	exporter, err := NewExporter(context.Background(), "postgresql", url)
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Schema: schema, Name: "teams"}, Where: "true"}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := exporter.LoadSchema(); err != nil {
+		t.Fatalf("LoadSchema failed: %v", err)
+	}
+
+	var script bytes.Buffer
+	writer, err := NewRecordWriter(OutputOptions{Format: FormatSQLInsert, Dialect: postgresDialect{}.dialect(), Writer: &script, Steps: exporter.LoadSteps()})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	if err := WriteRecords(writer, records); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+
+	if _, err := db.Exec("DROP SCHEMA " + schema + " CASCADE"); err != nil {
+		t.Fatalf("failed to drop fixture: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec(script.String()); err != nil {
+			t.Fatalf("loading the export failed: %v\n%s", err, script.String())
+		}
+	}
+
+	var captain, mood string
+	query := fmt.Sprintf("SELECT t.captain_id::text, p.moods[1]::text FROM %[1]s.teams t JOIN %[1]s.players p ON p.id = t.captain_id", schema)
+	if err := db.QueryRow(query).Scan(&captain, &mood); err != nil {
+		t.Fatalf("failed to query loaded rows: %v", err)
+	}
+	if captain != "1" || mood != "happy" {
+		t.Errorf("loaded captain %s with mood %s, want 1 with happy", captain, mood)
+	}
+
+	// New rows get keys past the loaded ones, and the constraints are back
+	var id int
+	if err := db.QueryRow(fmt.Sprintf("INSERT INTO %s.teams (name) VALUES ('blues') RETURNING id", schema)).Scan(&id); err != nil {
+		t.Fatalf("failed to insert after loading: %v", err)
+	}
+	if id != 2 {
+		t.Errorf("new team id = %d, want 2", id)
+	}
+	if _, err := db.Exec(fmt.Sprintf("INSERT INTO %s.teams (name) VALUES ('')", schema)); err == nil {
+		t.Error("check constraint was not recreated")
+	}
+	if _, err := db.Exec(fmt.Sprintf("UPDATE %s.teams SET captain_id = 99", schema)); err == nil {
+		t.Error("deferred foreign key was not recreated")
+	}
+}
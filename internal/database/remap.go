@@ -0,0 +1,404 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Strategies for agentenv export --remap-ids
+const (
+	RemapOffset   = "offset"   // Add a fixed offset to integer keys
+	RemapUUID     = "uuid"     // Replace keys with new random UUIDs
+	RemapSequence = "sequence" // Take keys from the target database's sequences
+)
+
+// RemapStrategies lists the supported key remapping strategies
+var RemapStrategies = []string{RemapOffset, RemapUUID, RemapSequence}
+
+// RemapOptions selects how exported rows get fresh primary keys
+type RemapOptions struct {
+	Strategy string
+	Offset   int64       // Added to integer keys by the offset strategy
+	Target   *Connection // Database whose sequences supply keys to the sequence strategy
+}
+
+// IDMapping is one primary key value given a new value by RemapIDs
+type IDMapping struct {
+	Table  TableName   `json:"-"`
+	Column string      `json:"column"`
+	Old    interface{} `json:"old"`
+	New    interface{} `json:"new"`
+}
+
+// keyAllocator returns the new value of a primary key column
+type keyAllocator interface {
+	// fits reports whether the strategy can replace a key value of a
+	// column type, which may be empty if unknown. Other keys are kept.
+	fits(old interface{}, columnType string) bool
+
+	next(table TableName, column string, old interface{}) (interface{}, error)
+}
+
+// allocation is the key allocator of a strategy and the function that
+// releases it
+type allocation struct {
+	allocator keyAllocator
+	close     func()
+}
+
+// remapDepth bounds how many foreign keys are followed to find the remapped
+// column behind a foreign key column
+const remapDepth = 16
+
+// RemapIDs gives the records of the last export fresh primary keys and
+// rewrites every foreign key inside the export to match, including those of
+// the export's load steps. Primary key columns that are themselves foreign
+// keys follow the rows they reference. It returns the new value of each key.
+func (e *Exporter) RemapIDs(records []Record, opts RemapOptions) ([]IDMapping, error) {
+	alloc, err := newKeyAllocator(opts)
+	if err != nil {
+		return nil, err
+	}
+	defer alloc.close()
+
+	r := &remapper{schema: e.schema, values: make(map[string]interface{})}
+	for _, record := range records {
+		if err := r.allocate(record, alloc.allocator); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := range records {
+		records[i] = r.rewrite(records[i])
+	}
+	for i := range e.steps.Fixups {
+		e.steps.Fixups[i].Record = r.rewrite(e.steps.Fixups[i].Record)
+	}
+
+	return r.mappings, nil
+}
+
+// newKeyAllocator returns the allocator of a strategy
+func newKeyAllocator(opts RemapOptions) (allocation, error) {
+	switch opts.Strategy {
+	case RemapOffset:
+		return allocation{allocator: offsetAllocator{offset: opts.Offset}, close: func() {}}, nil
+	case RemapUUID:
+		return allocation{allocator: uuidAllocator{}, close: func() {}}, nil
+	case RemapSequence:
+		if opts.Target == nil {
+			return allocation{}, fmt.Errorf("the %s strategy requires a target database", RemapSequence)
+		}
+		allocator, err := newSequenceAllocator(opts.Target)
+		if err != nil {
+			return allocation{}, err
+		}
+		return allocation{allocator: allocator, close: func() { allocator.db.Close() }}, nil
+	default:
+		return allocation{}, fmt.Errorf("unknown remap strategy %q (expected one of: %s)", opts.Strategy, strings.Join(RemapStrategies, ", "))
+	}
+}
+
+// remapper holds the new values of the exported primary keys
+type remapper struct {
+	schema   *schemaCache
+	values   map[string]interface{} // New values by table, column and old value
+	mappings []IDMapping
+}
+
+// allocate gives new values to the record's primary key columns that are
+// not foreign keys and that the strategy fits
+func (r *remapper) allocate(record Record, allocator keyAllocator) error {
+	pkColumns, err := r.schema.PrimaryKey(record.Table)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range columnIndexes(record, pkColumns) {
+		column, old := record.Columns[i], record.Values[i]
+		if old == nil || r.isForeignKey(record.Table, column) || !allocator.fits(old, record.columnType(i)) {
+			continue
+		}
+
+		key := remapKey(record.Table, column, old)
+		if _, ok := r.values[key]; ok {
+			continue
+		}
+
+		value, err := allocator.next(record.Table, column, old)
+		if err != nil {
+			return fmt.Errorf("failed to remap %s.%s: %w", record.Table, column, err)
+		}
+		r.values[key] = value
+		r.mappings = append(r.mappings, IDMapping{Table: record.Table, Column: column, Old: printableValue(old), New: value})
+	}
+
+	return nil
+}
+
+// rewrite returns a copy of the record with remapped keys and foreign keys
+func (r *remapper) rewrite(record Record) Record {
+	values := make([]interface{}, len(record.Values))
+	for i, column := range record.Columns {
+		values[i] = record.Values[i]
+		if values[i] == nil {
+			continue
+		}
+		if value, ok := r.lookup(record.Table, column, values[i], 0); ok {
+			values[i] = value
+		}
+	}
+
+	record.Values = values
+	return record
+}
+
+// lookup returns the new value of a column, following foreign keys to the
+// remapped column they reference
+func (r *remapper) lookup(table TableName, column string, old interface{}, depth int) (interface{}, bool) {
+	if value, ok := r.values[remapKey(table, column, old)]; ok {
+		return value, true
+	}
+	if depth >= remapDepth {
+		return nil, false
+	}
+
+	fks, err := r.schema.ForeignKeys(table)
+	if err != nil {
+		return nil, false
+	}
+	for _, fk := range fks {
+		for i, fkColumn := range fk.ColumnNames {
+			if fkColumn != column {
+				continue
+			}
+			if value, ok := r.lookup(fk.ForeignTable, fk.ForeignColumnNames[i], old, depth+1); ok {
+				return value, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// isForeignKey reports whether a column is part of one of the table's foreign keys
+func (r *remapper) isForeignKey(table TableName, column string) bool {
+	fks, err := r.schema.ForeignKeys(table)
+	if err != nil {
+		return false
+	}
+	for _, fk := range fks {
+		for _, fkColumn := range fk.ColumnNames {
+			if fkColumn == column {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// remapKey identifies a key value of a table's column
+func remapKey(table TableName, column string, value interface{}) string {
+	return table.String() + "." + column + "=" + keyID([]interface{}{value})
+}
+
+// printableValue returns bytes as text, for the mapping report
+func printableValue(value interface{}) interface{} {
+	if b, ok := value.([]byte); ok {
+		return string(b)
+	}
+	return value
+}
+
+// offsetAllocator adds a fixed offset to integer keys
+type offsetAllocator struct {
+	offset int64
+}
+
+func (offsetAllocator) fits(old interface{}, columnType string) bool {
+	return isIntegerKey(old, columnType)
+}
+
+func (a offsetAllocator) next(table TableName, column string, old interface{}) (interface{}, error) {
+	n, err := integerValue(old)
+	if err != nil {
+		return nil, err
+	}
+	return n + a.offset, nil
+}
+
+// integerValue returns an integer key as int64. Drivers return some integer
+// types as bytes or text.
+func integerValue(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case []byte, string:
+		n, err := strconv.ParseInt(fmt.Sprintf("%s", v), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("key %q is not an integer", v)
+		}
+		return n, nil
+	default:
+		return 0, fmt.Errorf("key %v (%T) is not an integer", value, value)
+	}
+}
+
+// isIntegerKey reports whether a key value of a column type is an integer
+func isIntegerKey(old interface{}, columnType string) bool {
+	if columnType != "" && !isIntegerType(columnType) {
+		return false
+	}
+	_, err := integerValue(old)
+	return err == nil
+}
+
+// uuidPattern matches the text form of a UUID
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// uuidAllocator replaces UUID keys with new random (version 4) UUIDs
+type uuidAllocator struct{}
+
+func (uuidAllocator) fits(old interface{}, columnType string) bool {
+	switch v := old.(type) {
+	case []byte:
+		return uuidPattern.Match(v)
+	case string:
+		return uuidPattern.MatchString(v)
+	default:
+		return false
+	}
+}
+
+func (uuidAllocator) next(table TableName, column string, old interface{}) (interface{}, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// sequenceAllocator takes keys from the target database: from the sequence
+// owning the column if the dialect has sequences, otherwise counting up
+// from the column's largest value
+type sequenceAllocator struct {
+	db        *sql.DB
+	dialect   Dialect
+	sequences map[string]string // nextval() argument by table and column, "" if none
+	last      map[string]int64  // Last key handed out without a sequence
+}
+
+func newSequenceAllocator(target *Connection) (*sequenceAllocator, error) {
+	dialect, err := DialectFor(target.Type)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := target.open(dialect.conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to target database: %w", err)
+	}
+
+	return &sequenceAllocator{
+		db:        db,
+		dialect:   dialect,
+		sequences: make(map[string]string),
+		last:      make(map[string]int64),
+	}, nil
+}
+
+func (*sequenceAllocator) fits(old interface{}, columnType string) bool {
+	return isIntegerKey(old, columnType)
+}
+
+func (a *sequenceAllocator) next(table TableName, column string, old interface{}) (interface{}, error) {
+	name := table.String() + "." + column
+
+	sequence, err := a.sequence(table, column)
+	if err != nil {
+		return nil, err
+	}
+	if sequence != "" {
+		var n int64
+		err := a.db.QueryRow("SELECT nextval("+a.dialect.queries.Placeholder(1)+")", sequence).Scan(&n)
+		return n, err
+	}
+
+	if _, ok := a.last[name]; !ok {
+		var max sql.NullInt64
+		query := fmt.Sprintf("SELECT MAX(%s) FROM %s", a.dialect.queries.QuoteIdentifier(column), quoteTable(a.dialect.queries, table))
+		if err := a.db.QueryRow(query).Scan(&max); err != nil {
+			return nil, fmt.Errorf("failed to read largest key in target: %w", err)
+		}
+		a.last[name] = max.Int64
+	}
+	a.last[name]++
+	return a.last[name], nil
+}
+
+// sequence returns the target's sequence owning a column, or "" if none
+func (a *sequenceAllocator) sequence(table TableName, column string) (string, error) {
+	name := table.String() + "." + column
+	if sequence, ok := a.sequences[name]; ok {
+		return sequence, nil
+	}
+
+	a.sequences[name] = ""
+	if sequences := a.dialect.sequences; sequences != nil {
+		owned, err := sequences.OwnedSequences(a.db, table)
+		if err != nil {
+			return "", fmt.Errorf("failed to get sequences in target: %w", err)
+		}
+		for _, seq := range owned {
+			if seq.Column == column {
+				a.sequences[name] = quoteTable(a.dialect.queries, seq.Name)
+			}
+		}
+	}
+
+	return a.sequences[name], nil
+}
+
+// WriteIDMap writes the key mappings as JSON, grouped by table
+func WriteIDMap(w io.Writer, mappings []IDMapping) error {
+	var tables []string
+	byTable := make(map[string][]IDMapping)
+	for _, mapping := range mappings {
+		table := mapping.Table.String()
+		if _, ok := byTable[table]; !ok {
+			tables = append(tables, table)
+		}
+		byTable[table] = append(byTable[table], mapping)
+	}
+
+	// Keep tables in dependency order rather than sorting the map's keys
+	var out strings.Builder
+	out.WriteString("{")
+	for i, table := range tables {
+		name, _ := json.Marshal(table)
+		entries, err := json.MarshalIndent(byTable[table], "  ", "  ")
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			out.WriteString(",")
+		}
+		fmt.Fprintf(&out, "\n  %s: %s", name, entries)
+	}
+	out.WriteString("\n}\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+// exportFixture exports a post with its tags from the SQLite fixture
+func exportFixture(t *testing.T) (*Exporter, []Record) {
+	t.Helper()
+
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	t.Cleanup(func() { exporter.Close() })
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{100}}}}, ExportOptions{IncludeChildren: true})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	return exporter, records
+}
+
+// rowsOf returns the values of a table's records
+func rowsOf(records []Record, table string) [][]interface{} {
+	var rows [][]interface{}
+	for _, record := range records {
+		if record.Table.Name == table {
+			rows = append(rows, record.Values)
+		}
+	}
+	return rows
+}
+
+func TestRemapIDsOffset(t *testing.T) {
+	exporter, records := exportFixture(t)
+
+	mappings, err := exporter.RemapIDs(records, RemapOptions{Strategy: RemapOffset, Offset: 1000})
+	if err != nil {
+		t.Fatalf("RemapIDs failed: %v", err)
+	}
+
+	tests := []struct {
+		table    string
+		expected [][]interface{}
+	}{
+		{"users", [][]interface{}{{int64(1001), "test@example.com"}}},
+		{"posts", [][]interface{}{{int64(1100), int64(1001), "Test Post"}}},
+		{"tags", [][]interface{}{{int64(1001), "go"}, {int64(1002), "sql"}}},
+		{"post_tags", [][]interface{}{{int64(1100), int64(1001)}, {int64(1100), int64(1002)}}},
+	}
+	for _, tt := range tests {
+		if rows := rowsOf(records, tt.table); !reflect.DeepEqual(rows, tt.expected) {
+			t.Errorf("%s rows = %v, want %v", tt.table, rows, tt.expected)
+		}
+	}
+
+	// post_tags' key columns are foreign keys, so they follow posts and tags
+	if len(mappings) != 4 {
+		t.Errorf("RemapIDs returned %d mappings, want 4: %v", len(mappings), mappings)
+	}
+}
+
+func TestRemapIDsSequence(t *testing.T) {
+	exporter, records := exportFixture(t)
+
+	// The target already has the fixture rows, so new keys start above them
+	target := &Connection{Type: "sqlite", Path: createSQLiteFixture(t)}
+	if _, err := exporter.RemapIDs(records, RemapOptions{Strategy: RemapSequence, Target: target}); err != nil {
+		t.Fatalf("RemapIDs failed: %v", err)
+	}
+
+	expected := [][]interface{}{{int64(102), int64(2), "Test Post"}}
+	if rows := rowsOf(records, "posts"); !reflect.DeepEqual(rows, expected) {
+		t.Errorf("posts rows = %v, want %v", rows, expected)
+	}
+
+	counts, err := Import(target, records, exporter.LoadSteps(), nil)
+	if err != nil {
+		t.Fatalf("Import failed: %v", err)
+	}
+	for _, count := range counts {
+		if count.Skipped != 0 {
+			t.Errorf("Import skipped %d %s row(s), want none", count.Skipped, count.Table)
+		}
+	}
+}
+
+func TestRemapIDsKeepsUnfitKeys(t *testing.T) {
+	// Users have integer keys, their roles are looked up by name
+	m := NewMemorySource("app")
+	m.AddTable("roles", []string{"name"}, "name")
+	m.AddTable("users", []string{"id", "role"}, "id")
+	m.AddForeignKey(ForeignKey{ConstraintName: "users_role", Table: m.Table("users"), ColumnNames: []string{"role"},
+		ForeignTable: m.Table("roles"), ForeignColumnNames: []string{"name"}})
+	if err := m.Insert("roles", "admin"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	if err := m.Insert("users", int64(7), "admin"); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	tests := []struct {
+		strategy string
+		users    [][]interface{}
+	}{
+		{RemapOffset, [][]interface{}{{int64(1007), "admin"}}},
+		{RemapUUID, [][]interface{}{{int64(7), "admin"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			exporter, records := exportMemory(t, m, Roots{Table: m.Table("users"), Keys: []Key{{Values: []interface{}{7}}}}, ExportOptions{})
+			if _, err := exporter.RemapIDs(records, RemapOptions{Strategy: tt.strategy, Offset: 1000}); err != nil {
+				t.Fatalf("RemapIDs failed: %v", err)
+			}
+			if rows := rowsOf(records, "roles"); !reflect.DeepEqual(rows, [][]interface{}{{"admin"}}) {
+				t.Errorf("roles rows = %v, want the key kept", rows)
+			}
+			if rows := rowsOf(records, "users"); !reflect.DeepEqual(rows, tt.users) {
+				t.Errorf("users rows = %v, want %v", rows, tt.users)
+			}
+		})
+	}
+}
+
+func TestRemapIDsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts RemapOptions
+	}{
+		{"sequence without target", RemapOptions{Strategy: RemapSequence}},
+		{"unknown strategy", RemapOptions{Strategy: "shuffle"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exporter, records := exportFixture(t)
+			if _, err := exporter.RemapIDs(records, tt.opts); err == nil {
+				t.Error("RemapIDs succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestWriteIDMap(t *testing.T) {
+	mappings := []IDMapping{
+		{Table: TableName{Schema: "main", Name: "users"}, Column: "id", Old: int64(1), New: int64(1001)},
+		{Table: TableName{Schema: "main", Name: "posts"}, Column: "id", Old: int64(100), New: int64(1100)},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteIDMap(&buf, mappings); err != nil {
+		t.Fatalf("WriteIDMap failed: %v", err)
+	}
+
+	expected := `{
+  "main.users": [
+    {
+      "column": "id",
+      "old": 1,
+      "new": 1001
+    }
+  ],
+  "main.posts": [
+    {
+      "column": "id",
+      "old": 100,
+      "new": 1100
+    }
+  ]
+}
+`
+	if buf.String() != expected {
+		t.Errorf("WriteIDMap() =\n%s\nwant:\n%s", buf.String(), expected)
+	}
+}
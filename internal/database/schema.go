@@ -0,0 +1,192 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// schemaCache remembers the catalog lookups of one export, so that each
+// table's columns, primary key and foreign keys are queried only once
+type schemaCache struct {
+	source      SchemaSource
+	columns     map[TableName][]Column
+	primaryKeys map[TableName][]string
+	foreignKeys map[TableName][]ForeignKey
+	referencing map[TableName][]ForeignKey
+}
+
+func newSchemaCache(source SchemaSource) *schemaCache {
+	return &schemaCache{
+		source:      source,
+		columns:     make(map[TableName][]Column),
+		primaryKeys: make(map[TableName][]string),
+		foreignKeys: make(map[TableName][]ForeignKey),
+		referencing: make(map[TableName][]ForeignKey),
+	}
+}
+
+// Columns returns all columns of a table
+func (s *schemaCache) Columns(table TableName) ([]Column, error) {
+	if columns, ok := s.columns[table]; ok {
+		return columns, nil
+	}
+
+	columns, err := s.source.TableColumns(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get columns for table %s: %w", table, err)
+	}
+	s.columns[table] = columns
+	return columns, nil
+}
+
+// RowColumns returns the columns an export reads from a table: all but its
+// generated columns, which cannot be inserted, after the implicit rowid of
+// SQLite tables keyed on it, so that their rows can be matched by key and
+// told apart when otherwise identical
+func (s *schemaCache) RowColumns(table TableName) ([]Column, error) {
+	tableColumns, err := s.Columns(table)
+	if err != nil {
+		return nil, err
+	}
+	var columns []Column
+	for _, column := range tableColumns {
+		if !column.Generated {
+			columns = append(columns, column)
+		}
+	}
+
+	pkColumns, err := s.PrimaryKey(table)
+	if errors.Is(err, ErrNoPrimaryKey) {
+		return columns, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(pkColumns) != 1 || pkColumns[0] != sqliteRowID {
+		return columns, nil
+	}
+	for _, column := range columns {
+		if column.Name == sqliteRowID {
+			return columns, nil
+		}
+	}
+	return append([]Column{{Name: sqliteRowID, Type: "INTEGER"}}, columns...), nil
+}
+
+// PrimaryKey returns the primary key column names for a table
+func (s *schemaCache) PrimaryKey(table TableName) ([]string, error) {
+	if pkColumns, ok := s.primaryKeys[table]; ok {
+		return pkColumns, nil
+	}
+
+	pkColumns, err := s.source.PrimaryKeyColumns(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get primary key for table %s: %w", table, err)
+	}
+	s.primaryKeys[table] = pkColumns
+	return pkColumns, nil
+}
+
+// ForeignKeys returns the foreign keys declared on a table
+func (s *schemaCache) ForeignKeys(table TableName) ([]ForeignKey, error) {
+	if fks, ok := s.foreignKeys[table]; ok {
+		return fks, nil
+	}
+
+	fks, err := s.source.ForeignKeys(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get foreign keys for table %s: %w", table, err)
+	}
+	s.foreignKeys[table] = fks
+	return fks, nil
+}
+
+// ReferencingKeys returns the foreign keys on other tables that reference a table
+func (s *schemaCache) ReferencingKeys(table TableName) ([]ForeignKey, error) {
+	if fks, ok := s.referencing[table]; ok {
+		return fks, nil
+	}
+
+	fks, err := s.source.ReferencingKeys(table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get referencing keys for table %s: %w", table, err)
+	}
+	s.referencing[table] = fks
+	return fks, nil
+}
+
+// AllForeignKeys returns every foreign key looked up so far, once each
+func (s *schemaCache) AllForeignKeys() []ForeignKey {
+	seen := make(map[string]bool)
+	var all []ForeignKey
+	for _, lists := range []map[TableName][]ForeignKey{s.foreignKeys, s.referencing} {
+		for _, fks := range lists {
+			for _, fk := range fks {
+				id := fk.Table.String() + "." + fk.ConstraintName
+				if !seen[id] {
+					seen[id] = true
+					all = append(all, fk)
+				}
+			}
+		}
+	}
+	return all
+}
+
+// Fingerprint hashes the columns, primary keys and foreign keys of tables,
+// so that a change to any of them gives a different fingerprint
+func (s *schemaCache) Fingerprint(tables []TableName) (string, error) {
+	names := make([]string, len(tables))
+	byName := make(map[string]TableName)
+	for i, table := range tables {
+		names[i] = table.String()
+		byName[names[i]] = table
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		description, err := s.describe(byName[name])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\n%s\n", name, description)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:16], nil
+}
+
+// describe lists a table's columns, primary key and foreign keys, one per line
+func (s *schemaCache) describe(table TableName) (string, error) {
+	columns, err := s.Columns(table)
+	if err != nil {
+		return "", err
+	}
+	pkColumns, err := s.PrimaryKey(table)
+	if err != nil {
+		return "", err
+	}
+	fks, err := s.ForeignKeys(table)
+	if err != nil {
+		return "", err
+	}
+
+	var lines []string
+	for _, column := range columns {
+		lines = append(lines, "column "+column.Name+" "+column.Type)
+	}
+	lines = append(lines, "primary key "+strings.Join(pkColumns, ","))
+
+	var fkLines []string
+	for _, fk := range fks {
+		fkLines = append(fkLines, fmt.Sprintf("foreign key %s (%s) references %s (%s)",
+			fk.ConstraintName, strings.Join(fk.ColumnNames, ","), fk.ForeignTable, strings.Join(fk.ForeignColumnNames, ",")))
+	}
+	sort.Strings(fkLines)
+
+	return strings.Join(append(lines, fkLines...), "\n"), nil
+}
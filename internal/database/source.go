@@ -0,0 +1,205 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// SchemaSource describes the tables an export reads: the catalog of a
+// database, or a synthetic schema in tests
+type SchemaSource interface {
+	// DefaultSchema returns the schema that unqualified table names resolve to
+	DefaultSchema() (string, error)
+
+	// TableColumns returns all columns of a table in ordinal order
+	TableColumns(table TableName) ([]Column, error)
+
+	// PrimaryKeyColumns returns the primary key column names for a table in key order
+	PrimaryKeyColumns(table TableName) ([]string, error)
+
+	// ForeignKeys returns the foreign keys declared on a table, one entry per constraint
+	ForeignKeys(table TableName) ([]ForeignKey, error)
+
+	// ReferencingKeys returns the foreign keys on other tables that reference a table
+	ReferencingKeys(table TableName) ([]ForeignKey, error)
+
+	// EstimateRows returns the number of rows in a table, estimated where
+	// the source keeps statistics
+	EstimateRows(table TableName) (int64, error)
+}
+
+// RowSource reads the rows of an export
+type RowSource interface {
+	// Rows calls fn with the values of each row a query selects, in the
+	// order of the query's columns
+	Rows(ctx context.Context, q RowQuery, fn func(values []interface{}) error) error
+
+	// CountRows returns how many rows a query selects
+	CountRows(ctx context.Context, q RowQuery) (int64, error)
+}
+
+// RowQuery selects rows of a table: those whose Match columns equal one of
+// the Tuples, or else those matching the SQL condition Where (all rows if
+// empty). Rows are ordered by OrderBy, if set, and capped at Limit if it is
+// positive.
+type RowQuery struct {
+	Table   TableName
+	Columns []string // Columns to read
+	Match   []string
+	Tuples  [][]interface{}
+	Where   string
+	OrderBy []string
+	Limit   int
+}
+
+// sequenceSource is implemented by sources whose tables own sequences
+type sequenceSource interface {
+	OwnedSequences(table TableName) ([]Sequence, error)
+}
+
+// ddlSource is implemented by sources that can write the DDL of tables
+type ddlSource interface {
+	CreateTable(table TableName, deferred []ForeignKey) (TableDDL, error)
+}
+
+// catalogReader lists the schemas, tables and columns of a database
+type catalogReader interface {
+	// DefaultSchema returns the schema that unqualified table names resolve to
+	DefaultSchema(db *sql.DB) (string, error)
+
+	// Tables returns the base tables of a schema, ordered by name
+	Tables(db *sql.DB, schema string) ([]TableName, error)
+
+	// TableColumns returns all columns of a table in ordinal order
+	TableColumns(db *sql.DB, table TableName) ([]Column, error)
+}
+
+// keyReader reads the primary and foreign keys of tables
+type keyReader interface {
+	// PrimaryKeyColumns returns the primary key column names for a table in key order
+	PrimaryKeyColumns(db *sql.DB, table TableName) ([]string, error)
+
+	// ForeignKeys returns the foreign keys declared on a table, one entry per constraint
+	ForeignKeys(db *sql.DB, table TableName) ([]ForeignKey, error)
+
+	// ReferencingKeys returns the foreign keys on other tables that reference a table
+	ReferencingKeys(db *sql.DB, table TableName) ([]ForeignKey, error)
+}
+
+// ddlDialect reads the statements that create a table and its indexes
+type ddlDialect interface {
+	// CreateTable returns the DDL that creates a table if it does not exist.
+	// The deferred foreign keys reference tables that may not exist yet.
+	CreateTable(db *sql.DB, table TableName, deferred []ForeignKey) (TableDDL, error)
+
+	// Indexes returns the indexes of a table other than those backing its
+	// primary key or constraints, ordered by name
+	Indexes(db *sql.DB, table TableName) ([]Index, error)
+}
+
+// estimateDialect estimates the size of tables
+type estimateDialect interface {
+	// EstimateRows returns the number of rows in a table, from the
+	// catalog's statistics where the engine keeps them
+	EstimateRows(db *sql.DB, table TableName) (int64, error)
+}
+
+// sqlSource reads the catalog and rows of a database through its dialect
+type sqlSource struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+func (s *sqlSource) DefaultSchema() (string, error) {
+	return s.dialect.catalog.DefaultSchema(s.db)
+}
+
+func (s *sqlSource) TableColumns(table TableName) ([]Column, error) {
+	return s.dialect.catalog.TableColumns(s.db, table)
+}
+
+func (s *sqlSource) PrimaryKeyColumns(table TableName) ([]string, error) {
+	return s.dialect.keys.PrimaryKeyColumns(s.db, table)
+}
+
+func (s *sqlSource) ForeignKeys(table TableName) ([]ForeignKey, error) {
+	return s.dialect.keys.ForeignKeys(s.db, table)
+}
+
+func (s *sqlSource) ReferencingKeys(table TableName) ([]ForeignKey, error) {
+	return s.dialect.keys.ReferencingKeys(s.db, table)
+}
+
+func (s *sqlSource) EstimateRows(table TableName) (int64, error) {
+	return s.dialect.estimates.EstimateRows(s.db, table)
+}
+
+func (s *sqlSource) CreateTable(table TableName, deferred []ForeignKey) (TableDDL, error) {
+	return s.dialect.ddl.CreateTable(s.db, table, deferred)
+}
+
+// OwnedSequences returns the sequences of a table, if the dialect has any
+func (s *sqlSource) OwnedSequences(table TableName) ([]Sequence, error) {
+	if s.dialect.sequences == nil {
+		return nil, nil
+	}
+	return s.dialect.sequences.OwnedSequences(s.db, table)
+}
+
+// Rows runs the query and scans each row
+func (s *sqlSource) Rows(ctx context.Context, q RowQuery, fn func(values []interface{}) error) error {
+	query, args := s.selectQuery(q, strings.Join(quoteColumns(s.dialect.queries, q.Columns), ", "))
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		// Prepare scan destinations
+		values := make([]interface{}, len(q.Columns))
+		valuePtrs := make([]interface{}, len(q.Columns))
+		for i := range values {
+			valuePtrs[i] = &values[i]
+		}
+
+		if err := rows.Scan(valuePtrs...); err != nil {
+			return err
+		}
+		if err := fn(values); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// CountRows counts the rows of the query without reading them
+func (s *sqlSource) CountRows(ctx context.Context, q RowQuery) (int64, error) {
+	query, args := s.selectQuery(q, "1")
+
+	var count int64
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM ("+query+") selected", args...).Scan(&count)
+	return count, err
+}
+
+// selectQuery builds the SELECT statement of a query
+func (s *sqlSource) selectQuery(q RowQuery, selectList string) (string, []interface{}) {
+	where, args := "1 = 1", []interface{}(nil)
+	if len(q.Match) > 0 {
+		where, args = s.dialect.queries.MatchAny(q.Match, q.Tuples)
+	} else if q.Where != "" {
+		where = "(" + q.Where + ")"
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE %s", selectList, quoteTable(s.dialect.queries, q.Table), where)
+	if len(q.OrderBy) > 0 {
+		query += " ORDER BY " + strings.Join(quoteColumns(s.dialect.queries, q.OrderBy), ", ")
+	}
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	return query, args
+}
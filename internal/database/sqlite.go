@@ -0,0 +1,336 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"modernc.org/sqlite"
+)
+
+// sqliteHeader is the magic string at the start of every SQLite database file
+const sqliteHeader = "SQLite format 3\x00"
+
+// sqliteRowID is the key of SQLite tables declared without a primary key
+const sqliteRowID = "rowid"
+
+// sqliteDialect reads metadata with PRAGMA table-valued functions
+type sqliteDialect struct{}
+
+// dialect returns the parts of the dialect. SQLite has no sequences, and
+// cannot alter columns or foreign keys of existing tables.
+func (d sqliteDialect) dialect() Dialect {
+	return Dialect{conn: d, catalog: d, keys: d, queries: d, inserts: d, ddl: d, estimates: d}
+}
+
+// Open opens a database file path read-only, or a file: URI, which may
+// set its own mode
+func (sqliteDialect) Open(path string) (*sql.DB, error) {
+	if !strings.HasPrefix(path, "file:") {
+		path = sqliteURI(path, "ro")
+	} else if !strings.Contains(path, "?") {
+		path += "?mode=ro"
+	}
+	return sql.Open("sqlite", path)
+}
+
+// sqliteURI returns the file: URI that opens a database path in a mode: ro,
+// rw or rwc. The path is escaped so names with ? or # are not read as URI
+// parameters.
+func sqliteURI(path, mode string) string {
+	u := url.URL{Scheme: "file", Path: path, RawQuery: "mode=" + mode}
+	return u.String()
+}
+
+// DefaultSchema returns "main", the name of the opened database file
+func (sqliteDialect) DefaultSchema(db *sql.DB) (string, error) {
+	return "main", nil
+}
+
+// Tables returns the tables of an attached database, without SQLite's own
+func (sqliteDialect) Tables(db *sql.DB, schema string) ([]TableName, error) {
+	query := `
+		SELECT name
+		FROM pragma_table_list
+		WHERE schema = ?
+			AND type = 'table'
+			AND name NOT LIKE 'sqlite_%'
+		ORDER BY name
+	`
+
+	return queryTables(db, schema, query, schema)
+}
+
+// TableColumns returns all columns of a table with their declared types
+func (sqliteDialect) TableColumns(db *sql.DB, table TableName) ([]Column, error) {
+	return queryColumns(db, "SELECT name, type, FALSE FROM pragma_table_info(?, ?) ORDER BY cid", table.Name, table.Schema)
+}
+
+// PrimaryKeyColumns returns the primary key column names for a table,
+// falling back to the implicit rowid for tables declared without one
+func (sqliteDialect) PrimaryKeyColumns(db *sql.DB, table TableName) ([]string, error) {
+	pkColumns, err := queryStrings(db, "SELECT name FROM pragma_table_info(?, ?) WHERE pk > 0 ORDER BY pk", table.Name, table.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkColumns) == 0 {
+		return []string{sqliteRowID}, nil
+	}
+	return pkColumns, nil
+}
+
+// ForeignKeys returns all foreign key relationships for a table using
+// PRAGMA foreign_key_list. SQLite foreign keys cannot cross databases, so
+// the referenced table is always in the same schema. The pragma does not
+// report deferrability, so no key is treated as deferrable.
+func (d sqliteDialect) ForeignKeys(db *sql.DB, table TableName) ([]ForeignKey, error) {
+	query := `
+		SELECT
+			'fk_' || id,
+			?,
+			?,
+			"from",
+			?,
+			"table",
+			COALESCE("to", ''),
+			FALSE
+		FROM pragma_foreign_key_list(?, ?)
+		ORDER BY id, seq
+	`
+
+	fks, err := queryForeignKeys(db, query, table.Schema, table.Name, table.Schema, table.Name, table.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	// A foreign key declared without a column list references the primary key
+	for i := range fks {
+		if fks[i].ForeignColumnNames[0] == "" {
+			pkColumns, err := d.PrimaryKeyColumns(db, fks[i].ForeignTable)
+			if err != nil {
+				return nil, err
+			}
+			fks[i].ForeignColumnNames = pkColumns
+		}
+	}
+
+	return fks, nil
+}
+
+// ReferencingKeys returns the foreign keys that reference a table. SQLite has
+// no reverse lookup, so every table's foreign key list is checked.
+func (d sqliteDialect) ReferencingKeys(db *sql.DB, table TableName) ([]ForeignKey, error) {
+	tables, err := d.Tables(db, table.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	var referencing []ForeignKey
+	for _, other := range tables {
+		fks, err := d.ForeignKeys(db, other)
+		if err != nil {
+			return nil, err
+		}
+		for _, fk := range fks {
+			if strings.EqualFold(fk.ForeignTable.Name, table.Name) {
+				fk.ForeignTable = table
+				referencing = append(referencing, fk)
+			}
+		}
+	}
+
+	return referencing, nil
+}
+
+// Indexes returns the indexes created with CREATE INDEX, as stored in the
+// schema table. Indexes SQLite creates for UNIQUE and PRIMARY KEY
+// constraints have no statement and are part of the table's definition.
+func (d sqliteDialect) Indexes(db *sql.DB, table TableName) ([]Index, error) {
+	query := fmt.Sprintf(`
+		SELECT name, sql
+		FROM %s.sqlite_master
+		WHERE type = 'index'
+			AND tbl_name = ?
+			AND sql IS NOT NULL
+		ORDER BY name
+	`, d.QuoteIdentifier(table.Schema))
+
+	rows, err := db.Query(query, table.Name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var indexes []Index
+	for rows.Next() {
+		var index Index
+		if err := rows.Scan(&index.Name, &index.Create); err != nil {
+			return nil, err
+		}
+		index.Create += ";\n"
+		index.Drop = fmt.Sprintf("DROP INDEX IF EXISTS %s;\n", quoteTable(d, TableName{Schema: table.Schema, Name: index.Name}))
+		indexes = append(indexes, index)
+	}
+
+	return indexes, rows.Err()
+}
+
+// QuoteIdentifier wraps a name in double quotes
+func (sqliteDialect) QuoteIdentifier(name string) string {
+	return quoteWith(`"`, name)
+}
+
+// Placeholder returns ? since SQLite binds parameters by position
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+// MatchAny matches value tuples with an IN list
+func (d sqliteDialect) MatchAny(columns []string, tuples [][]interface{}) (string, []interface{}) {
+	return matchAnyList(d, columns, tuples)
+}
+
+// Literal formats a value by its SQLite storage class
+func (sqliteDialect) Literal(value interface{}, columnType string) string {
+	return sqliteLiteral(value)
+}
+
+// InsertStatement uses ON CONFLICT DO NOTHING to skip duplicates, which
+// unlike INSERT OR IGNORE still fails on NOT NULL and CHECK violations. The
+// schema is left off so the output can be loaded into a database file of
+// any name.
+func (d sqliteDialect) InsertStatement(table TableName, columns, values []string) string {
+	return fmt.Sprintf("INSERT INTO %s (%s)\nVALUES (%s)\nON CONFLICT DO NOTHING;\n",
+		d.QuoteIdentifier(table.Name), strings.Join(quoteColumns(d, columns), ", "), strings.Join(values, ", "))
+}
+
+// EstimateRows counts the table's rows, since SQLite keeps no row counts
+// unless ANALYZE was run
+func (d sqliteDialect) EstimateRows(db *sql.DB, table TableName) (int64, error) {
+	var rows int64
+	err := db.QueryRow("SELECT COUNT(*) FROM " + quoteTable(d, table)).Scan(&rows)
+	return rows, err
+}
+
+// CreateTable returns the table's CREATE TABLE statement as stored in the
+// schema table. SQLite does not check that referenced tables exist when a
+// table is created, so deferred foreign keys stay in place.
+func (d sqliteDialect) CreateTable(db *sql.DB, table TableName, deferred []ForeignKey) (TableDDL, error) {
+	query := fmt.Sprintf("SELECT sql FROM %s.sqlite_master WHERE type = 'table' AND name = ?", d.QuoteIdentifier(table.Schema))
+
+	var create string
+	if err := db.QueryRow(query, table.Name).Scan(&create); err != nil {
+		return TableDDL{}, err
+	}
+	return TableDDL{Create: createIfNotExists(create)}, nil
+}
+
+// CopySQLite copies a live SQLite database to destPath with the online backup
+// API, so the source can stay open by a running application
+func CopySQLite(srcPath, destPath string) error {
+	// Opening a missing file would create an empty database
+	if _, err := os.Stat(srcPath); err != nil {
+		return fmt.Errorf("failed to open %s: %w", srcPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", destPath, err)
+	}
+
+	return withSQLiteConn(srcPath, "ro", func(conn sqliteBackuper) error {
+		bck, err := conn.NewBackup(sqliteURI(destPath, "rwc"))
+		if err != nil {
+			return err
+		}
+		return finishBackup(bck)
+	})
+}
+
+// restoreSQLite loads dumpFile into the database at destPath. Database files
+// are copied page by page with the backup API; anything else is executed as SQL.
+func restoreSQLite(destPath, dumpFile string) error {
+	header := make([]byte, len(sqliteHeader))
+	f, err := os.Open(dumpFile)
+	if err != nil {
+		return fmt.Errorf("failed to open dump file: %w", err)
+	}
+	_, _ = f.Read(header)
+	f.Close()
+
+	if bytes.Equal(header, []byte(sqliteHeader)) {
+		return withSQLiteConn(destPath, "rwc", func(conn sqliteBackuper) error {
+			bck, err := conn.NewRestore(sqliteURI(dumpFile, "ro"))
+			if err != nil {
+				return err
+			}
+			return finishBackup(bck)
+		})
+	}
+
+	script, err := os.ReadFile(dumpFile)
+	if err != nil {
+		return fmt.Errorf("failed to read dump file: %w", err)
+	}
+
+	db, err := sql.Open("sqlite", sqliteURI(destPath, "rwc"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if _, err := db.Exec(string(script)); err != nil {
+		return fmt.Errorf("failed to execute %s: %w", dumpFile, err)
+	}
+
+	return nil
+}
+
+// sqliteBackuper is the part of the modernc.org/sqlite connection that
+// exposes the online backup API
+type sqliteBackuper interface {
+	NewBackup(dstUri string) (*sqlite.Backup, error)
+	NewRestore(srcUri string) (*sqlite.Backup, error)
+}
+
+// withSQLiteConn opens path in a mode and hands the raw driver connection
+// to fn
+func withSQLiteConn(path, mode string, fn func(sqliteBackuper) error) error {
+	db, err := sql.Open("sqlite", sqliteURI(path, mode))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		backuper, ok := driverConn.(sqliteBackuper)
+		if !ok {
+			return fmt.Errorf("sqlite driver does not support the backup API")
+		}
+		return fn(backuper)
+	})
+}
+
+// finishBackup copies all remaining pages and releases the backup
+func finishBackup(bck *sqlite.Backup) error {
+	for {
+		more, err := bck.Step(-1)
+		if err != nil {
+			bck.Finish()
+			return fmt.Errorf("sqlite backup failed: %w", err)
+		}
+		if !more {
+			break
+		}
+	}
+	return bck.Finish()
+}
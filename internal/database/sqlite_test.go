@@ -0,0 +1,550 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// createSQLiteFixture creates a small users/posts database in a temp directory
+func createSQLiteFixture(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "app.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL);
+		CREATE TABLE posts (
+			id INTEGER PRIMARY KEY,
+			user_id INTEGER REFERENCES users,
+			title TEXT
+		);
+		CREATE TABLE order_items (
+			order_id INTEGER,
+			line INTEGER,
+			sku TEXT,
+			PRIMARY KEY (order_id, line)
+		);
+		CREATE TABLE shipments (
+			id INTEGER PRIMARY KEY,
+			order_id INTEGER,
+			line INTEGER,
+			FOREIGN KEY (order_id, line) REFERENCES order_items (order_id, line)
+		);
+		CREATE TABLE "order" ("group" INTEGER PRIMARY KEY, "user" INTEGER REFERENCES users (id));
+		CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT);
+		CREATE TABLE post_tags (
+			post_id INTEGER REFERENCES posts (id),
+			tag_id INTEGER REFERENCES tags (id),
+			PRIMARY KEY (post_id, tag_id)
+		);
+		INSERT INTO users (id, email) VALUES (1, 'test@example.com');
+		INSERT INTO "order" ("group", "user") VALUES (7, 1);
+		INSERT INTO posts (id, user_id, title) VALUES (100, 1, 'Test Post'), (101, 1, 'Other Post');
+		INSERT INTO tags (id, name) VALUES (1, 'go'), (2, 'sql'), (3, 'unused');
+		INSERT INTO post_tags (post_id, tag_id) VALUES (100, 1), (100, 2), (101, 3);
+		INSERT INTO order_items (order_id, line, sku) VALUES (5, 1, 'A'), (5, 2, 'B'), (6, 2, 'C');
+		INSERT INTO shipments (id, order_id, line) VALUES (1, 5, 2);
+	`)
+	if err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+
+	return path
+}
+
+func TestSQLiteExport(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{100}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	if len(records) != 2 {
+		t.Fatalf("Export returned %d records, want 2", len(records))
+	}
+	if records[0].Table.Name != "users" || records[1].Table.Name != "posts" {
+		t.Errorf("Export order = [%s, %s], want [users, posts]", records[0].Table, records[1].Table)
+	}
+}
+
+func TestSQLiteExportCompositeKeys(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	// Named columns may be given in any order
+	key := Key{Columns: []string{"line", "order_id"}, Values: []interface{}{2, 5}}
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "order_items"}, Keys: []Key{key}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(records) != 1 || records[0].Values[2] != "B" {
+		t.Fatalf("Export(order_items) = %v, want the single row with sku B", records)
+	}
+
+	// The composite foreign key must resolve to (5, 2), not (5, *) or (*, 2)
+	records, err = exporter.Export(context.Background(), Roots{Table: TableName{Name: "shipments"}, Keys: []Key{{Values: []interface{}{1}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Export(shipments) returned %d records, want 2", len(records))
+	}
+	if records[0].Table.Name != "order_items" || records[0].Values[2] != "B" {
+		t.Errorf("Export(shipments) parent = %v, want order_items row with sku B", records[0])
+	}
+}
+
+func TestSQLiteExportReservedWords(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Schema: "main", Name: "order"}, Keys: []Key{{Values: []interface{}{7}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(records) != 2 || records[0].Table.Name != "users" {
+		t.Fatalf("Export(order) = %v, want users row then order row", records)
+	}
+}
+
+func TestSQLiteExportRowIDKeys(t *testing.T) {
+	path := createSQLiteFixture(t)
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(`
+		CREATE TABLE logs (user_id INTEGER REFERENCES users, msg TEXT);
+		INSERT INTO logs (user_id, msg) VALUES (1, 'login'), (1, 'login');
+	`); err != nil {
+		t.Fatalf("failed to create logs: %v", err)
+	}
+
+	exporter, err := NewExporter(context.Background(), "sqlite", path)
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "logs"}, Keys: []Key{{Values: []interface{}{2}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(records) != 2 || !reflect.DeepEqual(records[1].Columns, []string{"rowid", "user_id", "msg"}) {
+		t.Fatalf("Export(logs) = %v, want users row then logs row with its rowid", records)
+	}
+
+	// Identical rows are told apart by their rowid
+	opts := ExportOptions{IncludeChildren: true}
+	records, err = exporter.Export(context.Background(), Roots{Table: TableName{Name: "users"}, Keys: []Key{{Values: []interface{}{1}}}}, opts)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	var logs []interface{}
+	for _, record := range records {
+		if record.Table.Name == "logs" {
+			logs = append(logs, record.Values[0])
+		}
+	}
+	if !reflect.DeepEqual(logs, []interface{}{int64(1), int64(2)}) {
+		t.Errorf("Export(users) logs rowids = %v, want [1 2]", logs)
+	}
+}
+
+func TestSQLiteExportChildren(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	// The post's tags come in through the join table, but the author's other
+	// post does not, since children are only followed downwards
+	opts := ExportOptions{IncludeChildren: true}
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{100}}}}, opts)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, record := range records {
+		counts[record.Table.Name]++
+	}
+	expected := map[string]int{"users": 1, "posts": 1, "post_tags": 2, "tags": 2}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Export counts = %v, want %v", counts, expected)
+	}
+
+	// Tags are found after the join rows that reference them, but are
+	// sorted ahead of them
+	position := make(map[string]int)
+	for i, record := range records {
+		position[fmt.Sprintf("%s:%v", record.Table.Name, record.Values[0])] = i
+	}
+	if position["tags:1"] > position["post_tags:100"] || position["posts:100"] > position["post_tags:100"] {
+		t.Errorf("Export order = %v, want parents before post_tags", position)
+	}
+
+	reason := exporter.Reason(TableName{Schema: "main", Name: "tags"})
+	if reason != "parent of main.post_tags via main.post_tags(tag_id)" {
+		t.Errorf("Reason(tags) = %q", reason)
+	}
+
+	// Filters and depth limits prune the traversal
+	opts = ExportOptions{IncludeChildren: true, ExcludeTables: []string{"tags"}, MaxChildDepth: 1}
+	records, err = exporter.Export(context.Background(), Roots{Table: TableName{Name: "users"}, Keys: []Key{{Values: []interface{}{1}}}}, opts)
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	counts = make(map[string]int)
+	for _, record := range records {
+		counts[record.Table.Name]++
+	}
+	expected = map[string]int{"users": 1, "posts": 2, "order": 1}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Export counts with filters = %v, want %v", counts, expected)
+	}
+
+	// The row budget stops runaway exports
+	opts = ExportOptions{IncludeChildren: true, MaxRows: 3}
+	if _, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "users"}, Keys: []Key{{Values: []interface{}{1}}}}, opts); err == nil {
+		t.Error("Export beyond MaxRows should fail")
+	}
+}
+
+func TestSQLiteExportRoots(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	// Both posts share one author, which is exported once and before them
+	roots := Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{101}}}, Where: "title LIKE '%Post'"}
+	records, err := exporter.Export(context.Background(), roots, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	var order []string
+	for _, record := range records {
+		order = append(order, fmt.Sprintf("%s:%v", record.Table.Name, record.Values[0]))
+	}
+	expected := []string{"users:1", "posts:101", "posts:100"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Export order = %v, want %v", order, expected)
+	}
+
+	// A limit picks the first rows in primary key order
+	roots = Roots{Table: TableName{Name: "tags"}, Limit: 2}
+	records, err = exporter.Export(context.Background(), roots, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(records) != 2 || records[1].Values[0] != int64(2) {
+		t.Errorf("Export with limit = %v, want tags 1 and 2", records)
+	}
+}
+
+func TestSQLiteArchiveRestore(t *testing.T) {
+	conn := &Connection{Type: "sqlite", Path: createSQLiteFixture(t)}
+	archiveFile := filepath.Join(t.TempDir(), "archive"+conn.ArchiveExtension())
+
+	if err := Archive(conn, archiveFile, false); err != nil {
+		t.Fatalf("Archive failed: %v", err)
+	}
+
+	// Names with URI characters restore into the file they name
+	restored := &Connection{Type: "sqlite", Path: filepath.Join(t.TempDir(), "restored?1 #.db")}
+	if err := Restore(restored, archiveFile, false); err != nil {
+		t.Fatalf("Restore failed: %v", err)
+	}
+	if _, err := os.Stat(restored.Path); err != nil {
+		t.Fatalf("restored file missing: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", sqliteURI(restored.Path, "ro"))
+	if err != nil {
+		t.Fatalf("failed to open restored database: %v", err)
+	}
+	defer db.Close()
+
+	var title string
+	if err := db.QueryRow("SELECT title FROM posts WHERE id = 100").Scan(&title); err != nil {
+		t.Fatalf("failed to query restored database: %v", err)
+	}
+	if title != "Test Post" {
+		t.Errorf("restored title = %q, want %q", title, "Test Post")
+	}
+}
+
+func TestSQLiteArchiveMissingFile(t *testing.T) {
+	conn := &Connection{Type: "sqlite", Path: filepath.Join(t.TempDir(), "missing.db")}
+	if err := Archive(conn, filepath.Join(t.TempDir(), "archive.db"), false); err == nil {
+		t.Error("Archive of a missing database succeeded, want an error")
+	}
+	if _, err := os.Stat(conn.Path); !os.IsNotExist(err) {
+		t.Errorf("Archive created %s", conn.Path)
+	}
+}
+
+func TestSQLiteLiteralRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "types.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`
+		CREATE TABLE blobs (id INTEGER PRIMARY KEY, data BLOB, note TEXT, ratio REAL);
+		INSERT INTO blobs VALUES (1, X'00ff5c27', 'it''s a \ backslash', 0.1);
+	`)
+	if err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+
+	exporter, err := NewExporter(context.Background(), "sqlite", path)
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "blobs"}, Keys: []Key{{Values: []interface{}{1}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+
+	var script bytes.Buffer
+	if err := GenerateSQL(records, sqliteDialect{}.dialect(), nil, &script); err != nil {
+		t.Fatalf("GenerateSQL failed: %v", err)
+	}
+	if _, err := db.Exec("DELETE FROM blobs; " + script.String()); err != nil {
+		t.Fatalf("re-import failed: %v\n%s", err, script.String())
+	}
+
+	var data []byte
+	var note string
+	var ratio float64
+	if err := db.QueryRow("SELECT data, note, ratio FROM blobs WHERE id = 1").Scan(&data, &note, &ratio); err != nil {
+		t.Fatalf("failed to read row: %v", err)
+	}
+	if !bytes.Equal(data, []byte{0x00, 0xff, '\\', '\''}) || note != `it's a \ backslash` || ratio != 0.1 {
+		t.Errorf("row after round trip = %x, %q, %v", data, note, ratio)
+	}
+}
+
+func TestSQLiteExportCycle(t *testing.T) {
+	schema := `
+		CREATE TABLE teams (id INTEGER PRIMARY KEY, captain_id INTEGER REFERENCES players (id));
+		CREATE TABLE players (id INTEGER PRIMARY KEY, team_id INTEGER REFERENCES teams (id));
+	`
+	path := filepath.Join(t.TempDir(), "league.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	if _, err := db.Exec(schema + "INSERT INTO teams VALUES (1, 10); INSERT INTO players VALUES (10, 1);"); err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+
+	exporter, err := NewExporter(context.Background(), "sqlite", path)
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "teams"}, Keys: []Key{{Values: []interface{}{1}}}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if len(records) != 2 || records[0].Table.Name != "teams" || records[1].Table.Name != "players" {
+		t.Fatalf("Export returned %v, want [teams, players]", records)
+	}
+
+	steps := exporter.LoadSteps()
+	if steps.DeferConstraints || len(steps.Fixups) != 1 || !reflect.DeepEqual(steps.Fixups[0].Columns, []string{"captain_id"}) {
+		t.Fatalf("LoadSteps() = %+v, want one fixup of teams.captain_id", steps)
+	}
+
+	var script bytes.Buffer
+	writer, err := NewRecordWriter(OutputOptions{Format: FormatSQLInsert, Dialect: sqliteDialect{}.dialect(), Writer: &script, Steps: steps})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	if err := WriteRecords(writer, records); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+
+	// Load into an empty database that enforces foreign keys
+	target, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "target.db"))
+	if err != nil {
+		t.Fatalf("failed to open target: %v", err)
+	}
+	defer target.Close()
+	if _, err := target.Exec("PRAGMA foreign_keys = ON;" + schema + script.String()); err != nil {
+		t.Fatalf("loading export failed: %v\n%s", err, script.String())
+	}
+
+	var captainID int
+	if err := target.QueryRow("SELECT captain_id FROM teams WHERE id = 1").Scan(&captainID); err != nil {
+		t.Fatalf("failed to read team: %v", err)
+	}
+	if captainID != 10 {
+		t.Errorf("captain_id = %d, want 10", captainID)
+	}
+}
+
+// tableWriter is a RecordWriter that notes the table of each record
+type tableWriter struct {
+	tables []string
+	closed bool
+}
+
+func (w *tableWriter) WriteRecord(record Record) error {
+	w.tables = append(w.tables, record.Table.Name)
+	return nil
+}
+
+func (w *tableWriter) Close() error { w.closed = true; return nil }
+func (w *tableWriter) Abort() error { return nil }
+
+func TestSQLiteExportTo(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	roots := Roots{Table: TableName{Name: "posts"}, Where: "1 = 1"}
+	w := &tableWriter{}
+	if err := exporter.ExportTo(context.Background(), roots, ExportOptions{}, w); err != nil {
+		t.Fatalf("ExportTo failed: %v", err)
+	}
+	if !reflect.DeepEqual(w.tables, []string{"users", "posts", "posts"}) || w.closed {
+		t.Errorf("ExportTo wrote %v (closed %v), want [users posts posts] without closing", w.tables, w.closed)
+	}
+	posts := TableName{Schema: "main", Name: "posts"}
+	if !reflect.DeepEqual(exporter.Tables(), []TableName{{Schema: "main", Name: "users"}, posts}) || exporter.Rows(posts) != 2 {
+		t.Errorf("Tables() = %v with %d posts, want users and 2 posts", exporter.Tables(), exporter.Rows(posts))
+	}
+
+	// Both posts wait for their author, which is one more than allowed
+	if err := exporter.ExportTo(context.Background(), roots, ExportOptions{MaxBufferedRows: 1}, &tableWriter{}); err == nil {
+		t.Error("ExportTo beyond MaxBufferedRows should fail")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := exporter.ExportTo(ctx, roots, ExportOptions{}, &tableWriter{}); err == nil {
+		t.Error("ExportTo with a cancelled context should fail")
+	}
+}
+
+func TestSQLiteExportWithSchema(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	records, err := exporter.Export(context.Background(), Roots{Table: TableName{Name: "posts"}, Keys: []Key{{Values: []interface{}{100}}}}, ExportOptions{IncludeChildren: true})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if err := exporter.LoadSchema(); err != nil {
+		t.Fatalf("LoadSchema failed: %v", err)
+	}
+
+	var script bytes.Buffer
+	writer, err := NewRecordWriter(OutputOptions{Format: FormatSQLInsert, Dialect: sqliteDialect{}.dialect(), Writer: &script, Steps: exporter.LoadSteps()})
+	if err != nil {
+		t.Fatalf("NewRecordWriter failed: %v", err)
+	}
+	if err := WriteRecords(writer, records); err != nil {
+		t.Fatalf("WriteRecords failed: %v", err)
+	}
+
+	// The script loads into an empty database, and again into the same one
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "empty.db"))
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	defer db.Close()
+	for i := 0; i < 2; i++ {
+		if _, err := db.Exec("PRAGMA foreign_keys = ON; " + script.String()); err != nil {
+			t.Fatalf("loading the export failed: %v\n%s", err, script.String())
+		}
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM post_tags JOIN tags ON tags.id = post_tags.tag_id").Scan(&count); err != nil {
+		t.Fatalf("failed to query loaded database: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("loaded %d post tags, want 2", count)
+	}
+}
+
+func TestSQLitePlan(t *testing.T) {
+	exporter, err := NewExporter(context.Background(), "sqlite", createSQLiteFixture(t))
+	if err != nil {
+		t.Fatalf("NewExporter failed: %v", err)
+	}
+	defer exporter.Close()
+
+	roots := Roots{Table: TableName{Name: "posts"}, Where: "title LIKE '%Post'"}
+	plan, err := exporter.Plan(context.Background(), roots, ExportOptions{IncludeChildren: true, ExcludeTables: []string{"tags"}})
+	if err != nil {
+		t.Fatalf("Plan failed: %v", err)
+	}
+
+	counts := make(map[string]int64)
+	for _, table := range plan.Tables {
+		counts[table.Table.Name] = table.Rows
+	}
+	expected := map[string]int64{"posts": 2, "users": 1, "post_tags": 3}
+	if !reflect.DeepEqual(counts, expected) {
+		t.Errorf("Plan tables = %v, want %v", counts, expected)
+	}
+	if len(plan.Edges) != 2 || len(plan.Skipped) != 1 || plan.Skipped[0].ForeignTable.Name != "tags" {
+		t.Errorf("Plan edges = %v, skipped %v, want 2 edges and the tags key skipped", plan.Edges, plan.Skipped)
+	}
+
+	// The row graph of an export links each post to its author
+	records, err := exporter.Export(context.Background(), roots, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	g, err := exporter.RowGraph(records)
+	if err != nil {
+		t.Fatalf("RowGraph failed: %v", err)
+	}
+	if len(g.Nodes) != 3 || len(g.Edges) != 2 || g.Nodes[g.Edges[0].To].Label != "main.users 1" {
+		t.Errorf("RowGraph() = %+v, want 2 posts referencing main.users 1", g)
+	}
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// LoadSteps holds what loading exported records needs besides inserting
+// them in order: the tables' DDL if requested, deferred constraint checks or
+// foreign keys set after the inserts to get through reference cycles, and
+// sequences to advance past the imported IDs
+type LoadSteps struct {
+	Schema           []string   // Statements creating the tables, run before the inserts
+	DeferConstraints bool       // Cycles go through deferrable constraints
+	Fixups           []Fixup    // Foreign keys inserted as NULL and set afterwards
+	Sequences        []Sequence // Sequences owned by the exported tables
+}
+
+// Fixup sets foreign key columns of a record once all records are inserted.
+// The record is inserted with those columns NULL to break a reference cycle.
+type Fixup struct {
+	Record     Record
+	KeyColumns []string // Primary key identifying the record
+	Columns    []string // Foreign key columns set afterwards
+}
+
+// Sequence is a sequence behind a table's serial or identity column
+type Sequence struct {
+	Name   TableName
+	Table  TableName
+	Column string
+}
+
+// sequenceDialect is implemented by dialects whose tables own sequences that
+// must be advanced after rows are inserted with explicit IDs
+type sequenceDialect interface {
+	OwnedSequences(db *sql.DB, table TableName) ([]Sequence, error)
+	SetSequenceStatement(seq Sequence) string
+}
+
+// insertValues returns the masked values to insert for a record, with the
+// columns of its fixups NULL
+func (s *LoadSteps) insertValues(record Record, masker *Masker) []interface{} {
+	values := masker.Values(record)
+	if s == nil {
+		return values
+	}
+
+	var nulled []int
+	for _, fixup := range s.Fixups {
+		if fixup.matches(record) {
+			nulled = append(nulled, columnIndexes(record, fixup.Columns)...)
+		}
+	}
+	if len(nulled) == 0 {
+		return values
+	}
+
+	// Copy, since the masker may return the record's own values
+	values = append([]interface{}(nil), values...)
+	for _, i := range nulled {
+		values[i] = nil
+	}
+	return values
+}
+
+// matches reports whether record is the fixup's record
+func (f Fixup) matches(record Record) bool {
+	if record.Table != f.Record.Table {
+		return false
+	}
+	key, ok := record.columnValues(f.KeyColumns)
+	fixupKey, _ := f.Record.columnValues(f.KeyColumns)
+	return ok && keyID(key) == keyID(fixupKey)
+}
+
+// indexes returns the positions in the record of the fixup's columns
+// followed by its key columns, the order of updateStatement's values
+func (f Fixup) indexes() []int {
+	return columnIndexes(f.Record, append(append([]string{}, f.Columns...), f.KeyColumns...))
+}
+
+// updateStatement sets the fixup's columns of its record. values holds one
+// literal or placeholder per column of indexes.
+func (f Fixup) updateStatement(d identifierQuoter, values []string) string {
+	set := make([]string, len(f.Columns))
+	for i, column := range f.Columns {
+		set[i] = d.QuoteIdentifier(column) + " = " + values[i]
+	}
+
+	where := make([]string, len(f.KeyColumns))
+	for i, column := range f.KeyColumns {
+		where[i] = d.QuoteIdentifier(column) + " = " + values[len(f.Columns)+i]
+	}
+
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s;\n",
+		quoteTable(d, f.Record.Table), strings.Join(set, ", "), strings.Join(where, " AND "))
+}
+
+// writeSQLSteps writes the statements that finish loading the records:
+// fixup updates, then sequence resets
+func writeSQLSteps(opts OutputOptions) {
+	if opts.Steps == nil {
+		return
+	}
+
+	for _, fixup := range opts.Steps.Fixups {
+		masked := opts.Masker.Values(fixup.Record)
+		indexes := fixup.indexes()
+		values := make([]string, len(indexes))
+		for i, j := range indexes {
+			values[i] = opts.Dialect.inserts.Literal(masked[j], fixup.Record.columnType(j))
+		}
+		fmt.Fprintf(opts.Writer, "%s\n", fixup.updateStatement(opts.Dialect.queries, values))
+	}
+
+	if sequences := opts.Dialect.sequences; sequences != nil {
+		for _, seq := range opts.Steps.Sequences {
+			fmt.Fprintf(opts.Writer, "%s\n", sequences.SetSequenceStatement(seq))
+		}
+	}
+}
+
+// columnIndexes returns the positions of columns in a record, skipping
+// columns the record does not have
+func columnIndexes(record Record, columns []string) []int {
+	var indexes []int
+	for _, column := range columns {
+		for i, recordColumn := range record.Columns {
+			if recordColumn == column {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes
+}
@@ -0,0 +1,101 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+)
+
+// TableName is a schema-qualified table name. In MySQL the schema is the
+// database; in SQLite it is the attached database name, usually "main".
+type TableName struct {
+	Schema string
+	Name   string
+}
+
+// ParseTableName parses "table" or "schema.table". Either part may be
+// double-quoted to keep dots or mixed case, e.g. "Reports"."2024.q1".
+func ParseTableName(s string) (TableName, error) {
+	parts, err := splitQualifiedName(s)
+	if err != nil {
+		return TableName{}, err
+	}
+
+	switch len(parts) {
+	case 1:
+		return TableName{Name: parts[0]}, nil
+	case 2:
+		return TableName{Schema: parts[0], Name: parts[1]}, nil
+	default:
+		return TableName{}, fmt.Errorf("invalid table name %q (expected table or schema.table)", s)
+	}
+}
+
+// String returns the table as schema.table, or just the name without a schema
+func (t TableName) String() string {
+	if t.Schema == "" {
+		return t.Name
+	}
+	return t.Schema + "." + t.Name
+}
+
+// identifierQuoter quotes the names in SQL statements
+type identifierQuoter interface {
+	QuoteIdentifier(name string) string
+}
+
+// quoteTable quotes both parts of a table name for use in SQL
+func quoteTable(d identifierQuoter, t TableName) string {
+	if t.Schema == "" {
+		return d.QuoteIdentifier(t.Name)
+	}
+	return d.QuoteIdentifier(t.Schema) + "." + d.QuoteIdentifier(t.Name)
+}
+
+// quoteColumns quotes each column name for use in SQL
+func quoteColumns(d identifierQuoter, columns []string) []string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.QuoteIdentifier(column)
+	}
+	return quoted
+}
+
+// quoteWith wraps name in quote characters, doubling any embedded quotes
+func quoteWith(quote string, name string) string {
+	return quote + strings.ReplaceAll(name, quote, quote+quote) + quote
+}
+
+// splitQualifiedName splits a dotted name, honouring double-quoted parts
+func splitQualifiedName(s string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	inQuotes := false
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' && inQuotes && i+1 < len(s) && s[i+1] == '"':
+			current.WriteByte('"')
+			i++
+		case c == '"':
+			inQuotes = !inQuotes
+		case c == '.' && !inQuotes:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	parts = append(parts, current.String())
+
+	for _, part := range parts {
+		if part == "" {
+			return nil, fmt.Errorf("invalid table name %q", s)
+		}
+	}
+
+	return parts, nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestParseTableName(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected TableName
+		wantErr  bool
+	}{
+		{
+			name:     "unqualified",
+			input:    "users",
+			expected: TableName{Name: "users"},
+		},
+		{
+			name:     "schema qualified",
+			input:    "billing.invoices",
+			expected: TableName{Schema: "billing", Name: "invoices"},
+		},
+		{
+			name:     "quoted parts",
+			input:    `"Reports"."2024.q1"`,
+			expected: TableName{Schema: "Reports", Name: "2024.q1"},
+		},
+		{
+			name:    "too many parts",
+			input:   "a.b.c",
+			wantErr: true,
+		},
+		{
+			name:    "empty part",
+			input:   "billing.",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ParseTableName(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTableName(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if result != tt.expected {
+				t.Errorf("ParseTableName(%q) = %+v, want %+v", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestQuoteTable(t *testing.T) {
+	table := TableName{Schema: "public", Name: `we"ird`}
+
+	if result := quoteTable(postgresDialect{}, table); result != `"public"."we""ird"` {
+		t.Errorf("quoteTable(postgres) = %s", result)
+	}
+	if result := quoteTable(mysqlDialect{}, TableName{Name: "order"}); result != "`order`" {
+		t.Errorf("quoteTable(mysql) = %s", result)
+	}
+}
@@ -0,0 +1,320 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/env"
+	"github.com/joshpurvis/agentenv/internal/registry"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFile is the part of a docker-compose.yml that decides the
+// environment of a service's container
+type composeFile struct {
+	Services map[string]composeService `yaml:"services"`
+	Secrets  map[string]composeSecret  `yaml:"secrets"`
+}
+
+// composeService holds the fields that take several forms as raw nodes
+type composeService struct {
+	Environment yaml.Node   `yaml:"environment"`
+	EnvFile     yaml.Node   `yaml:"env_file"`
+	Secrets     []yaml.Node `yaml:"secrets"`
+	Volumes     []yaml.Node `yaml:"volumes"`
+}
+
+// composeSecret is a top-level secret, read from a file or the host's
+// environment
+type composeSecret struct {
+	File        string `yaml:"file"`
+	Environment string `yaml:"environment"`
+}
+
+// secretsDir is where compose mounts a service's secrets
+const secretsDir = "/run/secrets/"
+
+// ServiceEnvironment returns the environment a service's container runs
+// with in an agent's worktree: the service's env_file entries, overridden by
+// its environment in the compose file, overridden in turn by the rendered
+// environment from .agentenv.yml that the agent's override file sets.
+// Compose ${VAR} interpolation uses the host's environment and the .env file
+// next to the compose file. A KEY_FILE variable that points at a compose
+// secret or a bind-mounted file sets KEY to the file's contents, as the
+// official database images do.
+func ServiceEnvironment(cfg *config.Config, agent *registry.Agent, serviceName string) (map[string]string, error) {
+	result := make(map[string]string)
+
+	var compose composeFile
+	var service composeService
+	dir := agent.WorktreePath
+	if cfg.Docker.ComposeFile != "" {
+		composePath := filepath.Join(agent.WorktreePath, cfg.Docker.ComposeFile)
+		dir = filepath.Dir(composePath)
+
+		data, err := os.ReadFile(composePath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read compose file: %w", err)
+		}
+		if err == nil {
+			if err := yaml.Unmarshal(data, &compose); err != nil {
+				return nil, fmt.Errorf("failed to parse compose file: %w", err)
+			}
+			service = compose.Services[serviceName]
+		}
+	}
+
+	vars := interpolationVars(dir)
+	if err := loadEnvFiles(result, service.EnvFile, dir, vars); err != nil {
+		return nil, err
+	}
+	if err := loadEnvironment(result, service.Environment, vars); err != nil {
+		return nil, err
+	}
+	for key, value := range cfg.Docker.Services[serviceName].Environment {
+		result[key] = replaceTemplateVars(value, agent)
+	}
+
+	for key, path := range result {
+		name := strings.TrimSuffix(key, "_FILE")
+		if name == key || result[name] != "" {
+			continue
+		}
+		value, err := readSecretFile(path, &compose, &service, dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", key, err)
+		}
+		result[name] = value
+	}
+
+	return result, nil
+}
+
+// interpolationVars returns the variables compose interpolates: the .env
+// file next to the compose file, overridden by the host's environment
+func interpolationVars(dir string) map[string]string {
+	vars, err := env.ParseEnvFile(filepath.Join(dir, ".env"))
+	if err != nil {
+		vars = make(map[string]string)
+	}
+	for _, variable := range os.Environ() {
+		if key, value, ok := strings.Cut(variable, "="); ok {
+			vars[key] = value
+		}
+	}
+	return vars
+}
+
+// loadEnvFiles reads a service's env_file entries, which may be a path, a
+// list of paths or a list of {path, required} mappings
+func loadEnvFiles(result map[string]string, node yaml.Node, dir string, vars map[string]string) error {
+	type envFile struct {
+		Path     string `yaml:"path"`
+		Required *bool  `yaml:"required"`
+	}
+
+	var files []envFile
+	switch node.Kind {
+	case 0:
+		return nil
+	case yaml.ScalarNode:
+		files = append(files, envFile{Path: node.Value})
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind == yaml.ScalarNode {
+				files = append(files, envFile{Path: item.Value})
+				continue
+			}
+			var file envFile
+			if err := item.Decode(&file); err != nil {
+				return fmt.Errorf("failed to parse env_file: %w", err)
+			}
+			files = append(files, file)
+		}
+	default:
+		return fmt.Errorf("failed to parse env_file: unexpected %s", node.Tag)
+	}
+
+	for _, file := range files {
+		path := resolvePath(dir, interpolate(file.Path, vars))
+
+		values, err := env.ParseEnvFile(path)
+		if err != nil {
+			if _, statErr := os.Stat(path); os.IsNotExist(statErr) && file.Required != nil && !*file.Required {
+				continue
+			}
+			return fmt.Errorf("failed to load env_file %s: %w", file.Path, err)
+		}
+		for key, value := range values {
+			result[key] = value
+		}
+	}
+	return nil
+}
+
+// loadEnvironment reads a service's environment, in either the mapping or
+// the KEY=VALUE list form. A variable without a value is taken from the
+// host, as compose does.
+func loadEnvironment(result map[string]string, node yaml.Node, vars map[string]string) error {
+	set := func(key string, value *string) {
+		if value != nil {
+			result[key] = interpolate(*value, vars)
+		} else if hostValue, ok := vars[key]; ok {
+			result[key] = hostValue
+		}
+	}
+
+	switch node.Kind {
+	case 0:
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if value.Tag == "!!null" {
+				set(key, nil)
+			} else {
+				set(key, &value.Value)
+			}
+		}
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			key, value, ok := strings.Cut(item.Value, "=")
+			if ok {
+				set(key, &value)
+			} else {
+				set(key, nil)
+			}
+		}
+	default:
+		return fmt.Errorf("failed to parse environment: unexpected %s", node.Tag)
+	}
+	return nil
+}
+
+// interpolationPattern matches $$, ${VAR}, ${VAR:-default}, ${VAR-default}
+// and $VAR
+var interpolationPattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?-)([^}]*))?\}|\$([A-Za-z_][A-Za-z0-9_]*)`)
+
+// interpolate replaces compose variable references in a value
+func interpolate(value string, vars map[string]string) string {
+	return interpolationPattern.ReplaceAllStringFunc(value, func(match string) string {
+		if match == "$$" {
+			return "$"
+		}
+		groups := interpolationPattern.FindStringSubmatch(match)
+		if groups[4] != "" {
+			return vars[groups[4]]
+		}
+
+		current, ok := vars[groups[1]]
+		switch groups[2] {
+		case ":-":
+			if current == "" {
+				return groups[3]
+			}
+		case "-":
+			if !ok {
+				return groups[3]
+			}
+		}
+		return current
+	})
+}
+
+// readSecretFile reads a file the container sees at path, by mapping it to
+// a compose secret or a bind mount on the host, with the trailing newline
+// removed
+func readSecretFile(path string, compose *composeFile, service *composeService, dir string) (string, error) {
+	hostPath, err := hostFile(path, compose, service, dir)
+	if err != nil {
+		return "", err
+	}
+	if hostPath == "" {
+		return "", fmt.Errorf("%s is not a compose secret or a bind-mounted file", path)
+	}
+
+	data, err := os.ReadFile(hostPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// hostFile maps a path inside the container to the host, returning "" if
+// the path is not one the compose file provides
+func hostFile(path string, compose *composeFile, service *composeService, dir string) (string, error) {
+	hostPath, err := secretFile(path, compose, service, dir)
+	if err != nil || hostPath != "" {
+		return hostPath, err
+	}
+	return mountedFile(path, service, dir), nil
+}
+
+// secretFile maps a path to the file of the compose secret mounted there,
+// returning "" if no secret is. Secrets are mounted at
+// /run/secrets/<target>, which defaults to the secret's name.
+func secretFile(path string, compose *composeFile, service *composeService, dir string) (string, error) {
+	for _, node := range service.Secrets {
+		source, target := node.Value, node.Value
+		if node.Kind == yaml.MappingNode {
+			var secret struct {
+				Source string `yaml:"source"`
+				Target string `yaml:"target"`
+			}
+			if err := node.Decode(&secret); err != nil {
+				return "", fmt.Errorf("failed to parse secrets: %w", err)
+			}
+			source, target = secret.Source, secret.Target
+			if target == "" {
+				target = source
+			}
+		}
+		if !strings.HasPrefix(target, "/") {
+			target = secretsDir + target
+		}
+		if target != path {
+			continue
+		}
+
+		secret, ok := compose.Secrets[source]
+		switch {
+		case ok && secret.File != "":
+			return resolvePath(dir, secret.File), nil
+		case ok && secret.Environment != "":
+			return "", fmt.Errorf("secret %s comes from the environment, not a file", source)
+		default:
+			return "", fmt.Errorf("secret %s is not defined", source)
+		}
+	}
+	return "", nil
+}
+
+// mountedFile maps a path to the host through the service's short-form bind
+// mounts (./host/dir:/container/dir[:ro]), returning "" if none covers it
+func mountedFile(path string, service *composeService, dir string) string {
+	for _, node := range service.Volumes {
+		parts := strings.Split(node.Value, ":")
+		if node.Kind != yaml.ScalarNode || len(parts) < 2 {
+			continue
+		}
+		source, target := parts[0], parts[1]
+		if !strings.HasPrefix(source, ".") && !strings.HasPrefix(source, "/") {
+			continue
+		}
+		if path == target || strings.HasPrefix(path, strings.TrimSuffix(target, "/")+"/") {
+			return resolvePath(dir, filepath.Join(source, strings.TrimPrefix(path, target)))
+		}
+	}
+	return ""
+}
+
+// resolvePath resolves a path in the compose file against its directory
+func resolvePath(dir, p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(dir, p)
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/registry"
+)
+
+// writeFiles writes files relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestServiceEnvironment(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		override map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "env_file, list environment and override",
+			files: map[string]string{
+				"docker-compose.yml": `services:
+  db:
+    env_file: [db.env]
+    environment:
+      - POSTGRES_USER=app
+      - POSTGRES_DB=shop
+`,
+				"db.env": "POSTGRES_USER=ignored\nPOSTGRES_PASSWORD=fromfile\n",
+			},
+			override: map[string]string{"POSTGRES_DB": "shop_agent{id}"},
+			expected: map[string]string{"POSTGRES_USER": "app", "POSTGRES_PASSWORD": "fromfile", "POSTGRES_DB": "shop_agent3"},
+		},
+		{
+			name: "interpolation from .env",
+			files: map[string]string{
+				"docker-compose.yml": `services:
+  db:
+    environment:
+      MYSQL_USER: ${DB_USER}
+      MYSQL_PASSWORD: ${DB_PASSWORD:-devpw}
+      MYSQL_DATABASE: $$literal
+`,
+				".env": "DB_USER=shop\n",
+			},
+			expected: map[string]string{"MYSQL_USER": "shop", "MYSQL_PASSWORD": "devpw", "MYSQL_DATABASE": "$literal"},
+		},
+		{
+			name: "file secrets",
+			files: map[string]string{
+				"docker-compose.yml": `services:
+  db:
+    environment:
+      POSTGRES_PASSWORD_FILE: /run/secrets/db_password
+      POSTGRES_USER_FILE: /config/user
+    secrets: [db_password]
+    volumes:
+      - ./config:/config:ro
+secrets:
+  db_password:
+    file: ./secrets/password.txt
+`,
+				"secrets/password.txt": "s3cret\n",
+				"config/user":          "app",
+			},
+			expected: map[string]string{"POSTGRES_PASSWORD": "s3cret", "POSTGRES_USER": "app"},
+		},
+		{
+			name:     "no compose file",
+			override: map[string]string{"POSTGRES_PASSWORD": "pw"},
+			expected: map[string]string{"POSTGRES_PASSWORD": "pw"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			cfg := &config.Config{
+				Docker: config.DockerConfig{
+					ComposeFile: "docker-compose.yml",
+					Services: map[string]config.ServiceConfig{
+						"db": {Environment: tt.override},
+					},
+				},
+			}
+			agent := &registry.Agent{WorktreePath: dir, PortSlot: 3}
+
+			env, err := ServiceEnvironment(cfg, agent, "db")
+			if err != nil {
+				t.Fatalf("ServiceEnvironment failed: %v", err)
+			}
+			for key, want := range tt.expected {
+				if env[key] != want {
+					t.Errorf("%s = %q, want %q", key, env[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestServiceEnvironmentMissingSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"docker-compose.yml": `services:
+  db:
+    environment:
+      POSTGRES_PASSWORD_FILE: /run/secrets/db_password
+`,
+	})
+
+	cfg := &config.Config{Docker: config.DockerConfig{ComposeFile: "docker-compose.yml"}}
+	agent := &registry.Agent{WorktreePath: dir}
+	if _, err := ServiceEnvironment(cfg, agent, "db"); err == nil {
+		t.Error("ServiceEnvironment succeeded with an unmapped secret file, want an error")
+	}
+}
@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Service is one Docker Compose service of an agent's environment
+type Service struct {
+	Dir          string // Worktree holding the compose files
+	ComposeFile  string
+	OverrideFile string
+	Name         string
+}
+
+// Exec runs a command inside the service's running container, with extra
+// KEY=VALUE environment variables, and returns its combined output
+func (s Service) Exec(env []string, args ...string) ([]byte, error) {
+	cmd := s.command(true, env, args)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("docker-compose exec %s %s failed: %w\nOutput: %s", s.Name, args[0], err, output)
+	}
+	return output, nil
+}
+
+// Attach runs an interactive command inside the service's running
+// container, connected to the terminal
+func (s Service) Attach(env []string, args ...string) error {
+	cmd := s.command(false, env, args)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker-compose exec %s %s failed: %w", s.Name, args[0], err)
+	}
+	return nil
+}
+
+// command builds the docker-compose exec command, without a TTY when the
+// output is captured
+func (s Service) command(noTTY bool, env []string, args []string) *exec.Cmd {
+	cmdArgs := []string{"-f", s.ComposeFile, "-f", s.OverrideFile, "exec"}
+	if noTTY {
+		cmdArgs = append(cmdArgs, "-T")
+	}
+	for _, variable := range env {
+		cmdArgs = append(cmdArgs, "-e", variable)
+	}
+	cmdArgs = append(append(cmdArgs, s.Name), args...)
+
+	cmd := exec.Command("docker-compose", cmdArgs...)
+	cmd.Dir = s.Dir
+	return cmd
+}
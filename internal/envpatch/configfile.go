@@ -0,0 +1,195 @@
+package envpatch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+// configDocument is a parsed config file that can be edited by key path
+// while keeping the rest of its text
+type configDocument interface {
+	Set(path []string, value string) error
+	Unset(path []string) error
+	String() string
+}
+
+// literalPattern matches numbers and booleans, which typed formats write
+// without quotes
+var literalPattern = regexp.MustCompile(`^(true|false|-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?)$`)
+
+// PatchConfigFiles patches the structured config files in the worktree. A
+// file the branch doesn't track is copied from the main repo first.
+func PatchConfigFiles(cfg *config.Config, worktree Worktree) error {
+	mainRepoPath, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	for _, configFile := range cfg.ConfigFiles {
+		worktreeFilePath := filepath.Join(worktree.Path, configFile.Path)
+
+		content, err := os.ReadFile(worktreeFilePath)
+		if os.IsNotExist(err) {
+			content, err = os.ReadFile(filepath.Join(mainRepoPath, configFile.Path))
+			if os.IsNotExist(err) {
+				fmt.Printf("Warning: config file %s does not exist in the worktree or main repo, skipping\n", configFile.Path)
+				continue
+			}
+			if err == nil {
+				err = os.MkdirAll(filepath.Dir(worktreeFilePath), 0755)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read config file %s: %w", configFile.Path, err)
+		}
+
+		patched, err := patchConfig(string(content), configFile, worktree.render)
+		if err != nil {
+			return fmt.Errorf("failed to patch config file %s: %w", configFile.Path, err)
+		}
+
+		if err := os.WriteFile(worktreeFilePath, []byte(patched), 0644); err != nil {
+			return fmt.Errorf("failed to write patched config file %s: %w", worktreeFilePath, err)
+		}
+	}
+
+	return nil
+}
+
+// patchConfig applies a config file's set and unset operations to its
+// contents. Keys are set in sorted order, then unset.
+func patchConfig(content string, configFile config.ConfigFile, render func(string) string) (string, error) {
+	format, err := configFormat(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	var doc configDocument
+	switch format {
+	case "yaml":
+		doc, err = parseYAMLDocument(content)
+	case "json":
+		doc, err = parseJSONDocument(content)
+	case "toml":
+		doc, err = parseTOMLDocument(content)
+	case "ini":
+		doc = parseINIDocument(content)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range sortedKeys(configFile.Set) {
+		path, err := splitKeyPath(key)
+		if err != nil {
+			return "", err
+		}
+		if err := doc.Set(path, render(configFile.Set[key])); err != nil {
+			return "", fmt.Errorf("failed to set %s: %w", key, err)
+		}
+	}
+	for _, key := range configFile.Unset {
+		path, err := splitKeyPath(key)
+		if err != nil {
+			return "", err
+		}
+		if err := doc.Unset(path); err != nil {
+			return "", fmt.Errorf("failed to unset %s: %w", key, err)
+		}
+	}
+
+	return doc.String(), nil
+}
+
+// configFormat returns a config file's format, from its format setting or
+// its extension
+func configFormat(configFile config.ConfigFile) (string, error) {
+	format := strings.ToLower(configFile.Format)
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(configFile.Path)) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		case ".json":
+			format = "json"
+		case ".toml":
+			format = "toml"
+		case ".ini", ".cfg", ".conf":
+			format = "ini"
+		default:
+			return "", fmt.Errorf("cannot tell the format of %s: set format to yaml, json, toml or ini", configFile.Path)
+		}
+	}
+
+	switch format {
+	case "yml":
+		return "yaml", nil
+	case "yaml", "json", "toml", "ini":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unsupported config file format: %q", configFile.Format)
+	}
+}
+
+// splitKeyPath splits a key path on dots. Segments holding dots are
+// double-quoted: Logging.LogLevel."Microsoft.AspNetCore".
+func splitKeyPath(key string) ([]string, error) {
+	var path []string
+	for rest := key; ; {
+		var segment string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.Index(rest[1:], `"`)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid key path %q: unterminated quote", key)
+			}
+			segment, rest = rest[1:end+1], rest[end+2:]
+			if rest != "" && !strings.HasPrefix(rest, ".") {
+				return nil, fmt.Errorf("invalid key path %q: expected a dot after a quoted key", key)
+			}
+		} else {
+			end := strings.Index(rest, ".")
+			if end < 0 {
+				end = len(rest)
+			}
+			segment, rest = rest[:end], rest[end:]
+		}
+
+		if segment == "" {
+			return nil, fmt.Errorf("invalid key path %q: empty key", key)
+		}
+		path = append(path, segment)
+
+		if rest == "" {
+			return path, nil
+		}
+		rest = rest[1:]
+	}
+}
+
+// isLiteral reports whether a value reads as a number or boolean
+func isLiteral(value string) bool {
+	return literalPattern.MatchString(value)
+}
+
+// insertLine inserts a line into content at pos, making sure the line
+// before it ends with a newline
+func insertLine(content string, pos int, line string) string {
+	if pos > 0 && content[pos-1] != '\n' {
+		line = "\n" + line
+	}
+	return content[:pos] + line + content[pos:]
+}
+
+// arrayIndex parses a key path segment that indexes an array
+func arrayIndex(segment string, length int) (int, error) {
+	index, err := strconv.Atoi(segment)
+	if err != nil || index < 0 || index >= length {
+		return 0, fmt.Errorf("%q is not an index of an array of %d", segment, length)
+	}
+	return index, nil
+}
@@ -0,0 +1,278 @@
+package envpatch
+
+import (
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+func TestPatchConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		content  string
+		set      map[string]string
+		unset    []string
+		expected string
+	}{
+		{
+			name: "yaml",
+			path: "config/local.yaml",
+			content: `# Local settings
+database:
+  host: localhost # dev only
+  port: 5432
+  name: "app"
+redis:
+  url: redis://localhost:6379
+`,
+			set: map[string]string{
+				"database.port": "{postgres.port}",
+				"database.name": "app_agent{id}",
+				"cache.ttl":     "60",
+			},
+			unset: []string{"redis"},
+			expected: `# Local settings
+database:
+  host: localhost # dev only
+  port: 5435
+  name: "app_agent3"
+cache:
+  ttl: 60
+`,
+		},
+		{
+			name: "yaml layout and documents",
+			path: "compose.override.yml",
+			content: `services:
+    web:
+        ports:
+        - "8000:8000"
+
+        environment:
+            PORT: &port 8000
+            SECRET:
+
+    # Background jobs
+    worker:
+        command: celery
+
+---
+services: {}
+`,
+			set: map[string]string{
+				"services.web.ports.0":              "{web.port}:8000",
+				"services.web.environment.PORT":     "{web.port}",
+				"services.web.environment.SECRET":   "dev-only",
+				"services.worker.environment.QUEUE": "agent{id}",
+			},
+			unset: []string{"services.web.ports"},
+			expected: `services:
+    web:
+
+        environment:
+            PORT: &port 8003
+            SECRET: dev-only
+
+    # Background jobs
+    worker:
+        command: celery
+        environment:
+            QUEUE: agent3
+
+---
+services: {}
+`,
+		},
+		{
+			name: "json",
+			path: "appsettings.Development.json",
+			content: `{
+  // Local overrides
+  "ConnectionStrings": {
+    "Default": "Host=localhost;Port=5432"
+  },
+  "Port": 8000,
+  "Logging": { "LogLevel": { "Default": "Debug", "Microsoft.AspNetCore": "Warning" } }
+}
+`,
+			set: map[string]string{
+				"ConnectionStrings.Default": "Host=localhost;Port={postgres.port}",
+				"Port":                      "{web.port}",
+				"Logging.LogLevel.\"Microsoft.AspNetCore\"": "Error",
+				"Agent.Name": "{name}",
+			},
+			unset: []string{"Logging.LogLevel.Default"},
+			expected: `{
+  // Local overrides
+  "ConnectionStrings": {
+    "Default": "Host=localhost;Port=5435"
+  },
+  "Port": 8003,
+  "Logging": { "LogLevel": { "Microsoft.AspNetCore": "Error" } },
+  "Agent": {
+    "Name": "agent3"
+  }
+}
+`,
+		},
+		{
+			name: "toml",
+			path: "settings.toml",
+			content: `title = "app" # the app
+
+[database]
+host = 'localhost'
+port = 5432
+
+[[workers]]
+port = 9000
+`,
+			set: map[string]string{
+				"database.port":  "{postgres.port}",
+				"database.host":  "127.0.0.1",
+				"database.name":  "app_agent{id}",
+				"debug":          "true",
+				"cache.redis.db": "3",
+			},
+			unset: []string{"title"},
+			expected: `debug = true
+
+[database]
+host = '127.0.0.1'
+port = 5435
+name = "app_agent3"
+
+[[workers]]
+port = 9000
+
+[cache.redis]
+db = 3
+`,
+		},
+		{
+			name: "toml array tables",
+			path: "pyproject.toml",
+			content: `[[servers]]
+port = 8000
+
+[[servers]]
+port = 8001
+
+[servers.tls]
+cert = "dev.pem"
+`,
+			set: map[string]string{
+				"servers.1.port":     "{web.port}",
+				"servers.1.tls.cert": "agent{id}.pem",
+				"servers.0.host":     "localhost",
+			},
+			expected: `[[servers]]
+port = 8000
+host = "localhost"
+
+[[servers]]
+port = 8003
+
+[servers.tls]
+cert = "agent3.pem"
+`,
+		},
+		{
+			name: "ini",
+			path: "worker.ini",
+			content: `; Worker settings
+[celery]
+broker_url = "redis://localhost:6379/0"
+concurrency=4
+
+[logging]
+level = INFO
+`,
+			set: map[string]string{
+				"celery.broker_url": "redis://localhost:{redis.port}/0",
+				"celery.queues":     "agent{id}",
+				"sentry.dsn":        "",
+			},
+			unset: []string{"logging.level"},
+			expected: `; Worker settings
+[celery]
+broker_url = "redis://localhost:6382/0"
+concurrency=4
+queues = agent3
+
+[logging]
+
+[sentry]
+dsn =
+`,
+		},
+	}
+
+	render := func(value string) string {
+		return replacePlaceholders(value, map[string]int{"postgres": 5435, "redis": 6382, "web": 8003}, 3, "agent3", "/work/agent3")
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configFile := config.ConfigFile{Path: tt.path, Set: tt.set, Unset: tt.unset}
+			got, err := patchConfig(tt.content, configFile, render)
+			if err != nil {
+				t.Fatalf("patchConfig failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("patchConfig() =\n%s\nwant\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPatchConfigErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		configFile config.ConfigFile
+		content    string
+	}{
+		{
+			name:       "unknown extension",
+			configFile: config.ConfigFile{Path: "settings.conf.local", Set: map[string]string{"a": "1"}},
+		},
+		{
+			name:       "value under a scalar",
+			configFile: config.ConfigFile{Path: "a.json", Set: map[string]string{"port.number": "1"}},
+			content:    `{"port": 8000}`,
+		},
+		{
+			name:       "replacing a table",
+			configFile: config.ConfigFile{Path: "a.yaml", Set: map[string]string{"database": "x"}},
+			content:    "database:\n  port: 1\n",
+		},
+		{
+			name:       "key added to a flow mapping",
+			configFile: config.ConfigFile{Path: "a.yaml", Set: map[string]string{"database.port": "1"}},
+			content:    "database: {host: localhost}\n",
+		},
+		{
+			name:       "array of tables without an index",
+			configFile: config.ConfigFile{Path: "a.toml", Set: map[string]string{"servers.port": "1"}},
+			content:    "[[servers]]\nport = 8000\n",
+		},
+		{
+			name:       "array of tables index out of range",
+			configFile: config.ConfigFile{Path: "a.toml", Set: map[string]string{"servers.1.port": "1"}},
+			content:    "[[servers]]\nport = 8000\n",
+		},
+		{
+			name:       "unterminated quote in key path",
+			configFile: config.ConfigFile{Path: "a.toml", Set: map[string]string{`"a.b`: "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := patchConfig(tt.content, tt.configFile, func(v string) string { return v }); err == nil {
+				t.Error("patchConfig succeeded, want an error")
+			}
+		})
+	}
+}
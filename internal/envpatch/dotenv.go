@@ -0,0 +1,205 @@
+package envpatch
+
+import (
+	"regexp"
+	"strings"
+)
+
+// assignmentPattern matches the start of a KEY=value line, with an optional
+// export prefix: the whole prefix up to the value, and the key
+var assignmentPattern = regexp.MustCompile(`^(\s*(?:export\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*=\s*)`)
+
+// plainValuePattern matches values that need no quotes
+var plainValuePattern = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,=?&-]*$`)
+
+// dotenvLine is one entry of a .env file: a comment, blank line or other
+// text kept verbatim, or a KEY=value assignment that can be rewritten
+// without touching its export prefix, quoting or inline comment
+type dotenvLine struct {
+	text   string // The original line(s), used while the entry is unchanged
+	key    string // Empty for lines that are not assignments
+	prefix string // Everything before the value, e.g. "export KEY="
+	quote  byte   // The value's quote character, or 0 when unquoted
+	suffix string // Whatever follows the value, e.g. " # comment"
+	eol    string // "\r" before the line's newline in Windows files
+}
+
+// dotenvFile is a parsed .env file that keeps everything it does not
+// change byte for byte
+type dotenvFile struct {
+	lines   []*dotenvLine
+	eol     string // "\r" for files with Windows line endings
+	trailer bool   // Whether the file ends with a newline
+}
+
+// parseDotenv parses the contents of a .env file
+func parseDotenv(content string) *dotenvFile {
+	f := &dotenvFile{trailer: strings.HasSuffix(content, "\n")}
+	if strings.Contains(content, "\r\n") {
+		f.eol = "\r"
+	}
+
+	rawLines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	if content == "" {
+		rawLines = nil
+	}
+
+	for i := 0; i < len(rawLines); i++ {
+		raw, eol := splitEOL(rawLines[i])
+		line := &dotenvLine{text: raw, eol: eol}
+		f.lines = append(f.lines, line)
+
+		match := assignmentPattern.FindStringSubmatch(raw)
+		if match == nil || strings.HasPrefix(strings.TrimSpace(raw), "#") {
+			continue
+		}
+		line.key, line.prefix = match[2], match[1]
+		rest := raw[len(match[1]):]
+
+		if rest != "" && (rest[0] == '"' || rest[0] == '\'') {
+			i = line.parseQuoted(rest, rawLines, i)
+			continue
+		}
+
+		// An unquoted value ends at an inline comment
+		value := rest
+		if index := strings.Index(rest, " #"); index >= 0 {
+			value = rest[:index]
+		}
+		line.suffix = rest[len(strings.TrimRight(value, " \t")):]
+	}
+
+	return f
+}
+
+// parseQuoted reads a quoted value, which may span lines up to the closing
+// quote, from rest, the text after the line's prefix. It returns the index
+// of the value's last line in rawLines.
+func (line *dotenvLine) parseQuoted(rest string, rawLines []string, i int) int {
+	line.quote = rest[0]
+	end := closingQuote(rest, line.quote)
+	for end < 0 && i+1 < len(rawLines) {
+		i++
+		next, nextEOL := splitEOL(rawLines[i])
+		line.text += line.eol + "\n" + next
+		rest += line.eol + "\n" + next
+		line.eol = nextEOL
+		end = closingQuote(rest, line.quote)
+	}
+	if end < 0 {
+		line.key = "" // Unterminated, so leave it alone
+		return i
+	}
+	line.suffix = rest[end+1:]
+	return i
+}
+
+// splitEOL separates the carriage return of a Windows line ending
+func splitEOL(raw string) (string, string) {
+	if strings.HasSuffix(raw, "\r") {
+		return strings.TrimSuffix(raw, "\r"), "\r"
+	}
+	return raw, ""
+}
+
+// closingQuote returns the index of the quote that closes a value starting
+// with one, skipping backslash escapes in double-quoted values, or -1
+func closingQuote(value string, quote byte) int {
+	for i := 1; i < len(value); i++ {
+		switch {
+		case value[i] == '\\' && quote == '"':
+			i++
+		case value[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// Has reports whether the file assigns key
+func (f *dotenvFile) Has(key string) bool {
+	for _, line := range f.lines {
+		if line.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Set replaces the value of every assignment of key, keeping its quoting
+// where the value allows, and appends an assignment if there is none
+func (f *dotenvFile) Set(key, value string) {
+	found := false
+	for _, line := range f.lines {
+		if line.key != key {
+			continue
+		}
+		found = true
+		line.text = line.prefix + quoteValue(value, line.quote) + line.suffix
+	}
+	if !found {
+		f.Append(key, value)
+	}
+}
+
+// Unset removes every assignment of key
+func (f *dotenvFile) Unset(key string) {
+	kept := f.lines[:0]
+	for _, line := range f.lines {
+		if line.key != key {
+			kept = append(kept, line)
+		}
+	}
+	f.lines = kept
+}
+
+// Append adds an assignment of key at the end of the file
+func (f *dotenvFile) Append(key, value string) {
+	prefix := key + "="
+	f.lines = append(f.lines, &dotenvLine{
+		text:   prefix + quoteValue(value, 0),
+		key:    key,
+		prefix: prefix,
+		quote:  quoteFor(value),
+		eol:    f.eol,
+	})
+	f.trailer = true
+}
+
+// String renders the file
+func (f *dotenvFile) String() string {
+	var b strings.Builder
+	for i, line := range f.lines {
+		b.WriteString(line.text)
+		if i < len(f.lines)-1 || f.trailer {
+			b.WriteString(line.eol + "\n")
+		}
+	}
+	return b.String()
+}
+
+// quoteValue renders a value in the given quote style, or in the simplest
+// style that keeps it intact when quote is 0 or cannot hold it
+func quoteValue(value string, quote byte) string {
+	if quote == 0 || (quote == '\'' && strings.ContainsRune(value, '\'')) {
+		quote = quoteFor(value)
+	}
+
+	switch quote {
+	case '"':
+		replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+		return `"` + replacer.Replace(value) + `"`
+	case '\'':
+		return "'" + value + "'"
+	default:
+		return value
+	}
+}
+
+// quoteFor returns the quote a new value needs
+func quoteFor(value string) byte {
+	if plainValuePattern.MatchString(value) {
+		return 0
+	}
+	return '"'
+}
@@ -0,0 +1,81 @@
+package envpatch
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+func TestApplyKeyPatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		envFile  config.EnvFile
+		expected string
+	}{
+		{
+			name:    "set keeps comments, order and quoting",
+			content: "# Database\nexport DB_HOST=localhost # local only\nDB_PORT='5432'\nDB_NAME=\"app\"\n",
+			envFile: config.EnvFile{Set: map[string]string{
+				"DB_PORT": "{postgres.port}",
+				"DB_NAME": "app_agent{id}",
+				"DB_HOST": "127.0.0.1",
+			}},
+			expected: "# Database\nexport DB_HOST=127.0.0.1 # local only\nDB_PORT='5435'\nDB_NAME=\"app_agent3\"\n",
+		},
+		{
+			name:     "set appends missing keys",
+			content:  "A=1",
+			envFile:  config.EnvFile{Set: map[string]string{"B": "two words"}},
+			expected: "A=1\nB=\"two words\"\n",
+		},
+		{
+			name:     "unset removes every assignment",
+			content:  "A=1\n# keep\nSECRET=x\nB=2\nSECRET=y\n",
+			envFile:  config.EnvFile{Unset: []string{"SECRET", "MISSING"}},
+			expected: "A=1\n# keep\nB=2\n",
+		},
+		{
+			name:    "append_if_missing leaves existing keys",
+			content: "A=1\n",
+			envFile: config.EnvFile{AppendIfMissing: map[string]string{
+				"A": "changed",
+				"C": "3",
+				"B": "{name}",
+			}},
+			expected: "A=1\nB=agent3\nC=3\n",
+		},
+		{
+			name:     "multi-line values and commented keys",
+			content:  "# A=old\nKEY=\"line one\nline two\"\nA=1\n",
+			envFile:  config.EnvFile{Set: map[string]string{"KEY": "single", "A": "2"}},
+			expected: "# A=old\nKEY=\"single\"\nA=2\n",
+		},
+		{
+			name:     "windows line endings",
+			content:  "A=1\r\nB=2\r\n",
+			envFile:  config.EnvFile{Set: map[string]string{"B": "3", "C": "4"}},
+			expected: "A=1\r\nB=3\r\nC=4\r\n",
+		},
+		{
+			name:     "single-quoted value that needs double quotes",
+			content:  "MSG='hi'\n",
+			envFile:  config.EnvFile{Set: map[string]string{"MSG": "it's"}},
+			expected: "MSG=\"it's\"\n",
+		},
+	}
+
+	render := func(value string) string {
+		return replacePlaceholders(value, map[string]int{"postgres": 5435}, 3, "agent3", "/work/agent3")
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := applyKeyPatches(tt.content, tt.envFile, render)
+			if got != tt.expected {
+				t.Errorf("applyKeyPatches() =\n%s\nwant\n%s", strings.ReplaceAll(got, "\r", `\r`), strings.ReplaceAll(tt.expected, "\r", `\r`))
+			}
+		})
+	}
+}
@@ -5,13 +5,14 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/joshpurvis/agentenv/internal/config"
 )
 
 // PatchEnvFiles patches all environment files according to the configuration
-func PatchEnvFiles(cfg *config.Config, worktreePath string, ports map[string]int, agentID int, agentName string) error {
+func PatchEnvFiles(cfg *config.Config, worktree Worktree) error {
 	// Get current directory (main repo root)
 	mainRepoPath, err := os.Getwd()
 	if err != nil {
@@ -22,61 +23,97 @@ func PatchEnvFiles(cfg *config.Config, worktreePath string, ports map[string]int
 		// Source path in main repo
 		mainEnvPath := filepath.Join(mainRepoPath, envFile.Path)
 		// Destination path in worktree
-		worktreeEnvPath := filepath.Join(worktreePath, envFile.Path)
+		worktreeEnvPath := filepath.Join(worktree.Path, envFile.Path)
 
 		// Copy env file from main repo to worktree if it exists
-		if _, err := os.Stat(mainEnvPath); err == nil {
-			// Create directory if needed
-			if err := os.MkdirAll(filepath.Dir(worktreeEnvPath), 0755); err != nil {
-				return fmt.Errorf("failed to create directory for %s: %w", worktreeEnvPath, err)
-			}
-
-			// Copy file
-			content, err := os.ReadFile(mainEnvPath)
-			if err != nil {
-				return fmt.Errorf("failed to read env file %s: %w", mainEnvPath, err)
-			}
-
-			if err := os.WriteFile(worktreeEnvPath, content, 0644); err != nil {
-				return fmt.Errorf("failed to copy env file to %s: %w", worktreeEnvPath, err)
-			}
-		} else {
+		if _, err := os.Stat(mainEnvPath); err != nil {
 			fmt.Printf("Warning: env file %s does not exist in main repo, skipping\n", mainEnvPath)
 			continue
 		}
-
-		// Read the copied file
-		content, err := os.ReadFile(worktreeEnvPath)
+		content, err := os.ReadFile(mainEnvPath)
 		if err != nil {
-			return fmt.Errorf("failed to read env file %s: %w", worktreeEnvPath, err)
+			return fmt.Errorf("failed to read env file %s: %w", mainEnvPath, err)
 		}
 
-		contentStr := string(content)
+		patched, err := patchEnvFile(string(content), envFile, worktree)
+		if err != nil {
+			return err
+		}
 
-		// Apply patches
-		for _, patch := range envFile.Patches {
-			pattern := patch.Pattern
-			replacement := patch.Replace
+		// Write the patched file
+		if err := os.MkdirAll(filepath.Dir(worktreeEnvPath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", worktreeEnvPath, err)
+		}
+		if err := os.WriteFile(worktreeEnvPath, []byte(patched), 0644); err != nil {
+			return fmt.Errorf("failed to write patched env file %s: %w", worktreeEnvPath, err)
+		}
+	}
 
-			// Replace template variables in the replacement string
-			replacement = replacePlaceholders(replacement, ports, agentID, agentName, worktreePath)
+	return nil
+}
 
-			// Apply regex replacement
-			re, err := regexp.Compile(pattern)
-			if err != nil {
-				return fmt.Errorf("invalid regex pattern '%s': %w", pattern, err)
-			}
+// patchEnvFile applies an env file's regex patches, then its key-based
+// operations, to its contents
+func patchEnvFile(content string, envFile config.EnvFile, worktree Worktree) (string, error) {
+	for _, patch := range envFile.Patches {
+		// Replace template variables in the replacement string
+		replacement := worktree.render(patch.Replace)
 
-			contentStr = re.ReplaceAllString(contentStr, replacement)
+		re, err := regexp.Compile(patch.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid regex pattern '%s': %w", patch.Pattern, err)
 		}
+		content = re.ReplaceAllString(content, replacement)
+	}
 
-		// Write the patched file
-		if err := os.WriteFile(worktreeEnvPath, []byte(contentStr), 0644); err != nil {
-			return fmt.Errorf("failed to write patched env file %s: %w", worktreeEnvPath, err)
+	return applyKeyPatches(content, envFile, worktree.render), nil
+}
+
+// applyKeyPatches applies an env file's set, unset and append_if_missing
+// operations to its parsed keys, keeping comments, ordering and quoting.
+// New keys are appended in sorted order.
+func applyKeyPatches(content string, envFile config.EnvFile, render func(string) string) string {
+	if len(envFile.Set) == 0 && len(envFile.Unset) == 0 && len(envFile.AppendIfMissing) == 0 {
+		return content
+	}
+
+	f := parseDotenv(content)
+	for _, key := range sortedKeys(envFile.Set) {
+		f.Set(key, render(envFile.Set[key]))
+	}
+	for _, key := range envFile.Unset {
+		f.Unset(key)
+	}
+	for _, key := range sortedKeys(envFile.AppendIfMissing) {
+		if !f.Has(key) {
+			f.Append(key, render(envFile.AppendIfMissing[key]))
 		}
 	}
+	return f.String()
+}
 
-	return nil
+// sortedKeys returns a map's keys in order
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Worktree is the agent worktree whose files are patched, with the values
+// its placeholders render to
+type Worktree struct {
+	Path      string
+	Ports     map[string]int
+	AgentID   int
+	AgentName string
+}
+
+// render replaces the template variables in a value with the worktree's
+func (w Worktree) render(value string) string {
+	return replacePlaceholders(value, w.Ports, w.AgentID, w.AgentName, w.Path)
 }
 
 // replacePlaceholders replaces template variables in a string
@@ -0,0 +1,186 @@
+package envpatch
+
+import (
+	"regexp"
+	"strings"
+)
+
+// iniKeyPattern matches a key line: the key, and the separator with the
+// whitespace around it
+var iniKeyPattern = regexp.MustCompile(`^([^=:\s\[;#][^=:]*?)(\s*[=:]\s*)`)
+
+// iniDocument edits an INI file in place, replacing only the lines it
+// changes. A key path is the section name followed by the key, so
+// celery.broker_url is broker_url in [celery]; keys before the first
+// section are addressed by the key alone.
+type iniDocument struct {
+	content  string
+	sections []iniSection
+	entries  []iniEntry
+}
+
+// iniSection is a [section] header
+type iniSection struct {
+	name  string
+	start int
+	end   int // Just past the header's line
+}
+
+// iniEntry is a key line and any indented continuation lines, as offsets
+// into the document
+type iniEntry struct {
+	section    int // Index into sections, or -1 before the first section
+	key        string
+	separator  string
+	lineStart  int
+	lineEnd    int // Just past the last line's newline
+	valueStart int
+	valueEnd   int
+}
+
+// parseINIDocument finds the sections and keys of an INI file
+func parseINIDocument(content string) *iniDocument {
+	d := &iniDocument{content: content}
+	d.parse()
+	return d
+}
+
+// parse (re)builds the sections and entries from the content
+func (d *iniDocument) parse() {
+	d.sections, d.entries = nil, nil
+	section := -1
+
+	for pos := 0; pos < len(d.content); {
+		lineStart := pos
+		pos = nextLine(d.content, pos)
+		line := strings.TrimRight(d.content[lineStart:pos], "\r\n")
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "" || trimmed[0] == ';' || trimmed[0] == '#':
+			continue
+		case trimmed[0] == '[' && strings.Contains(trimmed, "]"):
+			name := strings.TrimSpace(trimmed[1:strings.Index(trimmed, "]")])
+			d.sections = append(d.sections, iniSection{name: name, start: lineStart, end: pos})
+			section = len(d.sections) - 1
+			continue
+		case line[0] == ' ' || line[0] == '\t':
+			// An indented line continues the previous value
+			if n := len(d.entries); n > 0 && d.entries[n-1].lineEnd == lineStart {
+				d.entries[n-1].lineEnd = pos
+				d.entries[n-1].valueEnd = lineStart + len(line)
+			}
+			continue
+		}
+
+		match := iniKeyPattern.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		d.entries = append(d.entries, iniEntry{
+			section:    section,
+			key:        strings.TrimSpace(match[1]),
+			separator:  match[2],
+			lineStart:  lineStart,
+			lineEnd:    pos,
+			valueStart: lineStart + len(match[0]),
+			valueEnd:   lineStart + len(line),
+		})
+	}
+}
+
+// Set replaces a key's value, keeping its quotes, or adds the key to its
+// section, creating the section at the end when there is none
+func (d *iniDocument) Set(path []string, value string) error {
+	section, key := iniSectionName(path)
+	if entry := d.find(section, key); entry != nil {
+		old := d.content[entry.valueStart:entry.valueEnd]
+		if len(old) >= 2 && (old[0] == '"' || old[0] == '\'') && old[len(old)-1] == old[0] {
+			value = old[:1] + value + old[:1]
+		}
+		d.content = d.content[:entry.valueStart] + value + d.content[entry.valueEnd:]
+		d.parse()
+		return nil
+	}
+
+	separator := " = "
+	if len(d.entries) > 0 {
+		separator = d.entries[0].separator
+	}
+	line := strings.TrimRight(key+separator+value, " \t") + "\n"
+
+	index := d.findSection(section)
+	switch {
+	case index >= 0 || section == "":
+		d.content = insertLine(d.content, d.sectionEnd(index), line)
+	case strings.TrimSpace(d.content) == "":
+		d.content = insertLine(d.content, len(d.content), "["+section+"]\n"+line)
+	default:
+		d.content = insertLine(d.content, len(d.content), "\n["+section+"]\n"+line)
+	}
+	d.parse()
+	return nil
+}
+
+// Unset removes a key's lines, if it exists
+func (d *iniDocument) Unset(path []string) error {
+	section, key := iniSectionName(path)
+	if entry := d.find(section, key); entry != nil {
+		d.content = d.content[:entry.lineStart] + d.content[entry.lineEnd:]
+		d.parse()
+	}
+	return nil
+}
+
+// String renders the document
+func (d *iniDocument) String() string {
+	return d.content
+}
+
+// find returns the entry for a key in a section, or nil
+func (d *iniDocument) find(section, key string) *iniEntry {
+	index := d.findSection(section)
+	if index < 0 && section != "" {
+		return nil
+	}
+	for i, entry := range d.entries {
+		if entry.section == index && entry.key == key {
+			return &d.entries[i]
+		}
+	}
+	return nil
+}
+
+// findSection returns the index of the last [section] with name, or -1
+func (d *iniDocument) findSection(name string) int {
+	found := -1
+	for i, section := range d.sections {
+		if name != "" && section.name == name {
+			found = i
+		}
+	}
+	return found
+}
+
+// sectionEnd returns where a new key of a section goes: after its last
+// entry, or after its header. Keys before the first section go before it.
+func (d *iniDocument) sectionEnd(section int) int {
+	end := len(d.content)
+	if section >= 0 {
+		end = d.sections[section].end
+	} else if len(d.sections) > 0 {
+		end = d.sections[0].start
+	}
+
+	for _, entry := range d.entries {
+		if entry.section == section {
+			end = entry.lineEnd
+		}
+	}
+	return end
+}
+
+// iniSectionName splits a key path into its section and key
+func iniSectionName(path []string) (string, string) {
+	return strings.Join(path[:len(path)-1], "."), path[len(path)-1]
+}
@@ -0,0 +1,369 @@
+package envpatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// jsonDocument edits a JSON file in place, replacing only the text of the
+// values it changes, so formatting and any // or /* */ comments are kept
+type jsonDocument struct {
+	content string
+	indent  string // One level of indentation, used for new objects
+}
+
+// jsonMember is one key/value pair of an object, or one element of an
+// array (with an empty key), as offsets into the document
+type jsonMember struct {
+	key        string
+	start      int // The key, or the element
+	valueStart int
+	valueEnd   int
+	comma      int // The comma after the value, or -1
+}
+
+// parseJSONDocument checks that a JSON file holds an object
+func parseJSONDocument(content string) (*jsonDocument, error) {
+	d := &jsonDocument{content: content, indent: jsonIndent(content)}
+	if strings.TrimSpace(content) == "" {
+		d.content = "{}\n"
+	}
+
+	start := d.skipSpace(0)
+	if start >= len(d.content) || d.content[start] != '{' {
+		return nil, fmt.Errorf("the JSON document is not an object")
+	}
+	end, err := d.valueEnd(start)
+	if err != nil {
+		return nil, err
+	}
+	if rest := d.skipSpace(end); rest < len(d.content) {
+		return nil, fmt.Errorf("unexpected text after the JSON object at offset %d", rest)
+	}
+	return d, nil
+}
+
+// jsonIndent returns the indentation of the first indented line, or two
+// spaces
+func jsonIndent(content string) string {
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+	return "  "
+}
+
+// Set replaces a value, or adds it with the objects on its path
+func (d *jsonDocument) Set(path []string, value string) error {
+	pos := d.skipSpace(0)
+	for i, segment := range path {
+		members, err := d.members(pos)
+		if err != nil {
+			return err
+		}
+
+		member, found := jsonFind(d.content[pos], members, segment)
+		if d.content[pos] == '[' && !found {
+			if _, err := arrayIndex(segment, len(members)); err != nil {
+				return err
+			}
+		}
+		if !found {
+			return d.insert(pos, members, path[i:], value)
+		}
+
+		if i == len(path)-1 {
+			old := d.content[member.valueStart:member.valueEnd]
+			if old[0] == '{' || old[0] == '[' {
+				return fmt.Errorf("cannot replace an object or array with a value")
+			}
+			d.replace(member.valueStart, member.valueEnd, jsonValue(value, old[0] == '"'))
+			return nil
+		}
+
+		pos = member.valueStart
+		if c := d.content[pos]; c != '{' && c != '[' {
+			return fmt.Errorf("%s is not an object", strings.Join(path[:i+1], "."))
+		}
+	}
+	return nil
+}
+
+// Unset removes a member from its object, if it exists
+func (d *jsonDocument) Unset(path []string) error {
+	pos := d.skipSpace(0)
+	for i, segment := range path {
+		if d.content[pos] != '{' && (d.content[pos] != '[' || i == len(path)-1) {
+			return fmt.Errorf("%s is not an object", strings.Join(path[:i], "."))
+		}
+		members, err := d.members(pos)
+		if err != nil {
+			return err
+		}
+		member, found := jsonFind(d.content[pos], members, segment)
+		if !found {
+			return nil
+		}
+		if i < len(path)-1 {
+			pos = member.valueStart
+			continue
+		}
+
+		// Take the member out with the comma that separates it from its
+		// neighbours
+		index := 0
+		for members[index].start != member.start {
+			index++
+		}
+		end := member.valueEnd
+		if member.comma >= 0 {
+			end = member.comma + 1
+		}
+		switch {
+		case len(members) == 1:
+			closing := d.skipSpace(end)
+			d.replace(pos+1, closing, "")
+		case index < len(members)-1:
+			d.replace(member.start, members[index+1].start, "")
+		default:
+			d.replace(members[index-1].valueEnd, end, "")
+		}
+	}
+	return nil
+}
+
+// String renders the document
+func (d *jsonDocument) String() string {
+	return d.content
+}
+
+// insert adds the rest of a path as a new member of the object at pos
+func (d *jsonDocument) insert(pos int, members []jsonMember, path []string, value string) error {
+	if d.content[pos] != '{' {
+		return fmt.Errorf("cannot add elements to an array")
+	}
+
+	lineIndent := d.lineIndent(pos)
+	memberIndent := lineIndent + d.indent
+	closing, err := d.valueEnd(pos)
+	if err != nil {
+		return err
+	}
+	closing--
+
+	// Nest the rest of the path as new objects
+	rendered := jsonValue(value, false)
+	for i := len(path) - 1; i > 0; i-- {
+		nested := memberIndent + strings.Repeat(d.indent, i)
+		rendered = fmt.Sprintf("{\n%s%s: %s\n%s}", nested, jsonString(path[i]), rendered, nested[:len(nested)-len(d.indent)])
+	}
+	member := jsonString(path[0]) + ": " + rendered
+
+	if len(members) == 0 {
+		d.replace(pos+1, closing, "\n"+memberIndent+member+"\n"+lineIndent)
+		return nil
+	}
+
+	// Follow the separator of the existing members: a new line for
+	// multi-line objects, a space for objects on one line
+	last := members[len(members)-1]
+	separator := " "
+	if strings.Contains(d.content[pos:members[0].start], "\n") {
+		separator = "\n" + d.lineIndent(members[0].start)
+	}
+
+	insertAt := closing
+	for insertAt > last.valueEnd && strings.ContainsRune(" \t\r\n", rune(d.content[insertAt-1])) {
+		insertAt--
+	}
+	if last.comma >= 0 {
+		d.replace(insertAt, insertAt, separator+member+",")
+	} else {
+		d.replace(insertAt, insertAt, separator+member)
+		d.replace(last.valueEnd, last.valueEnd, ",")
+	}
+	return nil
+}
+
+// members lists the members of the object or elements of the array at pos
+func (d *jsonDocument) members(pos int) ([]jsonMember, error) {
+	var members []jsonMember
+	closing := byte('}')
+	if d.content[pos] == '[' {
+		closing = ']'
+	}
+
+	i := d.skipSpace(pos + 1)
+	for i < len(d.content) && d.content[i] != closing {
+		member := jsonMember{start: i, comma: -1}
+		if closing == '}' {
+			keyEnd, err := d.valueEnd(i)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal([]byte(d.content[i:keyEnd]), &member.key); err != nil {
+				return nil, fmt.Errorf("invalid object key at offset %d", i)
+			}
+			i = d.skipSpace(keyEnd)
+			if i >= len(d.content) || d.content[i] != ':' {
+				return nil, fmt.Errorf("expected ':' at offset %d", i)
+			}
+			i = d.skipSpace(i + 1)
+		}
+
+		member.valueStart = i
+		end, err := d.valueEnd(i)
+		if err != nil {
+			return nil, err
+		}
+		member.valueEnd = end
+		i = d.skipSpace(end)
+		if i < len(d.content) && d.content[i] == ',' {
+			member.comma = i
+			i = d.skipSpace(i + 1)
+		}
+		members = append(members, member)
+	}
+	if i >= len(d.content) {
+		return nil, fmt.Errorf("unterminated object or array at offset %d", pos)
+	}
+	return members, nil
+}
+
+// valueEnd returns the offset just past the value starting at pos
+func (d *jsonDocument) valueEnd(pos int) (int, error) {
+	if pos >= len(d.content) {
+		return 0, fmt.Errorf("unexpected end of JSON")
+	}
+
+	switch c := d.content[pos]; c {
+	case '"':
+		for i := pos + 1; i < len(d.content); i++ {
+			switch d.content[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated string at offset %d", pos)
+	case '{', '[':
+		return d.nestedEnd(pos)
+	default:
+		i := pos
+		for i < len(d.content) && !strings.ContainsRune(" \t\r\n,]}/", rune(d.content[i])) {
+			i++
+		}
+		if i == pos {
+			return 0, fmt.Errorf("unexpected %q at offset %d", c, pos)
+		}
+		return i, nil
+	}
+}
+
+// nestedEnd returns the offset just past the object or array starting at
+// pos
+func (d *jsonDocument) nestedEnd(pos int) (int, error) {
+	depth := 0
+	for i := pos; i < len(d.content); {
+		i = d.skipSpace(i)
+		if i >= len(d.content) {
+			break
+		}
+		switch d.content[i] {
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		case '"':
+			end, err := d.valueEnd(i)
+			if err != nil {
+				return 0, err
+			}
+			i = end
+			continue
+		}
+		i++
+	}
+	return 0, fmt.Errorf("unterminated object or array at offset %d", pos)
+}
+
+// skipSpace returns the offset of the next character that is not
+// whitespace or part of a comment
+func (d *jsonDocument) skipSpace(pos int) int {
+	for pos < len(d.content) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(d.content[pos])):
+			pos++
+		case strings.HasPrefix(d.content[pos:], "//"):
+			end := strings.Index(d.content[pos:], "\n")
+			if end < 0 {
+				return len(d.content)
+			}
+			pos += end + 1
+		case strings.HasPrefix(d.content[pos:], "/*"):
+			end := strings.Index(d.content[pos+2:], "*/")
+			if end < 0 {
+				return len(d.content)
+			}
+			pos += end + 4
+		default:
+			return pos
+		}
+	}
+	return pos
+}
+
+// lineIndent returns the whitespace that starts the line holding pos
+func (d *jsonDocument) lineIndent(pos int) string {
+	start := strings.LastIndex(d.content[:pos], "\n") + 1
+	line := d.content[start:pos]
+	return line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+}
+
+// replace swaps the text between start and end
+func (d *jsonDocument) replace(start, end int, text string) {
+	d.content = d.content[:start] + text + d.content[end:]
+}
+
+// jsonFind returns the member with key, or the array element at an index
+func jsonFind(container byte, members []jsonMember, segment string) (jsonMember, bool) {
+	if container == '[' {
+		index, err := arrayIndex(segment, len(members))
+		if err != nil {
+			return jsonMember{}, false
+		}
+		return members[index], true
+	}
+	for _, member := range members {
+		if member.key == segment {
+			return member, true
+		}
+	}
+	return jsonMember{}, false
+}
+
+// jsonValue renders a value as a string, or as a number or boolean when it
+// reads as one and the value it replaces was not a string
+func jsonValue(value string, wasString bool) string {
+	if !wasString && isLiteral(value) {
+		return value
+	}
+	return jsonString(value)
+}
+
+// jsonString renders a JSON string, leaving <, > and & unescaped
+func jsonString(value string) string {
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	// Encoding a string cannot fail
+	_ = encoder.Encode(value)
+	return strings.TrimSuffix(b.String(), "\n")
+}
@@ -0,0 +1,449 @@
+package envpatch
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bareKeyPattern matches TOML keys that need no quotes
+var bareKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// tomlDocument edits a TOML file in place, replacing only the text of the
+// values it changes, so formatting and comments are kept. Elements of
+// [[array]] tables are addressed by index: servers.1.port is port in the
+// second [[servers]].
+type tomlDocument struct {
+	content string
+	tables  []tomlTable
+	entries []tomlEntry
+	arrays  map[string]int // Element counts of [[array]] tables by path
+}
+
+// tomlTable is a [table] or [[array]] header
+type tomlTable struct {
+	path  []string // With array indexes, so [[servers]] is servers.0, servers.1...
+	start int
+	end   int // Just past the header's line
+}
+
+// tomlEntry is a key = value line, as offsets into the document
+type tomlEntry struct {
+	path       []string // The table's path followed by the key's
+	table      int      // Index into tables, or -1 for the root table
+	lineStart  int
+	lineEnd    int // Just past the line's newline
+	valueStart int
+	valueEnd   int
+}
+
+// parseTOMLDocument finds the tables and key/value lines of a TOML file
+func parseTOMLDocument(content string) (*tomlDocument, error) {
+	d := &tomlDocument{content: content}
+	if err := d.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// parse (re)builds the tables and entries from the content
+func (d *tomlDocument) parse() error {
+	d.tables, d.entries, d.arrays = nil, nil, make(map[string]int)
+	table := -1
+
+	for pos := 0; pos < len(d.content); {
+		lineStart := pos
+		pos = skipInlineSpace(d.content, pos)
+		if pos >= len(d.content) {
+			break
+		}
+
+		var err error
+		switch d.content[pos] {
+		case '\n', '\r', '#':
+			pos = nextLine(d.content, pos)
+		case '[':
+			pos, err = d.parseHeader(lineStart, pos)
+			table = len(d.tables) - 1
+		default:
+			pos, err = d.parseEntry(lineStart, pos, table)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseHeader adds the [table] or [[array]] header at pos to the tables,
+// returning the offset of the next line
+func (d *tomlDocument) parseHeader(lineStart, pos int) (int, error) {
+	array := strings.HasPrefix(d.content[pos:], "[[")
+	start := pos + 1
+	if array {
+		start++
+	}
+	end := strings.Index(d.content[start:], "]")
+	if end < 0 {
+		return 0, fmt.Errorf("unterminated table header at offset %d", pos)
+	}
+	path, err := parseTOMLKey(d.content[start : start+end])
+	if err != nil {
+		return 0, err
+	}
+
+	next := nextLine(d.content, start+end)
+	d.tables = append(d.tables, tomlTable{path: d.indexPath(path, array), start: lineStart, end: next})
+	return next, nil
+}
+
+// parseEntry adds the key = value line at pos, in the given table, to the
+// entries, returning the offset of the next line
+func (d *tomlDocument) parseEntry(lineStart, pos, table int) (int, error) {
+	equals := strings.Index(d.content[pos:], "=")
+	if equals < 0 || strings.Contains(d.content[pos:pos+equals], "\n") {
+		return 0, fmt.Errorf("expected key = value at offset %d", pos)
+	}
+	key, err := parseTOMLKey(d.content[pos : pos+equals])
+	if err != nil {
+		return 0, err
+	}
+
+	valueStart := skipInlineSpace(d.content, pos+equals+1)
+	valueEnd, err := tomlValueEnd(d.content, valueStart)
+	if err != nil {
+		return 0, err
+	}
+
+	var path []string
+	if table >= 0 {
+		path = append(path, d.tables[table].path...)
+	}
+	next := nextLine(d.content, valueEnd)
+	d.entries = append(d.entries, tomlEntry{
+		path:       append(path, key...),
+		table:      table,
+		lineStart:  lineStart,
+		lineEnd:    next,
+		valueStart: valueStart,
+		valueEnd:   valueEnd,
+	})
+	return next, nil
+}
+
+// indexPath inserts the index of the current element after each [[array]]
+// on a header's path. A new [[array]] header adds an element.
+func (d *tomlDocument) indexPath(path []string, array bool) []string {
+	var indexed []string
+	for i, segment := range path {
+		indexed = append(indexed, segment)
+		key := strings.Join(indexed, "\x00")
+		if i == len(path)-1 && array {
+			d.arrays[key]++
+		}
+		if n, ok := d.arrays[key]; ok {
+			indexed = append(indexed, strconv.Itoa(n-1))
+		}
+	}
+	return indexed
+}
+
+// checkArrays returns an error if a path names an [[array]] table itself,
+// or goes through one without a valid element index
+func (d *tomlDocument) checkArrays(path []string) error {
+	for k := 1; k <= len(path); k++ {
+		n, ok := d.arrays[strings.Join(path[:k], "\x00")]
+		if !ok {
+			continue
+		}
+		if k == len(path) {
+			return fmt.Errorf("%s is an array of tables", strings.Join(path, "."))
+		}
+		if _, err := arrayIndex(path[k], n); err != nil {
+			return fmt.Errorf("%s is an array of tables: %w", strings.Join(path[:k], "."), err)
+		}
+	}
+	return nil
+}
+
+// Set replaces a value, or adds it to the table that holds the most of its
+// path, creating a [table] for the rest when there is none
+func (d *tomlDocument) Set(path []string, value string) error {
+	if err := d.checkArrays(path); err != nil {
+		return err
+	}
+	if entry := d.find(path); entry != nil {
+		old := d.content[entry.valueStart:entry.valueEnd]
+		if old[0] == '[' || old[0] == '{' || strings.HasPrefix(old, `"""`) || strings.HasPrefix(old, "'''") {
+			return fmt.Errorf("cannot replace an array, inline table or multi-line string")
+		}
+		d.content = d.content[:entry.valueStart] + tomlValue(value, old[0]) + d.content[entry.valueEnd:]
+		return d.parse()
+	}
+
+	for _, entry := range d.entries {
+		if hasPrefix(path, entry.path) {
+			return fmt.Errorf("%s is not a table", strings.Join(entry.path, "."))
+		}
+	}
+
+	// Add the key to the deepest existing [table] on its path
+	for k := len(path) - 1; k > 0; k-- {
+		if table := d.findTable(path[:k]); table >= 0 {
+			d.content = insertLine(d.content, d.tableEnd(table), tomlKey(path[k:])+" = "+tomlValue(value, 0)+"\n")
+			return d.parse()
+		}
+	}
+
+	// Keys of the root table, including tables defined by its dotted keys,
+	// go there; other paths get a new [table] at the end
+	if len(path) == 1 || d.rootDefines(path[:len(path)-1]) {
+		d.content = insertLine(d.content, d.tableEnd(-1), tomlKey(path)+" = "+tomlValue(value, 0)+"\n")
+		return d.parse()
+	}
+
+	header := "[" + tomlKey(path[:len(path)-1]) + "]\n"
+	if strings.TrimSpace(d.content) != "" {
+		header = "\n" + header
+	}
+	d.content = insertLine(d.content, len(d.content), header+tomlKey(path[len(path)-1:])+" = "+tomlValue(value, 0)+"\n")
+	return d.parse()
+}
+
+// Unset removes a key's line, if it exists
+func (d *tomlDocument) Unset(path []string) error {
+	if entry := d.find(path); entry != nil {
+		d.content = d.content[:entry.lineStart] + d.content[entry.lineEnd:]
+		return d.parse()
+	}
+	return nil
+}
+
+// String renders the document
+func (d *tomlDocument) String() string {
+	return d.content
+}
+
+// find returns the entry for a key path, or nil
+func (d *tomlDocument) find(path []string) *tomlEntry {
+	for i, entry := range d.entries {
+		if equalPaths(entry.path, path) {
+			return &d.entries[i]
+		}
+	}
+	return nil
+}
+
+// findTable returns the index of the table with path, or -1
+func (d *tomlDocument) findTable(path []string) int {
+	for i, table := range d.tables {
+		if equalPaths(table.path, path) {
+			return i
+		}
+	}
+	return -1
+}
+
+// rootDefines reports whether root-table dotted keys define the table path
+func (d *tomlDocument) rootDefines(path []string) bool {
+	for _, entry := range d.entries {
+		if entry.table < 0 && len(entry.path) > len(path) && hasPrefix(entry.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// tableEnd returns where a new key of a table goes: after its last entry,
+// or after its header. The root table's keys go before the first header,
+// or at the end of a file without tables.
+func (d *tomlDocument) tableEnd(table int) int {
+	end := len(d.content)
+	if table >= 0 {
+		end = d.tables[table].end
+	} else if len(d.tables) > 0 {
+		end = d.tables[0].start
+	}
+
+	for _, entry := range d.entries {
+		if entry.table == table {
+			end = entry.lineEnd
+		}
+	}
+	return end
+}
+
+// parseTOMLKey splits a possibly dotted and quoted TOML key
+func parseTOMLKey(key string) ([]string, error) {
+	var path []string
+	rest := strings.TrimSpace(key)
+	for {
+		var segment string
+		switch {
+		case strings.HasPrefix(rest, `"`), strings.HasPrefix(rest, "'"):
+			end := strings.IndexByte(rest[1:], rest[0])
+			if end < 0 {
+				return nil, fmt.Errorf("invalid TOML key %q", key)
+			}
+			segment, rest = rest[1:end+1], strings.TrimSpace(rest[end+2:])
+		default:
+			end := strings.IndexByte(rest, '.')
+			if end < 0 {
+				end = len(rest)
+			}
+			segment, rest = strings.TrimSpace(rest[:end]), strings.TrimSpace(rest[end:])
+			if !bareKeyPattern.MatchString(segment) {
+				return nil, fmt.Errorf("invalid TOML key %q", key)
+			}
+		}
+		path = append(path, segment)
+
+		if rest == "" {
+			return path, nil
+		}
+		if rest[0] != '.' {
+			return nil, fmt.Errorf("invalid TOML key %q", key)
+		}
+		rest = strings.TrimSpace(rest[1:])
+	}
+}
+
+// tomlValueEnd returns the offset just past the value starting at pos
+func tomlValueEnd(content string, pos int) (int, error) {
+	if pos >= len(content) {
+		return 0, fmt.Errorf("missing value at end of TOML")
+	}
+	if strings.HasPrefix(content[pos:], `"""`) || strings.HasPrefix(content[pos:], "'''") {
+		return tomlMultilineStringEnd(content, pos)
+	}
+
+	switch content[pos] {
+	case '"', '\'':
+		for i := pos + 1; i < len(content) && content[i] != '\n'; i++ {
+			if content[i] == '\\' && content[pos] == '"' {
+				i++
+			} else if content[i] == content[pos] {
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("unterminated string at offset %d", pos)
+	case '[', '{':
+		return tomlNestedEnd(content, pos)
+	default:
+		end := pos
+		for end < len(content) && !strings.ContainsRune(" \t\r\n#", rune(content[end])) {
+			end++
+		}
+		return end, nil
+	}
+}
+
+// tomlMultilineStringEnd returns the offset just past the multi-line string
+// starting at pos
+func tomlMultilineStringEnd(content string, pos int) (int, error) {
+	quote := content[pos : pos+3]
+	end := strings.Index(content[pos+3:], quote)
+	if end < 0 {
+		return 0, fmt.Errorf("unterminated string at offset %d", pos)
+	}
+	end += pos + 6
+	// Up to two more quotes may end the string's content
+	for end < len(content) && content[end] == quote[0] {
+		end++
+	}
+	return end, nil
+}
+
+// tomlNestedEnd returns the offset just past the array or inline table
+// starting at pos
+func tomlNestedEnd(content string, pos int) (int, error) {
+	depth := 0
+	for i := pos; i < len(content); i++ {
+		switch content[i] {
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+			if depth == 0 {
+				return i + 1, nil
+			}
+		case '#':
+			i = nextLine(content, i) - 1
+		case '"', '\'':
+			end, err := tomlValueEnd(content, i)
+			if err != nil {
+				return 0, err
+			}
+			i = end - 1
+		}
+	}
+	return 0, fmt.Errorf("unterminated array or inline table at offset %d", pos)
+}
+
+// tomlKey renders a key path as a dotted key
+func tomlKey(path []string) string {
+	segments := make([]string, len(path))
+	for i, segment := range path {
+		segments[i] = segment
+		if !bareKeyPattern.MatchString(segment) {
+			segments[i] = tomlString(segment)
+		}
+	}
+	return strings.Join(segments, ".")
+}
+
+// tomlValue renders a value in the quote style of the value it replaces,
+// or as a number or boolean when it reads as one and replaces no string
+func tomlValue(value string, oldQuote byte) string {
+	switch {
+	case oldQuote == '\'' && !strings.ContainsAny(value, "'\n"):
+		return "'" + value + "'"
+	case oldQuote != '"' && oldQuote != '\'' && isLiteral(value):
+		return value
+	default:
+		return tomlString(value)
+	}
+}
+
+// tomlString renders a basic TOML string
+func tomlString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
+
+// skipInlineSpace skips spaces and tabs
+func skipInlineSpace(content string, pos int) int {
+	for pos < len(content) && (content[pos] == ' ' || content[pos] == '\t') {
+		pos++
+	}
+	return pos
+}
+
+// nextLine returns the offset just past the newline ending the line that
+// holds pos
+func nextLine(content string, pos int) int {
+	end := strings.IndexByte(content[pos:], '\n')
+	if end < 0 {
+		return len(content)
+	}
+	return pos + end + 1
+}
+
+// equalPaths reports whether two key paths are the same
+func equalPaths(a, b []string) bool {
+	return len(a) == len(b) && hasPrefix(a, b)
+}
+
+// hasPrefix reports whether path starts with prefix
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,323 @@
+package envpatch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// yamlDocument edits a YAML file in place, replacing only the text of the
+// values it changes, so formatting, blank lines and comments are kept. The
+// node tree gives the line and column of each key and value. Key paths
+// address the file's first document; any later ones are kept as they are.
+type yamlDocument struct {
+	content string
+	lines   []int      // Offset of the start of each line
+	root    *yaml.Node // The first document's mapping, or nil if it is empty
+	indent  int
+}
+
+// parseYAMLDocument parses a YAML file, whose first document must hold a
+// mapping
+func parseYAMLDocument(content string) (*yamlDocument, error) {
+	d := &yamlDocument{content: content, indent: yamlIndent(content)}
+	if err := d.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// parse (re)reads the node tree and line offsets from the content
+func (d *yamlDocument) parse() error {
+	d.lines = []int{0}
+	for i := 0; i < len(d.content); i++ {
+		if d.content[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	// Every document is read, so that errors in later ones are reported
+	var documents []*yaml.Node
+	decoder := yaml.NewDecoder(strings.NewReader(d.content))
+	for {
+		var document yaml.Node
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		documents = append(documents, &document)
+	}
+
+	d.root = nil
+	if len(documents) > 0 && len(documents[0].Content) > 0 {
+		d.root = documents[0].Content[0]
+		if d.root.Kind != yaml.MappingNode {
+			return fmt.Errorf("the YAML document is not a mapping")
+		}
+	}
+	return nil
+}
+
+// yamlIndent returns the indentation of the first indented line, or 2
+func yamlIndent(content string) int {
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || len(trimmed) == len(line) {
+			continue
+		}
+		return len(line) - len(trimmed)
+	}
+	return 2
+}
+
+// Set replaces a scalar, or adds the keys on its path that are missing to
+// the deepest mapping that exists
+func (d *yamlDocument) Set(path []string, value string) error {
+	if d.root == nil {
+		return d.addKeys(nil, path, value)
+	}
+
+	node := d.root
+	for i, segment := range path {
+		last := i == len(path)-1
+
+		if node.Kind == yaml.SequenceNode {
+			index, err := arrayIndex(segment, len(node.Content))
+			if err != nil {
+				return err
+			}
+			if last {
+				return d.replaceScalar(node.Content[index], value)
+			}
+			node = node.Content[index]
+			continue
+		}
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("%s is not a mapping", strings.Join(path[:i], "."))
+		}
+
+		_, child := yamlMapEntry(node, segment)
+		if child == nil {
+			return d.addKeys(node, path[i:], value)
+		}
+		if last {
+			return d.replaceScalar(child, value)
+		}
+		node = child
+	}
+	return nil
+}
+
+// Unset removes a key and its value's lines, if it exists
+func (d *yamlDocument) Unset(path []string) error {
+	node := d.root
+	for i, segment := range path[:len(path)-1] {
+		if node == nil {
+			return nil
+		}
+		switch node.Kind {
+		case yaml.MappingNode:
+			_, node = yamlMapEntry(node, segment)
+		case yaml.SequenceNode:
+			index, err := arrayIndex(segment, len(node.Content))
+			if err != nil {
+				return err
+			}
+			node = node.Content[index]
+		default:
+			return fmt.Errorf("%s is not a mapping", strings.Join(path[:i], "."))
+		}
+	}
+	if node == nil {
+		return nil
+	}
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s is not a mapping", strings.Join(path[:len(path)-1], "."))
+	}
+
+	key, value := yamlMapEntry(node, path[len(path)-1])
+	if key == nil {
+		return nil
+	}
+	if node.Style&yaml.FlowStyle != 0 {
+		return fmt.Errorf("cannot remove a key from a flow mapping")
+	}
+
+	lineStart := d.lines[key.Line-1]
+	keyStart := d.offset(key.Line, key.Column)
+	if strings.TrimLeft(d.content[lineStart:keyStart], " ") != "" {
+		return fmt.Errorf("cannot remove the first key of a sequence item")
+	}
+	end := yamlBlockEnd(d.content, keyStart, key.Column-1, value.Kind == yaml.SequenceNode)
+	d.content = d.content[:lineStart] + d.content[end:]
+	return d.parse()
+}
+
+// String renders the document
+func (d *yamlDocument) String() string {
+	return d.content
+}
+
+// replaceScalar replaces the text of a scalar. Anchors and tags before it
+// are kept.
+func (d *yamlDocument) replaceScalar(node *yaml.Node, value string) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("cannot replace a mapping or sequence with a value")
+	}
+
+	start := d.offset(node.Line, node.Column)
+	for start < len(d.content) && (d.content[start] == '&' || d.content[start] == '!') {
+		for start < len(d.content) && !strings.ContainsRune(" \t\r\n", rune(d.content[start])) {
+			start++
+		}
+		start = skipInlineSpace(d.content, start)
+	}
+
+	// A key without a value holds an empty null just after its colon
+	if node.Tag == "!!null" && node.Value == "" {
+		d.content = d.content[:start] + " " + yamlValue(value, node) + d.content[start:]
+		return d.parse()
+	}
+
+	end, err := yamlScalarEnd(d.content, start, node)
+	if err != nil {
+		return err
+	}
+	d.content = d.content[:start] + yamlValue(value, node) + d.content[end:]
+	return d.parse()
+}
+
+// addKeys adds the keys of path, nested, after the last entry of a mapping,
+// or at the end of the file when it has none
+func (d *yamlDocument) addKeys(mapping *yaml.Node, path []string, value string) error {
+	column, pos := 0, len(d.content)
+	if mapping != nil {
+		if mapping.Style&yaml.FlowStyle != 0 {
+			return fmt.Errorf("cannot add keys to a flow mapping")
+		}
+		key, last := mapping.Content[len(mapping.Content)-2], mapping.Content[len(mapping.Content)-1]
+		column = key.Column - 1
+		pos = yamlBlockEnd(d.content, d.offset(key.Line, key.Column), column, last.Kind == yaml.SequenceNode)
+	}
+
+	var lines strings.Builder
+	for i, segment := range path {
+		lines.WriteString(strings.Repeat(" ", column+i*d.indent) + yamlKey(segment) + ":")
+		if i == len(path)-1 {
+			lines.WriteString(" " + yamlValue(value, nil))
+		}
+		lines.WriteString("\n")
+	}
+	d.content = insertLine(d.content, pos, lines.String())
+	return d.parse()
+}
+
+// offset converts a node's 1-based line and column, counted in characters,
+// into an offset into the content
+func (d *yamlDocument) offset(line, column int) int {
+	pos := d.lines[line-1]
+	for i := 1; i < column && pos < len(d.content); i++ {
+		_, size := utf8.DecodeRuneInString(d.content[pos:])
+		pos += size
+	}
+	return pos
+}
+
+// yamlMapEntry returns the key and value nodes of key in a mapping, or nils
+func yamlMapEntry(mapping *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i], mapping.Content[i+1]
+		}
+	}
+	return nil, nil
+}
+
+// yamlBlockEnd returns the offset just past the last line of the block
+// starting on the line of pos: the lines after it indented deeper than
+// column, and its sequence items at column when seq is set. Blank and
+// comment lines after the block are not part of it.
+func yamlBlockEnd(content string, pos, column int, seq bool) int {
+	end := nextLine(content, pos)
+	for next := end; next < len(content); {
+		lineEnd := nextLine(content, next)
+		line := strings.TrimRight(content[next:lineEnd], "\r\n")
+		trimmed := strings.TrimLeft(line, " ")
+		indent := len(line) - len(trimmed)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#':
+		case indent > column, seq && indent == column && (trimmed == "-" || strings.HasPrefix(trimmed, "- ")):
+			end = lineEnd
+		default:
+			return end
+		}
+		next = lineEnd
+	}
+	return end
+}
+
+// yamlScalarEnd returns the offset just past the text of a one-line scalar
+// starting at pos
+func yamlScalarEnd(content string, pos int, node *yaml.Node) (int, error) {
+	switch {
+	case node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0:
+		return 0, fmt.Errorf("cannot replace a block scalar")
+	case node.Style&(yaml.DoubleQuotedStyle|yaml.SingleQuotedStyle) != 0:
+		quote := content[pos]
+		for i := pos + 1; i < len(content) && content[i] != '\n'; i++ {
+			switch {
+			case content[i] == '\\' && quote == '"':
+				i++
+			case content[i] == quote && quote == '\'' && i+1 < len(content) && content[i+1] == '\'':
+				i++
+			case content[i] == quote:
+				return i + 1, nil
+			}
+		}
+		return 0, fmt.Errorf("cannot replace a multi-line string")
+	default:
+		if !strings.HasPrefix(content[pos:], node.Value) {
+			return 0, fmt.Errorf("cannot replace a multi-line string")
+		}
+		return pos + len(node.Value), nil
+	}
+}
+
+// yamlKey renders a mapping key, quoted unless it is a bare key
+func yamlKey(key string) string {
+	if bareKeyPattern.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+// yamlValue renders a value in the quoting style of the scalar it
+// replaces. A string stays a string; other values are typed by what they
+// look like.
+func yamlValue(value string, old *yaml.Node) string {
+	switch {
+	case old != nil && old.Style&yaml.DoubleQuotedStyle != 0:
+		return strconv.Quote(value)
+	case old != nil && old.Style&yaml.SingleQuotedStyle != 0 && !strings.Contains(value, "\n"):
+		return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+	case (old == nil || old.Tag != "!!str") && isLiteral(value):
+		return value
+	}
+
+	// The encoder quotes strings that would otherwise read as another type
+	encoded, err := yaml.Marshal(value)
+	plain := strings.TrimSuffix(string(encoded), "\n")
+	if err != nil || strings.Contains(plain, "\n") {
+		return strconv.Quote(value)
+	}
+	return plain
+}
@@ -0,0 +1,333 @@
+package fixtures
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+	"github.com/joshpurvis/agentenv/internal/database"
+)
+
+// ManifestFile lists the builds in the fixtures directory
+const ManifestFile = "manifest.json"
+
+// Manifest records the latest build of each recipe
+type Manifest struct {
+	Fixtures map[string]Build `json:"fixtures"`
+}
+
+// Build describes one built fixture file
+type Build struct {
+	Version    int      `json:"version"`
+	File       string   `json:"file"` // Relative to the fixtures directory
+	BuiltAt    string   `json:"built_at"`
+	Rows       int      `json:"rows"`
+	Tables     []string `json:"tables"`
+	RecipeHash string   `json:"recipe_hash"`
+	SchemaHash string   `json:"schema_hash"` // Fingerprint of the tables when built
+}
+
+// Status tells whether a recipe's latest build can still be loaded as is
+type Status struct {
+	Name   string
+	Build  *Build // Latest build, nil if never built
+	Stale  bool
+	Reason string // Why the build is stale
+}
+
+// LoadManifest reads the manifest of a fixtures directory, or returns an
+// empty one if nothing was built yet
+func LoadManifest(dir string) (*Manifest, error) {
+	manifest := &Manifest{Fixtures: make(map[string]Build)}
+
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixtures manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse fixtures manifest: %w", err)
+	}
+	if manifest.Fixtures == nil {
+		manifest.Fixtures = make(map[string]Build)
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest into a fixtures directory
+func (m *Manifest) Save(dir string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ManifestFile), append(data, '\n'), 0644)
+}
+
+// Files returns the latest file of each named recipe, in the order given
+func (m *Manifest) Files(dir string, names []string) ([]string, error) {
+	files := make([]string, len(names))
+	for i, name := range names {
+		build, ok := m.Fixtures[name]
+		if !ok {
+			return nil, fmt.Errorf("fixture %q has not been built (run 'agentenv fixtures build %s')", name, name)
+		}
+		files[i] = filepath.Join(dir, build.File)
+	}
+	return files, nil
+}
+
+// RecipeNames returns the recipes of the configuration in name order
+func RecipeNames(cfg *config.Config) []string {
+	names := make([]string, 0, len(cfg.Database.Fixtures))
+	for name := range cfg.Database.Fixtures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Roots returns the rows a recipe exports
+func Roots(recipe config.FixtureRecipe) (database.Roots, error) {
+	table, err := database.ParseTableName(recipe.Table)
+	if err != nil {
+		return database.Roots{}, err
+	}
+
+	roots := database.Roots{Table: table, Where: recipe.Where, Limit: recipe.Limit}
+	for _, id := range recipe.IDs {
+		key, err := database.ParseKey(id)
+		if err != nil {
+			return roots, err
+		}
+		roots.Keys = append(roots.Keys, key)
+	}
+
+	if len(roots.Keys) == 0 && roots.Where == "" && roots.Limit == 0 {
+		return roots, fmt.Errorf("recipe selects no rows (set ids, where or limit)")
+	}
+	return roots, nil
+}
+
+// Options returns how far a recipe follows foreign keys
+func Options(recipe config.FixtureRecipe) database.ExportOptions {
+	return database.ExportOptions{
+		IncludeChildren: recipe.Children,
+		MaxDepth:        recipe.Depth,
+		MaxParentDepth:  recipe.ParentDepth,
+		MaxChildDepth:   recipe.ChildDepth,
+		IncludeTables:   recipe.IncludeTables,
+		ExcludeTables:   recipe.ExcludeTables,
+		MaxRows:         recipe.MaxRows,
+	}
+}
+
+// ExportConfig returns the masking settings of a recipe: the project's
+// rules with the recipe's own rules added
+func ExportConfig(cfg *config.Config, recipe config.FixtureRecipe) config.ExportConfig {
+	export := config.ExportConfig{Salt: cfg.Database.Export.Salt, Masking: make(map[string]config.MaskRule)}
+	for column, rule := range cfg.Database.Export.Masking {
+		export.Masking[column] = rule
+	}
+	for column, rule := range recipe.Masking {
+		export.Masking[column] = rule
+	}
+	return export
+}
+
+// RecipeHash identifies a recipe's settings, including the salt and the
+// masking rules that apply to it
+func RecipeHash(cfg *config.Config, recipe config.FixtureRecipe) string {
+	recipe.Masking = ExportConfig(cfg, recipe).Masking
+	data, _ := json.Marshal(struct {
+		Recipe config.FixtureRecipe
+		Salt   string
+	}{recipe, cfg.Database.Export.Salt})
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// Builder builds recipes from one database into a fixtures directory
+type Builder struct {
+	cfg      *config.Config
+	dir      string
+	exporter *database.Exporter
+	manifest *Manifest
+}
+
+// NewBuilder connects to the database recipes are built from
+func NewBuilder(ctx context.Context, cfg *config.Config, dbURL string) (*Builder, error) {
+	manifest, err := LoadManifest(cfg.Database.FixturesDir)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := database.NewExporter(ctx, cfg.Database.Type, dbURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Builder{cfg: cfg, dir: cfg.Database.FixturesDir, exporter: exporter, manifest: manifest}, nil
+}
+
+// Close closes the database connection
+func (b *Builder) Close() error {
+	return b.exporter.Close()
+}
+
+// Build exports a recipe into the next version of its file and records it
+// in the manifest
+func (b *Builder) Build(ctx context.Context, name string) (Build, error) {
+	recipe, ok := b.cfg.Database.Fixtures[name]
+	if !ok {
+		return Build{}, fmt.Errorf("no fixture recipe named %q in database.fixtures", name)
+	}
+
+	build := Build{
+		Version:    b.manifest.Fixtures[name].Version + 1,
+		BuiltAt:    time.Now().Format(time.RFC3339),
+		RecipeHash: RecipeHash(b.cfg, recipe),
+	}
+	build.File = fmt.Sprintf("%s.v%d.sql", name, build.Version)
+
+	if err := b.export(ctx, recipe, build.File); err != nil {
+		os.Remove(filepath.Join(b.dir, build.File))
+		return Build{}, fmt.Errorf("fixture %s: %w", name, err)
+	}
+
+	tables := b.exporter.Tables()
+	for _, table := range tables {
+		build.Rows += b.exporter.Rows(table)
+		build.Tables = append(build.Tables, table.String())
+	}
+
+	var err error
+	if build.SchemaHash, err = b.exporter.SchemaFingerprint(tables); err != nil {
+		return Build{}, err
+	}
+
+	b.manifest.Fixtures[name] = build
+	if err := b.manifest.Save(b.dir); err != nil {
+		return Build{}, fmt.Errorf("failed to save fixtures manifest: %w", err)
+	}
+	return build, nil
+}
+
+// export streams a recipe's masked records into a fixture file as SQL
+func (b *Builder) export(ctx context.Context, recipe config.FixtureRecipe, file string) error {
+	roots, err := Roots(recipe)
+	if err != nil {
+		return err
+	}
+
+	masker, err := database.NewMasker(ExportConfig(b.cfg, recipe))
+	if err != nil {
+		return fmt.Errorf("invalid masking: %w", err)
+	}
+
+	dialect, err := database.DialectFor(b.cfg.Database.Type)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create fixtures directory: %w", err)
+	}
+	f, err := os.Create(filepath.Join(b.dir, file))
+	if err != nil {
+		return fmt.Errorf("failed to create fixture file: %w", err)
+	}
+	defer f.Close()
+
+	writer, err := database.NewRecordWriter(database.OutputOptions{
+		Format:  database.FormatSQLInsert,
+		Dialect: dialect,
+		Masker:  masker,
+		Writer:  f,
+		Steps:   b.exporter.LoadSteps(),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := b.exporter.ExportTo(ctx, roots, Options(recipe), b.exporter.FollowMasking(masker, writer)); err != nil {
+		writer.Abort()
+		return err
+	}
+	if len(b.exporter.Tables()) == 0 {
+		writer.Abort()
+		return fmt.Errorf("no records found")
+	}
+	return writer.Close()
+}
+
+// Status compares a recipe's latest build with the recipe and the current
+// schema of the tables it exported
+func (b *Builder) Status(name string) (Status, error) {
+	status := Status{Name: name}
+
+	build, ok := b.manifest.Fixtures[name]
+	if !ok {
+		status.Stale, status.Reason = true, "not built"
+		return status, nil
+	}
+	status.Build = &build
+
+	recipe, ok := b.cfg.Database.Fixtures[name]
+	if !ok {
+		status.Stale, status.Reason = true, "recipe removed from database.fixtures"
+		return status, nil
+	}
+	if build.RecipeHash != RecipeHash(b.cfg, recipe) {
+		status.Stale, status.Reason = true, "recipe changed"
+		return status, nil
+	}
+
+	tables := make([]database.TableName, len(build.Tables))
+	for i, table := range build.Tables {
+		parsed, err := database.ParseTableName(table)
+		if err != nil {
+			return status, err
+		}
+		tables[i] = parsed
+	}
+
+	fingerprint, err := b.exporter.SchemaFingerprint(tables)
+	if err != nil {
+		status.Stale, status.Reason = true, fmt.Sprintf("schema changed (%v)", err)
+		return status, nil
+	}
+	if fingerprint != build.SchemaHash {
+		status.Stale, status.Reason = true, "schema changed"
+	}
+	return status, nil
+}
+
+// Recipes returns the recipes of the builder's configuration in name order
+func (b *Builder) Recipes() []string {
+	return RecipeNames(b.cfg)
+}
+
+// Names returns the recipes of the configuration and of the manifest, so
+// that removed recipes are reported too
+func (b *Builder) Names() []string {
+	names := RecipeNames(b.cfg)
+	for name := range b.manifest.Fixtures {
+		if _, ok := b.cfg.Database.Fixtures[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
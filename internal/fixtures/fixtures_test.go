@@ -0,0 +1,136 @@
+package fixtures
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joshpurvis/agentenv/internal/config"
+)
+
+// newTestBuilder creates a users/posts SQLite database and a builder with
+// one "smoke" recipe exporting post 100
+func newTestBuilder(t *testing.T) (*Builder, *sql.DB) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("failed to open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`
+		CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL);
+		CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users, title TEXT);
+		INSERT INTO users VALUES (1, 'test@example.com');
+		INSERT INTO posts VALUES (100, 1, 'Test Post');
+	`)
+	if err != nil {
+		t.Fatalf("failed to create fixture: %v", err)
+	}
+
+	cfg := &config.Config{Database: config.DatabaseConfig{
+		Type:        "sqlite",
+		FixturesDir: filepath.Join(dir, "fixtures"),
+		Fixtures: map[string]config.FixtureRecipe{
+			"smoke": {Table: "posts", IDs: []string{"100"}, Masking: map[string]config.MaskRule{"users.email": {Strategy: "fake_email"}}},
+		},
+	}}
+
+	builder, err := NewBuilder(context.Background(), cfg, path)
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %v", err)
+	}
+	t.Cleanup(func() { builder.Close() })
+	return builder, db
+}
+
+func TestBuild(t *testing.T) {
+	builder, _ := newTestBuilder(t)
+
+	for version := 1; version <= 2; version++ {
+		build, err := builder.Build(context.Background(), "smoke")
+		if err != nil {
+			t.Fatalf("Build failed: %v", err)
+		}
+		if build.Version != version || build.Rows != 2 {
+			t.Errorf("Build() = version %d with %d rows, want version %d with 2 rows", build.Version, build.Rows, version)
+		}
+		if _, err := os.Stat(filepath.Join(builder.dir, build.File)); err != nil {
+			t.Errorf("fixture file missing: %v", err)
+		}
+	}
+
+	manifest, err := LoadManifest(builder.dir)
+	if err != nil {
+		t.Fatalf("LoadManifest failed: %v", err)
+	}
+	files, err := manifest.Files(builder.dir, []string{"smoke"})
+	if err != nil {
+		t.Fatalf("Files failed: %v", err)
+	}
+	if filepath.Base(files[0]) != "smoke.v2.sql" {
+		t.Errorf("Files() = %v, want smoke.v2.sql", files)
+	}
+	if _, err := manifest.Files(builder.dir, []string{"billing"}); err == nil {
+		t.Error("Files() of an unbuilt recipe succeeded, want error")
+	}
+
+	if _, err := builder.Build(context.Background(), "billing"); err == nil {
+		t.Error("Build() of an unknown recipe succeeded, want error")
+	}
+}
+
+func TestStatus(t *testing.T) {
+	builder, db := newTestBuilder(t)
+
+	status, err := builder.Status("smoke")
+	if err != nil {
+		t.Fatalf("Status failed: %v", err)
+	}
+	if !status.Stale || status.Reason != "not built" {
+		t.Errorf("Status() before build = %+v, want not built", status)
+	}
+
+	if _, err := builder.Build(context.Background(), "smoke"); err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		change   func()
+		expected string
+	}{
+		{"up to date", func() {}, ""},
+		{"masking changed", func() {
+			recipe := builder.cfg.Database.Fixtures["smoke"]
+			recipe.Masking = nil
+			builder.cfg.Database.Fixtures["smoke"] = recipe
+		}, "recipe changed"},
+		{"schema changed", func() {
+			if _, err := builder.Build(context.Background(), "smoke"); err != nil {
+				t.Fatalf("Build failed: %v", err)
+			}
+			if _, err := db.Exec("ALTER TABLE users ADD COLUMN name TEXT"); err != nil {
+				t.Fatalf("failed to alter table: %v", err)
+			}
+		}, "schema changed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.change()
+			status, err := builder.Status("smoke")
+			if err != nil {
+				t.Fatalf("Status failed: %v", err)
+			}
+			if status.Reason != tt.expected || status.Stale != (tt.expected != "") {
+				t.Errorf("Status() = %+v, want reason %q", status, tt.expected)
+			}
+		})
+	}
+}
@@ -212,6 +212,25 @@ func GetRepoRoot(path string) (string, error) {
 	return strings.TrimSpace(string(output)), nil
 }
 
+// GetMainRepoRoot returns the root directory of the main working tree of
+// the repository, also when path is inside a linked worktree
+func GetMainRepoRoot(path string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-common-dir")
+	cmd.Dir = path
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get main repo root: %w", err)
+	}
+
+	// The common directory is relative to path unless it is elsewhere
+	gitDir := strings.TrimSpace(string(output))
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(path, gitDir)
+	}
+	return filepath.Abs(filepath.Dir(gitDir))
+}
+
 // IsGitRepo checks if the given path is inside a git repository
 func IsGitRepo(path string) bool {
 	cmd := exec.Command("git", "rev-parse", "--git-dir")
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 	"sort"
 	"time"
 )
@@ -29,6 +30,20 @@ type Agent struct {
 	CreatedAt             time.Time      `json:"created_at"`
 	DockerComposeOverride string         `json:"docker_compose_override"`
 	PID                   int            `json:"pid,omitempty"`
+	Checkpoints           []Checkpoint   `json:"checkpoints,omitempty"`
+}
+
+// Checkpoint is a saved copy of an agent's database
+type Checkpoint struct {
+	Name      string    `json:"name"`
+	Location  string    `json:"location"` // Database name, or file for SQLite
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Dir returns the absolute path of the .agentenv directory that holds the
+// registry, where other agent state is kept next to it
+func Dir() (string, error) {
+	return filepath.Abs(filepath.Dir(registryFile))
 }
 
 // LoadRegistry loads the agent registry from the current directory
@@ -158,3 +173,38 @@ func (r *Registry) ListAgentsBySlot() []*Agent {
 
 	return agents
 }
+
+// GetCheckpoint returns a checkpoint by name, or the latest one if name is empty
+func (a *Agent) GetCheckpoint(name string) (*Checkpoint, error) {
+	if name == "" {
+		if len(a.Checkpoints) == 0 {
+			return nil, fmt.Errorf("agent %s has no checkpoints", a.Name)
+		}
+		return &a.Checkpoints[len(a.Checkpoints)-1], nil
+	}
+
+	for i := range a.Checkpoints {
+		if a.Checkpoints[i].Name == name {
+			return &a.Checkpoints[i], nil
+		}
+	}
+	return nil, fmt.Errorf("checkpoint %s not found for agent %s", name, a.Name)
+}
+
+// SetCheckpoint records a checkpoint as the latest, replacing any earlier
+// checkpoint of the same name
+func (a *Agent) SetCheckpoint(checkpoint Checkpoint) {
+	a.RemoveCheckpoint(checkpoint.Name)
+	a.Checkpoints = append(a.Checkpoints, checkpoint)
+}
+
+// RemoveCheckpoint forgets a checkpoint
+func (a *Agent) RemoveCheckpoint(name string) {
+	kept := a.Checkpoints[:0]
+	for _, checkpoint := range a.Checkpoints {
+		if checkpoint.Name != name {
+			kept = append(kept, checkpoint)
+		}
+	}
+	a.Checkpoints = kept
+}